@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// captureLog sends the default logger to the returned buffer as JSON for
+// the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	return &buf
+}
+
+func TestAccessLog(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adguard/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("adguardhome_up 1\n"))
+	})
+	mux.HandleFunc("/adguard/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	h := (&AccessLog{Exclude: []string{"/healthz"}, Prefix: "/adguard"}).Wrap(mux)
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		bytes  int
+	}{
+		{"/adguard/metrics", http.StatusOK, len("adguardhome_up 1\n")},
+		{"/adguard/missing", http.StatusNotFound, len("404 page not found\n")},
+	} {
+		buf := captureLog(t)
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.RemoteAddr = "192.168.1.10:51234"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		var line struct {
+			Msg, Method, Path, Remote string
+			Status, Bytes             int
+			Duration                  int64
+		}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%v: %v in %q", tc.path, err, buf)
+		}
+		if line.Msg != "request" || line.Method != http.MethodGet || line.Path != tc.path ||
+			line.Status != tc.status || line.Bytes != tc.bytes || line.Remote != req.RemoteAddr {
+			t.Errorf("%v: got %+v", tc.path, line)
+		}
+	}
+
+	// excluded routes match without the prefix
+	buf := captureLog(t)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/adguard/healthz", nil))
+	if buf.Len() != 0 {
+		t.Errorf("excluded route logged: %s", buf)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// adguardStub is an AdGuard Home API answering with canned responses, or an
+// http.HandlerFunc for responses depending on the request. It counts the
+// requests per path and answers 404 to paths it has nothing for.
+type adguardStub struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]any
+	statuses  map[string]int
+	requests  map[string]int
+	auth      []string
+}
+
+// newAdGuardStub starts a stub with the responses of a typical instance.
+func newAdGuardStub(t *testing.T) *adguardStub {
+	t.Helper()
+
+	s := &adguardStub{
+		responses: map[string]any{
+			"/control/stats": map[string]any{
+				"time_units":                "hours",
+				"num_dns_queries":           100,
+				"num_blocked_filtering":     10,
+				"num_replaced_safebrowsing": 1,
+				"num_replaced_safesearch":   2,
+				"num_replaced_parental":     0,
+				"avg_processing_time":       0.012,
+				"top_upstreams_avg_time":    []map[string]float64{{"tls://1.1.1.1": 0.02}},
+				"top_upstreams_responses":   []map[string]int{{"tls://1.1.1.1": 50}, {"8.8.8.8": 0}},
+				"top_queried_domains":       []map[string]int{{"example.org": 5}, {"a.b.example.co.uk": 3}},
+				"top_blocked_domains":       []map[string]int{{"ads.example.com": 4}},
+				"top_clients":               []map[string]int{{"192.168.1.2": 7}},
+			},
+			"/control/status": map[string]any{
+				"version":            "v0.107.52",
+				"start_time":         1792137600000,
+				"protection_enabled": true,
+				"running":            true,
+				"dns_port":           53,
+				"http_port":          3000,
+			},
+			"/control/querylog/config": map[string]any{"enabled": true, "interval": 86400000},
+			"/control/querylog":        map[string]any{"data": []any{}},
+		},
+		statuses: map[string]int{},
+		requests: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *adguardStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+	response, ok := s.responses[r.URL.Path]
+	status := s.statuses[r.URL.Path]
+	s.mu.Unlock()
+
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if h, ok := response.(http.HandlerFunc); ok {
+		h(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// set replaces the response to path.
+func (s *adguardStub) set(path string, response any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[path] = response
+}
+
+// fail answers path with status, 0 answers it again.
+func (s *adguardStub) fail(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statuses[path] = status
+}
+
+// count returns the number of requests to path so far.
+func (s *adguardStub) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// authorization returns the Authorization header of the last request.
+func (s *adguardStub) authorization() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.auth) == 0 {
+		return ""
+	}
+	return s.auth[len(s.auth)-1]
+}
+
+// endpoint returns the host:port of the stub, as an Exporter takes it.
+func (s *adguardStub) endpoint() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// collectorFunc is an unchecked collector of the metrics collect sends.
+type collectorFunc func(chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) { f(ch) }
+
+// collectorOf returns the metrics of c named name as a collector.
+func collectorOf(c prometheus.Collector, name string) prometheus.Collector {
+	return collectorFunc(func(ch chan<- prometheus.Metric) {
+		for _, m := range collectMetrics(c.Collect) {
+			if metricInfos[m.Desc()].Name == name {
+				ch <- m
+			}
+		}
+	})
+}
+
+// exposition returns the text exposition of g.
+func exposition(t *testing.T, g prometheus.Gatherer) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("gathering: got %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for range 500 {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v", what)
+}
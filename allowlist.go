@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allowListLogInterval limits how often rejected requests are logged.
+const allowListLogInterval = time.Minute
+
+// AllowList only lets requests from the given networks through.
+type AllowList struct {
+	Prefixes []netip.Prefix
+
+	// TrustXForwardedFor takes the client address from the last
+	// X-Forwarded-For entry, the one added by the reverse proxy.
+	TrustXForwardedFor bool
+
+	mu         sync.Mutex
+	lastLog    time.Time
+	suppressed int
+}
+
+// ParseAllowList parses CIDRs like 192.168.10.0/24 or fd00::/8.
+func ParseAllowList(cidrs []string) (*AllowList, error) {
+	a := &AllowList{}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		a.Prefixes = append(a.Prefixes, prefix.Masked())
+	}
+
+	return a, nil
+}
+
+func (a *AllowList) allowed(r *http.Request) (netip.Addr, bool) {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); a.TrustXForwardedFor && forwarded != "" {
+		entries := strings.Split(forwarded, ",")
+		address = strings.TrimSpace(entries[len(entries)-1])
+	} else if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		// peers on a Unix socket have no address, the permissions of the
+		// socket restrict them
+		return netip.Addr{}, true
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range a.Prefixes {
+		if prefix.Contains(addr) {
+			return addr, true
+		}
+	}
+
+	return addr, false
+}
+
+// Wrap returns next answering 403 to requests from other networks.
+func (a *AllowList) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := a.allowed(r)
+		if !ok {
+			a.logRejected(addr, time.Now())
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *AllowList) logRejected(addr netip.Addr, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Sub(a.lastLog) < allowListLogInterval {
+		a.suppressed++
+		return
+	}
+
+	msg := fmt.Sprintf("Rejected request from %v", addr)
+	if a.suppressed > 0 {
+		msg = fmt.Sprintf("%v (%d more since the last message)", msg, a.suppressed)
+	}
+	slog.Warn(msg)
+	a.lastLog = now
+	a.suppressed = 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowList(t *testing.T) {
+	a, err := ParseAllowList([]string{"192.168.10.0/24", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name, remote, forwarded string
+		trust                   bool
+		want                    int
+	}{
+		{"allowed", "192.168.10.7:51234", "", false, http.StatusOK},
+		{"allowed IPv6", "[fd00::1]:51234", "", false, http.StatusOK},
+		{"mapped IPv4", "[::ffff:192.168.10.7]:51234", "", false, http.StatusOK},
+		{"denied", "10.0.0.1:51234", "", false, http.StatusForbidden},
+		{"denied IPv6", "[2001:db8::1]:51234", "", false, http.StatusForbidden},
+		{"untrusted forwarded", "10.0.0.1:51234", "192.168.10.7", false, http.StatusForbidden},
+		{"forwarded", "10.0.0.1:51234", "203.0.113.9, 192.168.10.7", true, http.StatusOK},
+		{"forwarded denied", "192.168.10.7:51234", "192.168.10.8, 10.0.0.1", true, http.StatusForbidden},
+		{"no address", "@", "", false, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a.TrustXForwardedFor = tc.trust
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = tc.remote
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("got %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestAllowListUnixSocket(t *testing.T) {
+	a, err := ParseAllowList([]string{"192.168.10.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "exporter.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &httptest.Server{
+		Listener: l,
+		Config:   &http.Server{Handler: a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))},
+	}
+	ts.Start()
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	for _, tc := range []struct {
+		forwarded string
+		want      int
+	}{
+		{"", http.StatusOK},
+		// a proxy on the socket still names the client
+		{"10.0.0.1", http.StatusForbidden},
+	} {
+		a.TrustXForwardedFor = tc.forwarded != ""
+		req, _ := http.NewRequest(http.MethodGet, "http://exporter/metrics", nil)
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("forwarded %q: got %d, want %d", tc.forwarded, res.StatusCode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/subtle"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// dummyHash is compared against for unknown users, so the response time
+// doesn't tell whether a username exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// BasicAuth protects handlers with usernames and bcrypt password hashes.
+type BasicAuth struct {
+	Users map[string][]byte
+}
+
+// Wrap returns next behind the credential check, answering 401 with a
+// WWW-Authenticate challenge otherwise.
+func (a *BasicAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+
+		hash, known := dummyHash, false
+		for u, h := range a.Users {
+			if subtle.ConstantTimeCompare([]byte(username), []byte(u)) == 1 {
+				hash, known = h, true
+			}
+		}
+		// always compare, so the response time doesn't tell which was wrong
+		passwordOK := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+
+		if !ok || !known || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="adguard-exporter", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BearerAuth protects handlers with a shared token.
+type BearerAuth struct {
+	Token string
+}
+
+// Wrap returns next behind the token check, answering 401 otherwise.
+func (a *BearerAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="adguard-exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReloadableAuth protects handlers with Basic auth users that can be replaced
+// at runtime. Without users requests pass through.
+type ReloadableAuth struct {
+	current atomic.Pointer[BasicAuth]
+}
+
+// Store replaces the users, nil disables the check.
+func (a *ReloadableAuth) Store(auth *BasicAuth) {
+	a.current.Store(auth)
+}
+
+// Wrap returns next behind the check with the users current at each request.
+func (a *ReloadableAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := a.current.Load(); auth != nil {
+			auth.Wrap(next).ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
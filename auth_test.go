@@ -0,0 +1,117 @@
+package main
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuthFlags(t *testing.T) {
+	stub := newAdGuardStub(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secretpw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := runExporter(t, "-endpoint", stub.URL,
+		"-web.basic-auth-username", "prometheus", "-web.basic-auth-password-hash", string(hash))
+
+	for _, tc := range []struct {
+		name, path    string
+		authorization func(*http.Request)
+		want          int
+	}{
+		{"correct", "/metrics", func(r *http.Request) { r.SetBasicAuth("prometheus", "secretpw") }, http.StatusOK},
+		{"wrong password", "/metrics", func(r *http.Request) { r.SetBasicAuth("prometheus", "wrongpw") }, http.StatusUnauthorized},
+		{"wrong user", "/metrics", func(r *http.Request) { r.SetBasicAuth("grafana", "secretpw") }, http.StatusUnauthorized},
+		{"missing header", "/metrics", func(*http.Request) {}, http.StatusUnauthorized},
+		{"other scheme", "/metrics", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secretpw") }, http.StatusUnauthorized},
+		{"health", "/healthz", func(*http.Request) {}, http.StatusOK},
+		{"readiness", "/readyz", func(*http.Request) {}, http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, base+tc.path, nil)
+		tc.authorization(req)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.name, res.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%v: no WWW-Authenticate challenge", tc.name)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-web.basic-auth-username", "prometheus"},
+		{"-web.basic-auth-username", "prometheus", "-web.basic-auth-password-hash", "secretpw"},
+	} {
+		if out, err := exporterOutput(t, append([]string{"-endpoint", stub.URL}, args...)...); err == nil {
+			t.Errorf("%v: got no error:\n%s", args, out)
+		}
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-metrics-token", "secrettoken")
+
+	for _, tc := range []struct {
+		name, path, authorization string
+		want                      int
+	}{
+		{"valid", "/metrics", "Bearer secrettoken", http.StatusOK},
+		{"invalid", "/metrics", "Bearer wrongtoken", http.StatusUnauthorized},
+		{"prefix of the token", "/metrics", "Bearer secret", http.StatusUnauthorized},
+		{"missing", "/metrics", "", http.StatusUnauthorized},
+		{"basic", "/metrics", basicAuthHeader("prometheus", "secrettoken"), http.StatusUnauthorized},
+		{"health", "/healthz", "", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, base+tc.path, nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.name, res.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") != `Bearer realm="adguard-exporter"` {
+			t.Errorf("%v: got challenge %q", tc.name, res.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestNoAuth(t *testing.T) {
+	stub := newAdGuardStub(t)
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		noAuth bool
+		want   string
+	}{
+		{"basic", "", false, basicAuthHeader("admin", "secretpw")},
+		{"bearer", "secrettoken", false, "Bearer secrettoken"},
+		{"no auth", "", true, ""},
+		{"no auth with a token", "secrettoken", true, ""},
+	} {
+		e := NewExporter(stub.endpoint(), "admin", "secretpw")
+		e.Token, e.NoAuth = tc.token, tc.noAuth
+		if _, err := e.getRaw(t.Context(), "/control/stats"); err != nil {
+			t.Fatal(err)
+		}
+		if got := stub.authorization(); got != tc.want {
+			t.Errorf("%v: got Authorization %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if out, err := exporterOutput(t, "-endpoint", stub.URL, "-no-auth", "-auth.session"); err == nil || !strings.Contains(out, "-no-auth can't be combined with -auth.session") {
+		t.Errorf("got %v:\n%s\nwant -no-auth rejected with -auth.session", err, out)
+	}
+}
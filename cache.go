@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+var scrapesCoalesced = newDesc(counterMetric,
+	prometheus.BuildFQName(namespace, "exporter", "concurrent_scrapes_coalesced_total"),
+	"Scrapes served the metrics of a collection already running for another scrape.",
+	nil,
+)
+
+// CachedCollector collects in the background every Interval and serves the
+// latest snapshot, so scrapes never wait on or add load to AdGuard.
+//
+// With a TTL instead it collects on scrape, but serves the snapshot, up
+// included, to the scrapes within TTL of its collection. A failed collection
+// is served like any other, so an unreachable AdGuard isn't asked again
+// before the TTL is over either.
+type CachedCollector struct {
+	Collector prometheus.Collector
+	Interval  time.Duration
+	TTL       time.Duration
+
+	// held for a TTL refresh, so concurrent scrapes wait for the same one
+	refreshMu sync.Mutex
+
+	snapshot snapshot
+}
+
+func (c *CachedCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.Collector.Describe(ch)
+}
+
+func (c *CachedCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.TTL > 0 {
+		c.refreshExpired()
+	}
+
+	metrics, _ := c.snapshot.load()
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// refreshExpired refreshes the snapshot if it's older than TTL. Scrapes
+// arriving during the refresh wait for it and find it fresh.
+func (c *CachedCollector) refreshExpired() {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	_, taken := c.snapshot.load()
+	if taken.IsZero() || time.Since(taken) >= c.TTL {
+		c.Refresh()
+	}
+}
+
+// Refresh replaces the snapshot with a fresh collection.
+func (c *CachedCollector) Refresh() {
+	start := time.Now()
+	c.snapshot.store(collectMetrics(c.Collector.Collect), start)
+}
+
+// Generation changes with every refresh of the snapshot.
+func (c *CachedCollector) Generation() uint64 {
+	return c.snapshot.generation()
+}
+
+// Run refreshes the snapshot every Interval until ctx is done. Each wait is
+// off by up to a tenth of Interval, so several targets and exporters don't
+// all poll AdGuard at the same moment.
+func (c *CachedCollector) Run(ctx context.Context) {
+	timer := time.NewTimer(jitter(c.Interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			c.Refresh()
+			timer.Reset(jitter(c.Interval))
+		}
+	}
+}
+
+// jitter returns d give or take up to a tenth of it.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 5)
+	if spread <= 0 {
+		return d
+	}
+	return d - d/10 + time.Duration(rand.Int64N(spread))
+}
+
+// snapshot keeps a set of collected metrics and when they were collected.
+type snapshot struct {
+	mu      sync.RWMutex
+	metrics []prometheus.Metric
+	taken   time.Time
+	stores  uint64
+}
+
+func (s *snapshot) store(metrics []prometheus.Metric, taken time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metrics, s.taken = metrics, taken
+	s.stores++
+}
+
+// load returns the metrics and when they were taken, zero if never.
+func (s *snapshot) load() ([]prometheus.Metric, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.metrics, s.taken
+}
+
+// generation changes with every store.
+func (s *snapshot) generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stores
+}
+
+// collectMetrics runs collect and returns what it sent.
+func collectMetrics(collect func(chan<- prometheus.Metric)) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collect(ch)
+		close(ch)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// CoalescingCollector lets scrapes arriving while a collection runs wait for
+// it and share its metrics, rather than asking AdGuard again. Once it's
+// done the next scrape collects afresh, nothing is kept.
+type CoalescingCollector struct {
+	Collector prometheus.Collector
+
+	// Descs are the descs its own metrics are exposed with, as those of
+	// the Exporter collected.
+	Descs *descSet
+
+	mu        sync.Mutex
+	running   *collection
+	coalesced uint64
+}
+
+// collection is a running collection, its metrics are set once done is
+// closed.
+type collection struct {
+	done    chan struct{}
+	metrics []prometheus.Metric
+}
+
+func (c *CoalescingCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.Collector.Describe(ch)
+	c.Descs.describe(ch, func(ch chan<- *prometheus.Desc) { ch <- scrapesCoalesced })
+}
+
+func (c *CoalescingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext collects with ctx if Collector takes one. The scrapes
+// waiting for a collection share its context too.
+func (c *CoalescingCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	running := c.running
+	if running != nil {
+		c.coalesced++
+		c.mu.Unlock()
+		<-running.done
+	} else {
+		running = &collection{done: make(chan struct{})}
+		c.running = running
+		c.mu.Unlock()
+
+		collect := c.Collector.Collect
+		if cc, ok := c.Collector.(contextCollector); ok {
+			collect = func(ch chan<- prometheus.Metric) { cc.CollectContext(ctx, ch) }
+		}
+		running.metrics = collectMetrics(collect)
+		c.mu.Lock()
+		c.running = nil
+		c.mu.Unlock()
+		close(running.done)
+	}
+
+	for _, m := range running.metrics {
+		ch <- m
+	}
+
+	c.mu.Lock()
+	coalesced := c.coalesced
+	c.mu.Unlock()
+	c.Descs.collect(ch, func(ch chan<- prometheus.Metric) {
+		ch <- prometheus.MustNewConstMetric(
+			scrapesCoalesced, prometheus.CounterValue, float64(coalesced),
+		)
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCachedCollectorServesSnapshot(t *testing.T) {
+	stub := newAdGuardStub(t)
+	cached := &CachedCollector{Collector: NewExporter(stub.endpoint(), "", ""), Interval: time.Hour}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cached)
+
+	cached.Refresh()
+	stub.set("/control/stats", map[string]any{"num_dns_queries": 500, "num_blocked_filtering": 0, "avg_processing_time": 0.01})
+	for range 3 {
+		if body := exposition(t, registry); !strings.Contains(body, "adguardhome_dns_queries 100\n") {
+			t.Fatalf("got\n%s\nwant the snapshot", body)
+		}
+	}
+	if n := stub.count("/control/stats"); n != 1 {
+		t.Errorf("got %d requests to AdGuard, want only the one of the snapshot", n)
+	}
+}
+
+func TestCachedCollectorRun(t *testing.T) {
+	stub := newAdGuardStub(t)
+	cached := &CachedCollector{Collector: NewExporter(stub.endpoint(), "", ""), Interval: 20 * time.Millisecond}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cached)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		cached.Run(ctx)
+		close(done)
+	}()
+
+	stub.set("/control/stats", map[string]any{"num_dns_queries": 500, "num_blocked_filtering": 0, "avg_processing_time": 0.01})
+	waitFor(t, "the snapshot to be refreshed", func() bool {
+		return strings.Contains(exposition(t, registry), "adguardhome_dns_queries 500\n")
+	})
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the collection loop didn't stop on shutdown")
+	}
+	requests := stub.count("/control/stats")
+	time.Sleep(100 * time.Millisecond)
+	if n := stub.count("/control/stats"); n != requests {
+		t.Errorf("got %d requests after shutdown, want none", n-requests)
+	}
+}
+
+func TestCachedCollectorTTL(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stats := map[string]any{"num_dns_queries": 100, "num_blocked_filtering": 0, "avg_processing_time": 0.01}
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// long enough for the scrapes to overlap
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(stats)
+	}))
+	const ttl = 500 * time.Millisecond
+	cached := &CachedCollector{Collector: NewExporter(stub.endpoint(), "", ""), TTL: ttl}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cached)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := exposition(t, registry); !strings.Contains(body, "adguardhome_dns_queries 100\n") {
+				t.Errorf("got\n%s\nwant the collection", body)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := stub.count("/control/stats"); n != 1 {
+		t.Errorf("concurrent scrapes: got %d requests to AdGuard, want 1", n)
+	}
+
+	// a failure is cached like a success
+	time.Sleep(ttl)
+	stub.fail("/control/stats", http.StatusBadGateway)
+	for range 3 {
+		if body := exposition(t, registry); !strings.Contains(body, "adguardhome_up 0\n") {
+			t.Fatalf("got\n%s\nwant the target down", body)
+		}
+	}
+	if n := stub.count("/control/stats"); n != 2 {
+		t.Errorf("after the TTL: got %d requests to AdGuard, want 2", n)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	const d = 10 * time.Second
+	seen := map[time.Duration]bool{}
+	for range 100 {
+		got := jitter(d)
+		if got < 9*time.Second || got >= 11*time.Second {
+			t.Fatalf("got %v, want within a tenth of %v", got, d)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 90 {
+		t.Errorf("got %d distinct waits of 100, want them spread", len(seen))
+	}
+	if got := jitter(time.Nanosecond); got != time.Nanosecond {
+		t.Errorf("got %v, want a wait too short to spread kept", got)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	polls := 0
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		first := polls == 1
+		mu.Unlock()
+		if first {
+			json.NewEncoder(w).Encode(map[string]any{"num_dns_queries": 100})
+			return
+		}
+		// later polls hang until released
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"num_dns_queries": 500})
+	}))
+	base := runExporter(t, "-endpoint", stub.URL, "-poll.interval", "100ms")
+
+	get := func() string {
+		t.Helper()
+
+		res, err := http.Get(base + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	// the initial poll at startup
+	if body := get(); !strings.Contains(body, "adguardhome_dns_queries 100\n") {
+		t.Fatalf("got\n%s\nwant the startup snapshot", body)
+	}
+	waitFor(t, "a poll to hang", func() bool { return stub.count("/control/stats") >= 2 })
+	for range 3 {
+		start := time.Now()
+		body := get()
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("scrape took %v while AdGuard hangs, want the snapshot right away", elapsed)
+		}
+		if !strings.Contains(body, "adguardhome_dns_queries 100\n") {
+			t.Errorf("got\n%s\nwant the last snapshot", body)
+		}
+	}
+
+	close(release)
+	waitFor(t, "the next snapshot", func() bool { return strings.Contains(get(), "adguardhome_dns_queries 500\n") })
+
+	stub.fail("/control/stats", http.StatusBadGateway)
+	waitFor(t, "the failed poll", func() bool {
+		body := get()
+		return strings.Contains(body, "adguardhome_up 0\n") &&
+			strings.Contains(body, `adguardhome_collector_success{collector="stats"} 0`)
+	})
+}
+
+func TestCoalescingCollector(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release := make(chan struct{})
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		json.NewEncoder(w).Encode(map[string]any{"num_dns_queries": 100})
+	}))
+	c := &CoalescingCollector{Collector: NewExporter(stub.endpoint(), "", "")}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	const scrapes = 10
+	var wg sync.WaitGroup
+	for range scrapes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := exposition(t, registry); !strings.Contains(body, "adguardhome_dns_queries 100\n") {
+				t.Errorf("got\n%s\nwant the shared collection", body)
+			}
+		}()
+	}
+	waitFor(t, "the scrapes to wait for the running collection", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.coalesced == scrapes-1
+	})
+	close(release)
+	wg.Wait()
+
+	if n := stub.count("/control/stats"); n != 1 {
+		t.Errorf("got %d requests to AdGuard, want 1", n)
+	}
+	// nothing is kept for the next scrape
+	body := exposition(t, registry)
+	if n := stub.count("/control/stats"); n != 2 {
+		t.Errorf("next scrape: got %d requests to AdGuard, want 2", n)
+	}
+	if !strings.Contains(body, "adguardhome_exporter_concurrent_scrapes_coalesced_total 9\n") {
+		t.Errorf("got\n%s\nwant 9 scrapes coalesced", body)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	checkHostBlocked = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "check_host_blocked"),
+		"Whether AdGuard blocks the checked domain (1) or not (0).",
+		[]string{"domain", "client", "expected"},
+	)
+	checkHostSuccess = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "check_host_success"),
+		"Whether the check of the domain succeeded (1) or failed (0).",
+		[]string{"domain", "client", "expected"},
+	)
+	checkHostMismatches = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "check_host_mismatches"),
+		"Number of checked domains not handled as expected.",
+		nil,
+	)
+)
+
+// blockedReasons are the check_host reasons meaning a domain is blocked,
+// rewrites and safe search aren't.
+var blockedReasons = map[string]bool{
+	"FilteredBlackList":      true,
+	"FilteredSafeBrowsing":   true,
+	"FilteredParental":       true,
+	"FilteredInvalid":        true,
+	"FilteredBlockedService": true,
+}
+
+type CheckHostResponse struct {
+	Reason string `json:"reason"`
+}
+
+// HostCheck is a domain expected to be blocked or allowed, optionally for a
+// specific client.
+type HostCheck struct {
+	Domain, Client string
+	Blocked        bool
+}
+
+// ParseHostCheck parses domain[@client]=blocked|allowed.
+func ParseHostCheck(s string) (HostCheck, error) {
+	target, expected, ok := strings.Cut(s, "=")
+	if !ok {
+		return HostCheck{}, fmt.Errorf("%q: expected domain[@client]=blocked|allowed", s)
+	}
+
+	var check HostCheck
+	check.Domain, check.Client, _ = strings.Cut(target, "@")
+	if check.Domain == "" {
+		return HostCheck{}, fmt.Errorf("%q: missing domain", s)
+	}
+
+	switch expected {
+	case "blocked":
+		check.Blocked = true
+	case "allowed":
+	default:
+		return HostCheck{}, fmt.Errorf("%q: expected blocked or allowed, got %q", s, expected)
+	}
+
+	return check, nil
+}
+
+// ParseHostChecks parses the checks of the -check-host values. A domain
+// can be checked once per client.
+func ParseHostChecks(values []string) ([]HostCheck, error) {
+	var checks []HostCheck
+	seen := map[[2]string]bool{}
+	for _, s := range values {
+		check, err := ParseHostCheck(s)
+		if err != nil {
+			return nil, err
+		}
+		key := [2]string{check.Domain, check.Client}
+		if seen[key] {
+			return nil, fmt.Errorf("%q: %v checked twice", s, strings.TrimSuffix(check.Domain+"@"+check.Client, "@"))
+		}
+		seen[key] = true
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func (c HostCheck) expected() string {
+	if c.Blocked {
+		return "blocked"
+	}
+	return "allowed"
+}
+
+// HostChecks verifies the blocking policy via /control/filtering/check_host.
+// The checks run concurrently, bounded by Timeout.
+type HostChecks struct {
+	Checks  []HostCheck
+	Timeout time.Duration
+}
+
+func (h *HostChecks) Describe(ch chan<- *prometheus.Desc) {
+	ch <- checkHostBlocked
+	ch <- checkHostSuccess
+	ch <- checkHostMismatches
+}
+
+func (e *Exporter) CollectFromHostChecks(ctx context.Context, ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(ctx, e.HostChecks.Timeout)
+	defer cancel()
+
+	blocked := make([]*bool, len(e.HostChecks.Checks))
+
+	var wg sync.WaitGroup
+	for i, check := range e.HostChecks.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			query := url.Values{"name": {check.Domain}}
+			if check.Client != "" {
+				query.Set("client", check.Client)
+			}
+
+			var res CheckHostResponse
+			if err := e.get(ctx, "/control/filtering/check_host?"+query.Encode(), &res); err != nil {
+				slog.Error(fmt.Sprintf("Checking %v failed: %v", check.Domain, err))
+				return
+			}
+
+			b := blockedReasons[res.Reason]
+			blocked[i] = &b
+		}()
+	}
+	wg.Wait()
+
+	mismatches := 0
+	for i, check := range e.HostChecks.Checks {
+		ch <- prometheus.MustNewConstMetric(
+			checkHostSuccess, prometheus.GaugeValue, boolToFloat(blocked[i] != nil),
+			check.Domain, check.Client, check.expected(),
+		)
+		if blocked[i] == nil {
+			continue
+		}
+		if *blocked[i] != check.Blocked {
+			mismatches++
+		}
+		ch <- prometheus.MustNewConstMetric(
+			checkHostBlocked, prometheus.GaugeValue, boolToFloat(*blocked[i]),
+			check.Domain, check.Client, check.expected(),
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		checkHostMismatches, prometheus.GaugeValue, float64(mismatches),
+	)
+}
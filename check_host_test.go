@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHostChecks(t *testing.T) {
+	checks, err := ParseHostChecks([]string{
+		"doubleclick.net=blocked",
+		"example.com=allowed",
+		"youtube.com@192.168.1.20=blocked",
+		"youtube.com=allowed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HostCheck{
+		{Domain: "doubleclick.net", Blocked: true},
+		{Domain: "example.com"},
+		{Domain: "youtube.com", Client: "192.168.1.20", Blocked: true},
+		{Domain: "youtube.com"},
+	}
+	if len(checks) != len(want) {
+		t.Fatalf("got %v, want %v", checks, want)
+	}
+	for i := range want {
+		if checks[i] != want[i] {
+			t.Errorf("check %d: got %+v, want %+v", i, checks[i], want[i])
+		}
+	}
+
+	for _, tc := range []struct {
+		values  []string
+		wantErr string
+	}{
+		{[]string{"example.com"}, "expected domain[@client]=blocked|allowed"},
+		{[]string{"@192.168.1.20=allowed"}, "missing domain"},
+		{[]string{"example.com=denied"}, `expected blocked or allowed, got "denied"`},
+		{[]string{"example.com=allowed", "example.com=blocked"}, "example.com checked twice"},
+		{[]string{"a.com@10.0.0.1=allowed", "a.com@10.0.0.1=allowed"}, "a.com@10.0.0.1 checked twice"},
+	} {
+		_, err := ParseHostChecks(tc.values)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%q: got error %v, want %q", tc.values, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCollectFromHostChecks(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/filtering/check_host", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := map[string]string{
+			"doubleclick.net": "FilteredBlackList",
+			"example.com":     "NotFilteredNotFound",
+			"nas.home.arpa":   "Rewrite",
+		}[r.URL.Query().Get("name")]
+		if r.URL.Query().Get("client") == "192.168.1.20" {
+			reason = "FilteredBlockedService"
+		}
+		if reason == "" {
+			http.Error(w, "unknown", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(CheckHostResponse{Reason: reason})
+	}))
+
+	checks, err := ParseHostChecks([]string{
+		"doubleclick.net=blocked",
+		"example.com=allowed",
+		// a rewrite isn't a block
+		"nas.home.arpa=blocked",
+		"example.com@192.168.1.20=blocked",
+		"broken.example=allowed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(stub.endpoint(), "", "")
+	e.HostChecks = &HostChecks{Checks: checks, Timeout: 5 * time.Second}
+
+	err = testutil.CollectAndCompare(collectorFunc(func(ch chan<- prometheus.Metric) {
+		e.CollectFromHostChecks(t.Context(), ch)
+	}), strings.NewReader(`
+# HELP adguardhome_check_host_blocked Whether AdGuard blocks the checked domain (1) or not (0).
+# TYPE adguardhome_check_host_blocked gauge
+adguardhome_check_host_blocked{client="",domain="doubleclick.net",expected="blocked"} 1
+adguardhome_check_host_blocked{client="",domain="example.com",expected="allowed"} 0
+adguardhome_check_host_blocked{client="",domain="nas.home.arpa",expected="blocked"} 0
+adguardhome_check_host_blocked{client="192.168.1.20",domain="example.com",expected="blocked"} 1
+# HELP adguardhome_check_host_mismatches Number of checked domains not handled as expected.
+# TYPE adguardhome_check_host_mismatches gauge
+adguardhome_check_host_mismatches 1
+# HELP adguardhome_check_host_success Whether the check of the domain succeeded (1) or failed (0).
+# TYPE adguardhome_check_host_success gauge
+adguardhome_check_host_success{client="",domain="broken.example",expected="allowed"} 0
+adguardhome_check_host_success{client="",domain="doubleclick.net",expected="blocked"} 1
+adguardhome_check_host_success{client="",domain="example.com",expected="allowed"} 1
+adguardhome_check_host_success{client="",domain="nas.home.arpa",expected="blocked"} 1
+adguardhome_check_host_success{client="192.168.1.20",domain="example.com",expected="blocked"} 1
+`))
+	if err != nil {
+		t.Error(err)
+	}
+}
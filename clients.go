@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	clientsIgnoredStatistics = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "clients_ignored_statistics"),
+		"Number of persistent clients excluded from statistics.",
+		nil,
+	)
+	clientsWithCustomUpstreams = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "clients_with_custom_upstreams"),
+		"Number of persistent clients with upstreams of their own.",
+		nil,
+	)
+)
+
+type Client struct {
+	Name string   `json:"name"`
+	IDs  []string `json:"ids"`
+
+	// nil on AdGuard versions without per-client statistics settings
+	IgnoreStatistics *bool `json:"ignore_statistics"`
+	// null or missing for clients using the global upstreams
+	Upstreams []string `json:"upstreams"`
+}
+
+type ClientsResponse struct {
+	Clients []Client `json:"clients"`
+}
+
+func (e *Exporter) CollectFromClients(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var res ClientsResponse
+	if err := e.get(ctx, "/control/clients", &res); err != nil {
+		return err
+	}
+
+	supported, ignored, customUpstreams := len(res.Clients) == 0, 0, 0
+	for _, c := range res.Clients {
+		if len(c.Upstreams) > 0 {
+			customUpstreams++
+		}
+		if c.IgnoreStatistics != nil {
+			supported = true
+			if *c.IgnoreStatistics {
+				ignored++
+			}
+		}
+	}
+
+	if supported {
+		ch <- prometheus.MustNewConstMetric(
+			clientsIgnoredStatistics, prometheus.GaugeValue, float64(ignored),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		clientsWithCustomUpstreams, prometheus.GaugeValue, float64(customUpstreams),
+	)
+
+	return nil
+}
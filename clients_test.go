@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestClientsIgnoredStatistics(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	clients := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromClients(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	stub.set("/control/clients", map[string]any{"clients": []map[string]any{
+		{"name": "tv", "ids": []string{"192.168.1.20"}, "use_global_settings": true, "ignore_querylog": false, "ignore_statistics": false},
+		{"name": "uptime-kuma", "ids": []string{"192.168.1.5"}, "use_global_settings": false, "ignore_querylog": true, "ignore_statistics": true},
+		{"name": "k8s-node", "ids": []string{"10.0.0.0/24"}, "use_global_settings": false, "ignore_querylog": false, "ignore_statistics": true},
+	}})
+	err := testutil.CollectAndCompare(clients, strings.NewReader(`
+# HELP adguardhome_clients_ignored_statistics Number of persistent clients excluded from statistics.
+# TYPE adguardhome_clients_ignored_statistics gauge
+adguardhome_clients_ignored_statistics 2
+`), "adguardhome_clients_ignored_statistics")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// versions without the setting
+	stub.set("/control/clients", map[string]any{"clients": []map[string]any{
+		{"name": "tv", "ids": []string{"192.168.1.20"}, "use_global_settings": true},
+	}})
+	if n := testutil.CollectAndCount(clients, "adguardhome_clients_ignored_statistics"); n != 0 {
+		t.Errorf("without ignore_statistics: got %d series, want none", n)
+	}
+
+	stub.set("/control/clients", map[string]any{"clients": nil})
+	if got := testutil.ToFloat64(collectorOf(clients, "adguardhome_clients_ignored_statistics")); got != 0 {
+		t.Errorf("without clients: got %v, want 0", got)
+	}
+}
+
+func TestClientsWithCustomUpstreams(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	clients := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromClients(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	stub.set("/control/clients", map[string]any{"clients": []map[string]any{
+		{"name": "tv", "ids": []string{"192.168.1.20"}, "upstreams": []string{}},
+		{"name": "laptop", "ids": []string{"192.168.1.30"}, "upstreams": nil},
+		{"name": "printer", "ids": []string{"192.168.1.40"}},
+		{"name": "kids", "ids": []string{"192.168.1.50"}, "upstreams": []string{"https://family.cloudflare-dns.com/dns-query"}},
+		{"name": "work", "ids": []string{"192.168.1.60"}, "upstreams": []string{"[/corp.example/]10.0.0.53", "tls://1.1.1.1"}},
+	}})
+	err := testutil.CollectAndCompare(clients, strings.NewReader(`
+# HELP adguardhome_clients_with_custom_upstreams Number of persistent clients with upstreams of their own.
+# TYPE adguardhome_clients_with_custom_upstreams gauge
+adguardhome_clients_with_custom_upstreams 2
+`), "adguardhome_clients_with_custom_upstreams")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// versions without the field
+	stub.set("/control/clients", map[string]any{"clients": []map[string]any{
+		{"name": "tv", "ids": []string{"192.168.1.20"}},
+	}})
+	if got := testutil.ToFloat64(collectorOf(clients, "adguardhome_clients_with_custom_upstreams")); got != 0 {
+		t.Errorf("without upstreams: got %v, want 0", got)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// subsetCollector collects only the named API collectors of an exporter, with
+// its up and request counts. It's unchecked, the names decide what it
+// collects.
+type subsetCollector struct {
+	ctx      context.Context
+	exporter *Exporter
+	names    []string
+}
+
+func (c subsetCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c subsetCollector) Collect(ch chan<- prometheus.Metric) {
+	e := c.exporter
+	e.Descs.collect(ch, func(ch chan<- prometheus.Metric) {
+		err := e.collect(c.ctx, ch, c.names...)
+		e.requests.Collect(ch)
+		if e.Session != nil {
+			e.Session.Collect(ch)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			up, prometheus.GaugeValue, boolToFloat(err == nil),
+		)
+	})
+}
+
+// contextCollector is a collector that can bind a collection to a context.
+type contextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// scrapeCollector collects a target with the context of a scrape. It's
+// unchecked, the target was checked when it was added.
+type scrapeCollector struct {
+	ctx       context.Context
+	collector contextCollector
+}
+
+func (c scrapeCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collector.CollectContext(c.ctx, ch)
+}
+
+// registerScrape registers the collector of every target on reg, bound to
+// ctx where it takes one, with the labels of the target.
+func (s *TargetSet) registerScrape(ctx context.Context, reg prometheus.Registerer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.members {
+		var c prometheus.Collector = m.registered
+		if cc, ok := m.collector.(contextCollector); ok {
+			c = scrapeCollector{ctx: ctx, collector: cc}
+		}
+		if err := prometheus.WrapRegistererWith(m.labels, reg).Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrapeContext returns the context of a scrape, canceled with the request
+// and after Prometheus' scrape timeout or timeout, whichever is shorter.
+func scrapeContext(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+			if scrape := time.Duration(seconds * float64(time.Second)); timeout == 0 || scrape < timeout {
+				timeout = scrape
+			}
+		}
+	}
+	if timeout == 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// registerSubsets registers a subsetCollector of every target on reg, with
+// the labels of the target.
+func (s *TargetSet) registerSubsets(ctx context.Context, reg prometheus.Registerer, names []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.members {
+		c := subsetCollector{ctx: ctx, exporter: m.exporter, names: names}
+		if err := prometheus.WrapRegistererWith(m.labels, reg).Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectParam serves scrapes with collect[] parameters from a registry of
+// just those API collectors of the targets, and the exporter's own metrics
+// from Self. Collectors that aren't enabled stay off. With Live the other
+// scrapes are served from a registry of every target too, so that all of
+// them collect with the context of the scrape.
+type CollectParam struct {
+	Self        prometheus.Gatherer
+	Targets     *TargetSet
+	ConstLabels prometheus.Labels
+	Opts        promhttp.HandlerOpts
+	Live        bool
+
+	inFlight chan struct{}
+}
+
+// Wrap returns a handler serving scrapes without collect[] with next. The
+// Opts.MaxRequestsInFlight limit holds for the scrapes of both together, so
+// next is expected to have none of its own.
+func (p *CollectParam) Wrap(next http.Handler) http.Handler {
+	// the handlers are made per request, so they can't limit the scrapes
+	opts := p.Opts
+	if opts.MaxRequestsInFlight > 0 {
+		p.inFlight = make(chan struct{}, opts.MaxRequestsInFlight)
+		opts.MaxRequestsInFlight = 0
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		for _, name := range names {
+			if !slices.ContainsFunc(collectorInfos, func(c collectorInfo) bool { return c.Name == name }) {
+				http.Error(w, fmt.Sprintf("unknown collector %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if p.inFlight != nil {
+			select {
+			case p.inFlight <- struct{}{}:
+				defer func() { <-p.inFlight }()
+			default:
+				http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", cap(p.inFlight)),
+					http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		if len(names) == 0 && !p.Live {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := scrapeContext(r, p.Opts.Timeout)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		reg := prometheus.WrapRegistererWith(p.ConstLabels, registry)
+		var err error
+		if len(names) == 0 {
+			err = p.Targets.registerScrape(ctx, reg)
+		} else {
+			err = p.Targets.registerSubsets(ctx, reg, names)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(prometheus.Gatherers{p.Self, registry}, opts).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStats makes the stats of stub hang until released or the request is
+// canceled, reporting the cancellations on canceled.
+func slowStats(stub *adguardStub) (release chan struct{}, canceled chan struct{}) {
+	release, canceled = make(chan struct{}), make(chan struct{}, 10)
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		}
+	}))
+	return release, canceled
+}
+
+func newTestCollectParam(s *TargetSet, opts promhttp.HandlerOpts) http.Handler {
+	return (&CollectParam{
+		Self:    prometheus.NewRegistry(),
+		Targets: s,
+		Opts:    opts,
+		Live:    true,
+	}).Wrap(http.NotFoundHandler())
+}
+
+func TestScrapeTimeoutCancelsCollection(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release, canceled := slowStats(stub)
+	defer close(release)
+	s, _ := newTestTargetSet()
+	static := &target{Scheme: "http", Endpoint: stub.endpoint()}
+	s.Add(static, s.Exporter.forTarget(static))
+	h := newTestCollectParam(s, promhttp.HandlerOpts{})
+
+	for _, path := range []string{"/metrics", "/metrics?collect[]=stats"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(rec, req)
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%v: scrape took %v, want the scrape timeout", path, elapsed)
+		}
+		select {
+		case <-canceled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: request to AdGuard not canceled", path)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, `adguardhome_up{source="static",target="`+stub.endpoint()+`"} 0`) {
+			t.Errorf("%v: got\n%s\nwant the target down", path, body)
+		}
+	}
+}
+
+func TestScrapeMaxRequestsInFlight(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release, _ := slowStats(stub)
+	s, _ := newTestTargetSet()
+	static := &target{Scheme: "http", Endpoint: stub.endpoint()}
+	s.Add(static, s.Exporter.forTarget(static))
+	ts := httptest.NewServer(newTestCollectParam(s, promhttp.HandlerOpts{MaxRequestsInFlight: 1}))
+	defer ts.Close()
+
+	first := make(chan int)
+	go func() {
+		res, err := http.Get(ts.URL + "/metrics")
+		if err != nil {
+			t.Error(err)
+			first <- 0
+			return
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		first <- res.StatusCode
+	}()
+	waitFor(t, "the first scrape to reach AdGuard", func() bool { return stub.count("/control/stats") == 1 })
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("concurrent scrape: got %d, want 503", res.StatusCode)
+	}
+
+	close(release)
+	if status := <-first; status != http.StatusOK {
+		t.Errorf("first scrape: got %d, want 200", status)
+	}
+}
+
+func TestMaxRequestsInFlightShared(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release, _ := slowStats(stub)
+	s, _ := newTestTargetSet()
+	static := &target{Scheme: "http", Endpoint: stub.endpoint()}
+	s.Add(static, s.Exporter.forTarget(static))
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s.Exporters()[0])
+	ts := httptest.NewServer((&CollectParam{
+		Self:    prometheus.NewRegistry(),
+		Targets: s,
+		Opts:    promhttp.HandlerOpts{MaxRequestsInFlight: 1},
+	}).Wrap(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+	defer ts.Close()
+
+	// a scrape of either kind holds the only slot for the other
+	for _, paths := range [][2]string{
+		{"/metrics", "/metrics?collect[]=status"},
+		{"/metrics?collect[]=stats", "/metrics"},
+	} {
+		before := stub.count("/control/stats")
+		first := make(chan int)
+		go func() {
+			res, err := http.Get(ts.URL + paths[0])
+			if err != nil {
+				t.Error(err)
+				first <- 0
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			first <- res.StatusCode
+		}()
+		waitFor(t, "the first scrape to reach AdGuard", func() bool { return stub.count("/control/stats") == before+1 })
+
+		res, err := http.Get(ts.URL + paths[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%v during %v: got %d, want 503", paths[1], paths[0], res.StatusCode)
+		}
+
+		release <- struct{}{}
+		if status := <-first; status != http.StatusOK {
+			t.Errorf("%v: got %d, want 200", paths[0], status)
+		}
+	}
+}
+
+func TestCollectParam(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/filtering/status", map[string]any{"enabled": true, "filters": []any{}})
+	base := runExporter(t, "-endpoint", stub.URL, "-collector.status", "-collector.filtering")
+
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		res, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	for _, tc := range []struct {
+		query            string
+		want, unwanted   []string
+		called, uncalled []string
+	}{
+		{
+			"?collect[]=stats&collect[]=status",
+			[]string{`collector="stats"`, `collector="status"`, "adguardhome_dns_queries 100"},
+			[]string{`collector="filtering"`},
+			[]string{"/control/stats", "/control/status"},
+			[]string{"/control/filtering/status"},
+		},
+		{
+			"?collect[]=filtering",
+			[]string{`collector="filtering"`},
+			[]string{`collector="stats"`, `collector="status"`, "adguardhome_dns_queries "},
+			[]string{"/control/filtering/status"},
+			[]string{"/control/stats", "/control/status"},
+		},
+		// enabled collectors only
+		{
+			"?collect[]=dhcp",
+			nil,
+			[]string{`collector="dhcp"`},
+			nil,
+			[]string{"/control/dhcp/status", "/control/stats"},
+		},
+		{
+			"",
+			[]string{`collector="stats"`, `collector="status"`, `collector="filtering"`},
+			[]string{`collector="dhcp"`},
+			[]string{"/control/stats", "/control/status", "/control/filtering/status"},
+			nil,
+		},
+	} {
+		before := map[string]int{}
+		for _, path := range append(tc.called, tc.uncalled...) {
+			before[path] = stub.count(path)
+		}
+		status, body := get("/metrics" + tc.query)
+		if status != http.StatusOK {
+			t.Fatalf("%q: got %d: %s", tc.query, status, body)
+		}
+		// always there
+		for _, want := range append(tc.want, "adguardhome_up 1", "adguardhome_exporter_requests_total", "adguardhome_exporter_config_last_reload_successful 1") {
+			if !strings.Contains(body, want) {
+				t.Errorf("%q: got\n%s\nwant %s", tc.query, body, want)
+			}
+		}
+		for _, unwanted := range tc.unwanted {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%q: got\n%s\nwant no %s", tc.query, body, unwanted)
+			}
+		}
+		for _, path := range tc.called {
+			if stub.count(path) == before[path] {
+				t.Errorf("%q: %v not requested", tc.query, path)
+			}
+		}
+		for _, path := range tc.uncalled {
+			if stub.count(path) != before[path] {
+				t.Errorf("%q: %v requested", tc.query, path)
+			}
+		}
+	}
+
+	status, body := get("/metrics?collect[]=stats&collect[]=bogus")
+	if status != http.StatusBadRequest || !strings.Contains(body, `unknown collector "bogus"`) {
+		t.Errorf("got %d: %s, want the unknown collector rejected", status, body)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+var (
+	collectorSuccess = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "collector", "success"),
+		"Whether the last run of the API collector succeeded (1) or not (0).",
+		[]string{"collector"},
+	)
+	collectorDuration = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "collector", "duration_seconds"),
+		"Duration of the last run of the API collector.",
+		[]string{"collector"},
+	)
+)
+
+// collectorInfo describes an API collector for its flags and -collector.list.
+type collectorInfo struct {
+	Name, Path, Help string
+	Default          bool
+	// Alias is the older -<alias>.enabled flag of the collector.
+	Alias string
+}
+
+// collectorInfos are the API collectors in the order collect runs them.
+var collectorInfos = []collectorInfo{
+	{"stats", "/control/stats", "query statistics, top lists and upstreams", true, ""},
+	{"querylog", "/control/querylog", "per-query metrics from the query log", false, "querylog"},
+	{"status", "/control/status", "protection and version", false, "status"},
+	{"dhcp", "/control/dhcp/status", "DHCP pool utilization", false, "dhcp"},
+	{"clients", "/control/clients", "persistent clients", false, "clients"},
+	{"dns_info", "/control/dns_info", "DNS settings", false, "dns-info"},
+	{"filtering", "/control/filtering/status", "filtering switch, filter lists and rules", false, "filtering"},
+}
+
+// negatedFlag is the -no-collector.<name> flag switching a collector off.
+type negatedFlag struct {
+	enabled *bool
+}
+
+func (f negatedFlag) String() string {
+	if f.enabled == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*f.enabled)
+}
+
+func (f negatedFlag) Set(value string) error {
+	disabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*f.enabled = !disabled
+	return nil
+}
+
+func (f negatedFlag) IsBoolFlag() bool { return true }
+
+// collectorFlags registers -collector.<name>, -no-collector.<name> and the
+// older -<alias>.enabled of every collector on fs, all setting the same
+// switch.
+func collectorFlags(fs *flag.FlagSet) map[string]*bool {
+	enabled := map[string]*bool{}
+	for _, c := range collectorInfos {
+		enabled[c.Name] = fs.Bool("collector."+c.Name, c.Default,
+			fmt.Sprintf("Collect %v from %v", c.Help, c.Path))
+		fs.Var(negatedFlag{enabled[c.Name]}, "no-collector."+c.Name,
+			"Disable -collector."+c.Name)
+		if c.Alias != "" {
+			fs.BoolVar(enabled[c.Name], c.Alias+".enabled", c.Default,
+				"Alias of -collector."+c.Name)
+		}
+	}
+	return enabled
+}
+
+// collectorFlag returns the collector a flag switches and whether it
+// switches it off, ok is false for other flags.
+func collectorFlag(name string) (collector string, negated, ok bool) {
+	for _, c := range collectorInfos {
+		switch name {
+		case "collector." + c.Name:
+			return c.Name, false, true
+		case "no-collector." + c.Name:
+			return c.Name, true, true
+		}
+		if c.Alias != "" && name == c.Alias+".enabled" {
+			return c.Name, false, true
+		}
+	}
+	return "", false, false
+}
+
+// expandCollectorFlags marks every flag of a collector as set when one of
+// them is, so a config file doesn't override an alias given on the
+// command line.
+func expandCollectorFlags(explicit map[string]bool) {
+	for name := range explicit {
+		collector, _, ok := collectorFlag(name)
+		if !ok {
+			continue
+		}
+		for _, c := range collectorInfos {
+			if c.Name != collector {
+				continue
+			}
+			explicit["collector."+c.Name] = true
+			explicit["no-collector."+c.Name] = true
+			if c.Alias != "" {
+				explicit[c.Alias+".enabled"] = true
+			}
+		}
+	}
+}
+
+// listCollectors writes the API collectors, their endpoint and whether
+// they're enabled by default.
+func listCollectors(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDEFAULT\tENDPOINT\tHELP")
+	for _, c := range collectorInfos {
+		state := "disabled"
+		if c.Default {
+			state = "enabled"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", c.Name, state, c.Path, c.Help)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCollectorFlags(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want map[string]bool
+	}{
+		{nil, map[string]bool{"stats": true, "status": false, "filtering": false}},
+		{[]string{"-no-collector.stats", "-collector.dhcp"}, map[string]bool{"stats": false, "dhcp": true}},
+		{[]string{"-collector.stats=false", "-no-collector.filtering=false"}, map[string]bool{"stats": false, "filtering": true}},
+		{[]string{"-dns-info.enabled"}, map[string]bool{"dns_info": true}},
+		{[]string{"-collector.status", "-no-collector.status"}, map[string]bool{"status": false}},
+	} {
+		fs := flag.NewFlagSet("adguard-exporter", flag.ContinueOnError)
+		enabled := collectorFlags(fs)
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range tc.want {
+			if got := *enabled[name]; got != want {
+				t.Errorf("%q: %v got %v, want %v", tc.args, name, got, want)
+			}
+		}
+	}
+}
+
+func TestCollectorList(t *testing.T) {
+	out, err := exporterOutput(t, "-collector.list")
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(collectorInfos)+1 {
+		t.Fatalf("got\n%s\nwant a header and %d collectors", out, len(collectorInfos))
+	}
+	for i, c := range collectorInfos {
+		if fields := strings.Fields(lines[i+1]); len(fields) < 3 || fields[0] != c.Name || fields[2] != c.Path {
+			t.Errorf("got %q, want %v with %v", lines[i+1], c.Name, c.Path)
+		}
+	}
+	if fields := strings.Fields(lines[1]); fields[1] != "enabled" {
+		t.Errorf("got %q, want stats enabled by default", lines[1])
+	}
+}
+
+func TestCollectorsDescribe(t *testing.T) {
+	described := func(e *Exporter) map[*prometheus.Desc]bool {
+		ch := make(chan *prometheus.Desc)
+		go func() {
+			e.Describe(ch)
+			close(ch)
+		}()
+		descs := map[*prometheus.Desc]bool{}
+		for desc := range ch {
+			descs[desc] = true
+		}
+		return descs
+	}
+
+	e := NewExporter("adguard.example:3000", "", "")
+	if descs := described(e); !descs[dnsQueries] || !descs[topClients] {
+		t.Errorf("stats enabled: got no stats metrics described")
+	}
+	e.Stats = false
+	if descs := described(e); descs[dnsQueries] || descs[topClients] || descs[upstreamTime] {
+		t.Errorf("stats disabled: got stats metrics described")
+	}
+}
+
+func TestCollectorSwitches(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/filtering/status", map[string]any{"enabled": true, "filters": []any{}})
+	base := runExporter(t, "-endpoint", stub.URL,
+		"-no-collector.stats", "-collector.status", "-collector.filtering")
+
+	res, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	body := string(b)
+
+	for _, want := range []string{
+		`adguardhome_collector_success{collector="status"} 1`,
+		`adguardhome_collector_success{collector="filtering"} 1`,
+		"adguardhome_protection_enabled 1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got\n%s\nwant %s", body, want)
+		}
+	}
+	for _, unwanted := range []string{
+		`collector="stats"`,
+		`collector="dhcp"`,
+		"adguardhome_dns_queries ",
+		`endpoint="stats"`,
+	} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("got\n%s\nwant no %s", body, unwanted)
+		}
+	}
+
+	for path, want := range map[string]bool{
+		"/control/stats":            false,
+		"/control/dhcp/status":      false,
+		"/control/status":           true,
+		"/control/filtering/status": true,
+	} {
+		if got := stub.count(path) > 0; got != want {
+			t.Errorf("%v: got %d requests, want called %v", path, stub.count(path), want)
+		}
+	}
+}
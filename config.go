@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var envRefRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references. Other $ signs, as in bcrypt hashes,
+// are kept.
+func expandEnv(s string) string {
+	return envRefRE.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRefRE.FindStringSubmatch(ref)[1])
+	})
+}
+
+// fileConfig is the schema of -config.file. The keys are the flag names
+// split at the dots into nested mappings, a flag that's also the prefix of
+// others moves into their mapping as enabled (probe: {dot: {enabled: true}}
+// is -probe.dot). Every option names its flag in the flag tag, with ,file
+// for a secret read from the file given.
+type fileConfig struct {
+	Endpoint        option[string] `yaml:"endpoint" flag:"endpoint"`
+	Target          targetList     `yaml:"target" flag:"target"`
+	Scheme          option[string] `yaml:"scheme" flag:"scheme"`
+	Username        option[string] `yaml:"username" flag:"username"`
+	UsernameSecret  option[string] `yaml:"username_file" flag:"username,file"`
+	Password        option[string] `yaml:"password" flag:"password"`
+	PasswordSecret  option[string] `yaml:"password_file" flag:"password,file"`
+	Token           option[string] `yaml:"token" flag:"token"`
+	TokenSecret     option[string] `yaml:"token_file" flag:"token,file"`
+	UsernameFile    option[string] `yaml:"username-file" flag:"username-file"`
+	PasswordFile    option[string] `yaml:"password-file" flag:"password-file"`
+	TokenFile       option[string] `yaml:"token-file" flag:"token-file"`
+	NoAuth          option[bool]   `yaml:"no-auth" flag:"no-auth"`
+	Header          list[string]   `yaml:"header" flag:"header"`
+	ProxyAuth       option[string] `yaml:"proxy-auth" flag:"proxy-auth"`
+	ProxyAuthSecret option[string] `yaml:"proxy-auth_file" flag:"proxy-auth,file"`
+	Auth            struct {
+		Session option[bool] `yaml:"session" flag:"auth.session"`
+	} `yaml:"auth"`
+	Address          option[string]        `yaml:"address" flag:"address"`
+	Path             option[string]        `yaml:"path" flag:"path"`
+	MetricsToken     option[string]        `yaml:"metrics-token" flag:"metrics-token"`
+	MetricsSecret    option[string]        `yaml:"metrics-token_file" flag:"metrics-token,file"`
+	TLSMinVersion    option[string]        `yaml:"tls-min-version" flag:"tls-min-version"`
+	DisableHTTP2     option[bool]          `yaml:"disable-http2" flag:"disable-http2"`
+	TLSInsecure      option[bool]          `yaml:"tls-insecure" flag:"tls-insecure"`
+	TLSCAFile        option[string]        `yaml:"tls-ca-file" flag:"tls-ca-file"`
+	TLSCertFile      option[string]        `yaml:"tls-cert-file" flag:"tls-cert-file"`
+	TLSKeyFile       option[string]        `yaml:"tls-key-file" flag:"tls-key-file"`
+	MaxResponseBytes option[int64]         `yaml:"max-response-bytes" flag:"max-response-bytes"`
+	EndpointTimeout  option[time.Duration] `yaml:"endpoint-timeout" flag:"endpoint-timeout"`
+	ExcludeClient    list[string]          `yaml:"exclude-client" flag:"exclude-client"`
+	ExcludeDomain    list[string]          `yaml:"exclude-domain" flag:"exclude-domain"`
+	StateFile        option[string]        `yaml:"state-file" flag:"state-file"`
+	LogLevel         option[string]        `yaml:"log-level" flag:"log-level"`
+	ShutdownTimeout  option[time.Duration] `yaml:"shutdown-timeout" flag:"shutdown-timeout"`
+	CollectInterval  option[time.Duration] `yaml:"collect-interval" flag:"collect-interval"`
+	OnceAndServe     option[bool]          `yaml:"once-and-serve" flag:"once-and-serve"`
+
+	Web       webFileConfig       `yaml:"web"`
+	Collector collectorFileConfig `yaml:"collector"`
+	Querylog  querylogFileConfig  `yaml:"querylog"`
+	Discovery discoveryFileConfig `yaml:"discovery"`
+	Probe     probeFileConfig     `yaml:"probe"`
+	CheckHost checkHostFileConfig `yaml:"check-host"`
+	Metrics   metricsFileConfig   `yaml:"metrics"`
+	Retry     struct {
+		Attempts option[int]           `yaml:"attempts" flag:"retry.attempts"`
+		Backoff  option[time.Duration] `yaml:"backoff" flag:"retry.backoff"`
+		Jitter   option[bool]          `yaml:"jitter" flag:"retry.jitter"`
+	} `yaml:"retry"`
+	Stale struct {
+		MaxAge option[time.Duration] `yaml:"max-age" flag:"stale.max-age"`
+	} `yaml:"stale"`
+	Cache struct {
+		TTL option[time.Duration] `yaml:"ttl" flag:"cache.ttl"`
+	} `yaml:"cache"`
+	Ready struct {
+		RequireRecentSuccess option[time.Duration] `yaml:"require-recent-success" flag:"ready.require-recent-success"`
+	} `yaml:"ready"`
+	Labels struct {
+		DomainAggregation option[string] `yaml:"domain-aggregation" flag:"labels.domain-aggregation"`
+	} `yaml:"labels"`
+
+	// the older -<collector>.enabled flags
+	Status struct {
+		Enabled option[bool] `yaml:"enabled" flag:"status.enabled"`
+	} `yaml:"status"`
+	DHCP struct {
+		Enabled option[bool] `yaml:"enabled" flag:"dhcp.enabled"`
+	} `yaml:"dhcp"`
+	Clients struct {
+		Enabled option[bool] `yaml:"enabled" flag:"clients.enabled"`
+	} `yaml:"clients"`
+	DNSInfo struct {
+		Enabled option[bool] `yaml:"enabled" flag:"dns-info.enabled"`
+	} `yaml:"dns-info"`
+	Filtering struct {
+		Enabled    option[bool]          `yaml:"enabled" flag:"filtering.enabled"`
+		StaleAfter option[time.Duration] `yaml:"stale-after" flag:"filtering.stale-after"`
+	} `yaml:"filtering"`
+}
+
+type webFileConfig struct {
+	SocketMode    option[string] `yaml:"socket-mode" flag:"web.socket-mode"`
+	HealthAddress option[string] `yaml:"health-address" flag:"web.health-address"`
+	Config        struct {
+		File option[string] `yaml:"file" flag:"web.config.file"`
+	} `yaml:"config"`
+	TLSCert               option[string]        `yaml:"tls-cert" flag:"web.tls-cert"`
+	TLSKey                option[string]        `yaml:"tls-key" flag:"web.tls-key"`
+	BasicAuthUsername     option[string]        `yaml:"basic-auth-username" flag:"web.basic-auth-username"`
+	BasicAuthPasswordHash option[string]        `yaml:"basic-auth-password-hash" flag:"web.basic-auth-password-hash"`
+	BasicAuthPasswordFile option[string]        `yaml:"basic-auth-password-hash_file" flag:"web.basic-auth-password-hash,file"`
+	ReadTimeout           option[time.Duration] `yaml:"read-timeout" flag:"web.read-timeout"`
+	ReadHeaderTimeout     option[time.Duration] `yaml:"read-header-timeout" flag:"web.read-header-timeout"`
+	WriteTimeout          option[time.Duration] `yaml:"write-timeout" flag:"web.write-timeout"`
+	IdleTimeout           option[time.Duration] `yaml:"idle-timeout" flag:"web.idle-timeout"`
+	MaxHeaderBytes        option[int]           `yaml:"max-header-bytes" flag:"web.max-header-bytes"`
+	MaxRequestsInFlight   option[int]           `yaml:"max-requests-in-flight" flag:"web.max-requests-in-flight"`
+	ScrapeTimeout         option[time.Duration] `yaml:"scrape-timeout" flag:"web.scrape-timeout"`
+	EnableOpenMetrics     option[bool]          `yaml:"enable-openmetrics" flag:"web.enable-openmetrics"`
+	ErrorHandling         option[string]        `yaml:"error-handling" flag:"web.error-handling"`
+	AllowCIDR             list[string]          `yaml:"allow-cidr" flag:"web.allow-cidr"`
+	TrustXForwardedFor    option[bool]          `yaml:"trust-x-forwarded-for" flag:"web.trust-x-forwarded-for"`
+	AccessLog             option[bool]          `yaml:"access-log" flag:"web.access-log"`
+	AccessLogExclude      list[string]          `yaml:"access-log-exclude" flag:"web.access-log-exclude"`
+	EnableLifecycle       option[bool]          `yaml:"enable-lifecycle" flag:"web.enable-lifecycle"`
+	EnableDebug           option[bool]          `yaml:"enable-debug" flag:"web.enable-debug"`
+	EnablePprof           option[bool]          `yaml:"enable-pprof" flag:"web.enable-pprof"`
+	RoutePrefix           option[string]        `yaml:"route-prefix" flag:"web.route-prefix"`
+	ExternalURL           option[string]        `yaml:"external-url" flag:"web.external-url"`
+}
+
+type collectorFileConfig struct {
+	Stats     option[bool] `yaml:"stats" flag:"collector.stats"`
+	Querylog  option[bool] `yaml:"querylog" flag:"collector.querylog"`
+	Status    option[bool] `yaml:"status" flag:"collector.status"`
+	DHCP      option[bool] `yaml:"dhcp" flag:"collector.dhcp"`
+	Clients   option[bool] `yaml:"clients" flag:"collector.clients"`
+	DNSInfo   option[bool] `yaml:"dns_info" flag:"collector.dns_info"`
+	Filtering option[bool] `yaml:"filtering" flag:"collector.filtering"`
+}
+
+type querylogFileConfig struct {
+	Enabled            option[bool]          `yaml:"enabled" flag:"querylog.enabled"`
+	Limit              option[int]           `yaml:"limit" flag:"querylog.limit"`
+	Buckets            option[string]        `yaml:"buckets" flag:"querylog.buckets"`
+	UpstreamHistograms option[bool]          `yaml:"upstream-histograms" flag:"querylog.upstream-histograms"`
+	MaxDomains         option[int]           `yaml:"max-domains" flag:"querylog.max-domains"`
+	MaxClients         option[int]           `yaml:"max-clients" flag:"querylog.max-clients"`
+	MaxUpstreams       option[int]           `yaml:"max-upstreams" flag:"querylog.max-upstreams"`
+	Window             option[time.Duration] `yaml:"window" flag:"querylog.window"`
+	DistinctLimit      option[int]           `yaml:"distinct-limit" flag:"querylog.distinct-limit"`
+	IgnoreClients      list[string]          `yaml:"ignore-clients" flag:"querylog.ignore-clients"`
+	IgnoreDomains      list[string]          `yaml:"ignore-domains" flag:"querylog.ignore-domains"`
+	File               option[string]        `yaml:"file" flag:"querylog.file"`
+}
+
+type discoveryFileConfig struct {
+	File            list[string]          `yaml:"file" flag:"discovery.file"`
+	DNSSRV          list[string]          `yaml:"dns-srv" flag:"discovery.dns-srv"`
+	DNSServer       option[string]        `yaml:"dns-server" flag:"discovery.dns-server"`
+	RefreshInterval option[time.Duration] `yaml:"refresh-interval" flag:"discovery.refresh-interval"`
+	Docker          struct {
+		Enabled option[bool]   `yaml:"enabled" flag:"discovery.docker"`
+		Network option[string] `yaml:"network" flag:"discovery.docker.network"`
+	} `yaml:"docker"`
+	Kubernetes struct {
+		Service    list[string]   `yaml:"service" flag:"discovery.kubernetes.service"`
+		Port       option[string] `yaml:"port" flag:"discovery.kubernetes.port"`
+		Kubeconfig option[string] `yaml:"kubeconfig" flag:"discovery.kubernetes.kubeconfig"`
+	} `yaml:"kubernetes"`
+	AuthModule option[string] `yaml:"auth-module" flag:"discovery.auth-module"`
+}
+
+type probeFileConfig struct {
+	AllowTarget list[string]   `yaml:"allow-target" flag:"probe.allow-target"`
+	AuthModule  authModuleList `yaml:"auth-module" flag:"probe.auth-module"`
+	DNS         struct {
+		Target   option[string]        `yaml:"target" flag:"probe.dns.target"`
+		Query    option[string]        `yaml:"query" flag:"probe.dns.query"`
+		Protocol option[string]        `yaml:"protocol" flag:"probe.dns.protocol"`
+		Timeout  option[time.Duration] `yaml:"timeout" flag:"probe.dns.timeout"`
+	} `yaml:"dns"`
+	DOT struct {
+		Enabled option[bool]   `yaml:"enabled" flag:"probe.dot"`
+		Target  option[string] `yaml:"target" flag:"probe.dot.target"`
+	} `yaml:"dot"`
+	DOH struct {
+		Enabled option[bool]   `yaml:"enabled" flag:"probe.doh"`
+		URL     option[string] `yaml:"url" flag:"probe.doh.url"`
+	} `yaml:"doh"`
+	TLS struct {
+		Timeout option[time.Duration] `yaml:"timeout" flag:"probe.tls.timeout"`
+	} `yaml:"tls"`
+	Upstreams struct {
+		Enabled     option[bool]          `yaml:"enabled" flag:"probe.upstreams"`
+		Interval    option[time.Duration] `yaml:"interval" flag:"probe.upstreams.interval"`
+		Timeout     option[time.Duration] `yaml:"timeout" flag:"probe.upstreams.timeout"`
+		Concurrency option[int]           `yaml:"concurrency" flag:"probe.upstreams.concurrency"`
+	} `yaml:"upstreams"`
+}
+
+type checkHostFileConfig struct {
+	Hosts   list[string]          `yaml:"hosts" flag:"check-host"`
+	Max     option[int]           `yaml:"max" flag:"check-host.max"`
+	Timeout option[time.Duration] `yaml:"timeout" flag:"check-host.timeout"`
+}
+
+type metricsFileConfig struct {
+	Only                       option[string] `yaml:"only" flag:"metrics"`
+	Counters                   option[bool]   `yaml:"counters" flag:"metrics.counters"`
+	Gauges                     option[bool]   `yaml:"gauges" flag:"metrics.gauges"`
+	ConstLabel                 list[string]   `yaml:"const-label" flag:"metrics.const-label"`
+	Namespace                  option[string] `yaml:"namespace" flag:"metrics.namespace"`
+	Runtime                    option[bool]   `yaml:"runtime" flag:"metrics.runtime"`
+	ProcessingTimeMilliseconds option[bool]   `yaml:"processing-time-milliseconds" flag:"metrics.processing-time-milliseconds"`
+}
+
+// configValues are the values of an option of the config file, with ${VAR}
+// expanded.
+type configValues struct {
+	values []string
+	line   int
+}
+
+func (c *configValues) get() *configValues { return c }
+
+// decode reads the values of node, a single one or a list, with item.
+func (c *configValues) decode(node *yaml.Node, item func(*yaml.Node) (string, error)) error {
+	items := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		items = node.Content
+	}
+	c.line = node.Line
+	for _, i := range items {
+		v, err := item(i)
+		if err != nil {
+			return err
+		}
+		c.values = append(c.values, v)
+	}
+	return nil
+}
+
+// scalar returns the value of node, checked to decode into T.
+func scalar[T any](node *yaml.Node) (string, error) {
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("line %d: expected a value", node.Line)
+	}
+	value := expandEnv(node.Value)
+	var v T
+	err := (&yaml.Node{Kind: yaml.ScalarNode, Value: value}).Decode(&v)
+	if _, ok := any(v).(time.Duration); ok {
+		// Go syntax like the flags, a bare 0 included
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("line %d: expected a %T, got %q", node.Line, v, value)
+	}
+	return value, nil
+}
+
+// option is an option taking a single value of type T.
+type option[T any] struct{ configValues }
+
+func (o *option[T]) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a single value", node.Line)
+	}
+	return o.decode(node, scalar[T])
+}
+
+// list is a repeatable option, a value of type T or a list of them.
+type list[T any] struct{ configValues }
+
+func (l *list[T]) UnmarshalYAML(node *yaml.Node) error {
+	return l.decode(node, scalar[T])
+}
+
+// targetList is -target, its items URLs or mappings converted by targetURL.
+type targetList struct{ configValues }
+
+func (l *targetList) UnmarshalYAML(node *yaml.Node) error {
+	return l.decode(node, orMapping(targetURL))
+}
+
+// authModuleList is -probe.auth-module, its items mappings converted by
+// authModuleValue or the flag syntax.
+type authModuleList struct{ configValues }
+
+func (l *authModuleList) UnmarshalYAML(node *yaml.Node) error {
+	return l.decode(node, orMapping(authModuleValue))
+}
+
+// orMapping returns an item reader converting a mapping with convert.
+func orMapping(convert func(*yaml.Node) (string, error)) func(*yaml.Node) (string, error) {
+	return func(node *yaml.Node) (string, error) {
+		if node.Kind == yaml.MappingNode {
+			return convert(node)
+		}
+		return scalar[string](node)
+	}
+}
+
+// configValue is the value of an option of the config file for a flag.
+type configValue struct {
+	flag   string
+	values []string
+	line   int
+	// the values name files to read them from
+	file bool
+}
+
+// configFlagValues returns the options set in c, following the flag tags.
+func configFlagValues(c reflect.Value) []configValue {
+	var values []configValue
+	for i := range c.NumField() {
+		field, value := c.Type().Field(i), c.Field(i)
+		tag, ok := field.Tag.Lookup("flag")
+		if !ok {
+			values = append(values, configFlagValues(value)...)
+			continue
+		}
+		option := value.Addr().Interface().(interface{ get() *configValues }).get()
+		if option.values == nil {
+			continue
+		}
+		name, file := strings.CutSuffix(tag, ",file")
+		values = append(values, configValue{flag: name, values: option.values, line: option.line, file: file})
+	}
+	return values
+}
+
+// decodeConfigFile reads the options set in a config file.
+func decodeConfigFile(path string) ([]configValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return configFlagValues(reflect.ValueOf(&c).Elem()), nil
+}
+
+// loadConfigFile sets flags from a YAML file following fileConfig. Lists
+// set repeatable flags and a key ending in _file reads the secret from
+// that file. The flags in explicit keep their value, so the command line
+// and env override the file.
+func loadConfigFile(fs *flag.FlagSet, path string, explicit map[string]bool) error {
+	return readConfigFile(fs, path, explicit, func(f *flag.Flag, value string) error {
+		return f.Value.Set(value)
+	})
+}
+
+// readConfigFile is loadConfigFile with set called for each value instead.
+func readConfigFile(fs *flag.FlagSet, path string, explicit map[string]bool, set func(*flag.Flag, string) error) error {
+	values, err := decodeConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, option := range values {
+		f := fs.Lookup(option.flag)
+		if f == nil {
+			return fmt.Errorf("line %d: unknown option %q", option.line, option.flag)
+		}
+		if explicit[f.Name] {
+			continue
+		}
+		for _, v := range option.values {
+			if option.file {
+				secret, err := readSecretFile(v)
+				if err != nil {
+					return fmt.Errorf("line %d: %v: %w", option.line, option.flag, err)
+				}
+				v = secret
+			}
+			if err := set(f, v); err != nil {
+				return fmt.Errorf("line %d: %v: %w", option.line, option.flag, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// targetURL turns a target mapping into a -target URL. Its keys are url,
+// username, password and the options of the URL; username_file and
+// password_file read the credentials from files.
+func targetURL(node *yaml.Node) (string, error) {
+	options, err := mappingOptions(node, "target")
+	if err != nil {
+		return "", err
+	}
+	rawURL, username, password := options.Get("url"), options.Get("username"), options.Get("password")
+	for _, key := range []string{"url", "username", "password"} {
+		options.Del(key)
+	}
+
+	if rawURL == "" {
+		return "", fmt.Errorf("line %d: target without url", node.Line)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("line %d: invalid target url", node.Line)
+	}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	} else if password != "" {
+		return "", fmt.Errorf("line %d: target with a password but no username", node.Line)
+	}
+	query := u.Query()
+	for name, values := range options {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// authModuleValue turns an auth module mapping into a -probe.auth-module
+// value, name?options. Its keys are name and the options of a target plus
+// username and password, as for targetURL.
+func authModuleValue(node *yaml.Node) (string, error) {
+	options, err := mappingOptions(node, "auth module")
+	if err != nil {
+		return "", err
+	}
+	name := options.Get("name")
+	options.Del("name")
+	if name == "" {
+		return "", fmt.Errorf("line %d: auth module without name", node.Line)
+	}
+
+	return name + "?" + options.Encode(), nil
+}
+
+// mappingOptions reads the scalar values of a mapping, a key ending in
+// _file reads the value of username or password from a file.
+func mappingOptions(node *yaml.Node, what string) (url.Values, error) {
+	options := url.Values{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: %v %v: expected a value", value.Line, what, key.Value)
+		}
+
+		name, v := key.Value, expandEnv(value.Value)
+		if base, ok := strings.CutSuffix(name, "_file"); ok && (base == "username" || base == "password") {
+			secret, err := readSecretFile(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v %v: %w", value.Line, what, name, err)
+			}
+			name, v = base, secret
+		}
+		options.Set(name, v)
+	}
+
+	return options, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// commandLineFlags are the flags the config file doesn't set: actions,
+// the config file itself and older aliases of other options.
+var commandLineFlags = []string{
+	"config.file", "collector.list", "list-metrics", "check-config", "check-config.connect",
+	"route-prefix", "poll.interval", "label",
+}
+
+// exporterFlags returns the names of the flags main and collectorFlags
+// register.
+func exporterFlags(t *testing.T) []string {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		fun, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if pkg, ok := fun.X.(*ast.Ident); !ok || pkg.Name != "flag" {
+			return true
+		}
+		for _, arg := range call.Args {
+			if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				name, _ := strconv.Unquote(lit.Value)
+				names = append(names, name)
+				break
+			}
+		}
+		return true
+	})
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	collectorFlags(fs)
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+func TestConfigSchema(t *testing.T) {
+	var options []string
+	for _, v := range configFlagValues(reflect.ValueOf(fileConfigWithAll()).Elem()) {
+		if !v.file {
+			options = append(options, v.flag)
+		}
+	}
+
+	flags := exporterFlags(t)
+	for _, name := range flags {
+		if strings.HasPrefix(name, "no-collector.") || slices.Contains(commandLineFlags, name) {
+			continue
+		}
+		if n := len(slices.DeleteFunc(slices.Clone(options), func(o string) bool { return o != name })); n != 1 {
+			t.Errorf("-%v has %d options in the config file, want 1", name, n)
+		}
+	}
+	for _, name := range options {
+		if !slices.Contains(flags, name) {
+			t.Errorf("option of unknown flag -%v", name)
+		}
+	}
+}
+
+// fileConfigWithAll returns a fileConfig with every option set.
+func fileConfigWithAll() *fileConfig {
+	c := &fileConfig{}
+	var fill func(v reflect.Value)
+	fill = func(v reflect.Value) {
+		for i := range v.NumField() {
+			if _, ok := v.Type().Field(i).Tag.Lookup("flag"); !ok {
+				fill(v.Field(i))
+				continue
+			}
+			v.Field(i).Addr().Interface().(interface{ get() *configValues }).get().values = []string{""}
+		}
+	}
+	fill(reflect.ValueOf(c).Elem())
+	return c
+}
+
+func TestConfigExample(t *testing.T) {
+	values, err := decodeConfigFile("config.example.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := exporterFlags(t)
+	for _, v := range values {
+		if !slices.Contains(flags, v.flag) {
+			t.Errorf("line %d: unknown flag -%v", v.line, v.flag)
+		}
+	}
+	if len(values) == 0 {
+		t.Error("no options read")
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "password")
+	if err := os.WriteFile(secret, []byte("secretpw\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_ADGUARD_HOST", "adguard.home.arpa")
+	t.Setenv("TEST_RETRIES", "5")
+
+	newFlagSet := func() (*flag.FlagSet, *string, *string, *int, *time.Duration, *stringsFlag) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		endpoint := fs.String("endpoint", "", "")
+		password := fs.String("password", "", "")
+		attempts := fs.Int("retry.attempts", 0, "")
+		backoff := fs.Duration("retry.backoff", 0, "")
+		var ignored stringsFlag
+		fs.Var(&ignored, "querylog.ignore-clients", "")
+		return fs, endpoint, password, attempts, backoff, &ignored
+	}
+	load := func(content string, explicit map[string]bool) (*flag.FlagSet, error) {
+		path := filepath.Join(dir, "config.yml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		fs, _, _, _, _, _ := newFlagSet()
+		return fs, loadConfigFile(fs, path, explicit)
+	}
+
+	fs, err := load(`
+endpoint: ${TEST_ADGUARD_HOST}:3000
+password_file: `+secret+`
+retry:
+  attempts: ${TEST_RETRIES}
+  backoff: 0
+querylog:
+  ignore-clients: [127.0.0.1, "::1"]
+`, map[string]bool{"retry.attempts": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"endpoint":                "adguard.home.arpa:3000",
+		"password":                "secretpw",
+		"retry.attempts":          "0",
+		"retry.backoff":           "0s",
+		"querylog.ignore-clients": "127.0.0.1,::1",
+	} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("-%v: got %q, want %q", name, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, content, wantErr string
+	}{
+		{"unknown key", "retry:\n  attempt: 3\n", "field attempt not found"},
+		{"flat key", "retry.attempts: 3\n", "field retry.attempts not found"},
+		{"duration", "retry:\n  backoff: 200\n", "line 2: expected a time.Duration"},
+		{"int", "retry:\n  attempts: three\n", "line 2: expected a int"},
+		{"single value", "endpoint: [a, b]\n", "line 1: expected a single value"},
+		{"missing secret", "password_file: " + filepath.Join(dir, "missing") + "\n", "line 1: password"},
+		{"duplicate key", "endpoint: a\nendpoint: b\n", "already defined"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := load(tc.content, map[string]bool{})
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	stub := newAdGuardStub(t)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("valid.yml", "endpoint: "+stub.URL+"\nretry:\n  attempts: 2\n")
+	malformed := write("malformed.yml", "endpoint: "+stub.URL+"\nretry:\n  attempts: three\n")
+	unreachable := write("unreachable.yml", "endpoint: "+closed.URL+"\n")
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		ok   bool
+		want string
+	}{
+		{"valid", []string{"-config.file", valid}, true, "Configuration is valid"},
+		{"valid connect", []string{"-config.file", valid, "-check-config.connect"}, true, "Configuration is valid"},
+		{"malformed", []string{"-config.file", malformed}, false, "Invalid -config.file"},
+		// the connection is only tried when asked for
+		{"unreachable", []string{"-config.file", unreachable}, true, "Configuration is valid"},
+		{"unreachable connect", []string{"-config.file", unreachable, "-check-config.connect"}, false, "Unable to collect from"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := stub.count("/control/stats")
+			out, err := exporterOutput(t, append([]string{"-check-config"}, tc.args...)...)
+			if (err == nil) != tc.ok || !strings.Contains(out, tc.want) {
+				t.Errorf("got %v:\n%s\nwant ok %v and %q", err, out, tc.ok, tc.want)
+			}
+			if strings.Contains(out, "Listening on") {
+				t.Errorf("got\n%s\nwant no listener", out)
+			}
+			if connected := stub.count("/control/stats") > requests; connected != (tc.name == "valid connect") {
+				t.Errorf("got AdGuard requested %v, want %v", connected, tc.name == "valid connect")
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DebugTargetHandler serves the raw /control/stats response of a target next
+// to how the exporter parsed it. Only the targets of the given exporters can
+// be requested.
+func DebugTargetHandler(exporters func() []*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+
+		exporter := selectExporter(exporters(), target, false)
+		if exporter == nil {
+			http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusNotFound)
+			return
+		}
+
+		raw, err := exporter.getRaw(r.Context(), "/control/stats")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		var parsed Response
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			http.Error(w, fmt.Sprintf("unable to parse response: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		body, err := json.MarshalIndent(struct {
+			Target string          `json:"target"`
+			Raw    json.RawMessage `json:"raw"`
+			Parsed Response        `json:"parsed"`
+		}{target, raw, parsed}, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+// LastErrorHandler serves the time and the error of the last failed
+// collection of every target, or none, as text. Several targets get a line
+// each, prefixed with their endpoint.
+func LastErrorHandler(exporters func() []*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exporters := exporters()
+
+		var b strings.Builder
+		for _, e := range exporters {
+			if len(exporters) > 1 {
+				fmt.Fprintf(&b, "%v: ", e.Endpoint)
+			}
+			err, at := e.health.failure()
+			if err == nil {
+				b.WriteString("none\n")
+				continue
+			}
+			fmt.Fprintf(&b, "%v %v\n", at.Format(time.RFC3339), secrets.Redact(err.Error()))
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
+
+// collectLogged runs an API collector and, at debug level, logs each metric
+// of a successful run, to check the mapping of the AdGuard fields without a
+// Prometheus server.
+func (e *Exporter) collectLogged(ctx context.Context, c apiCollector, ch chan<- prometheus.Metric) error {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return c.collect(ctx, ch)
+	}
+
+	var err error
+	metrics := collectMetrics(func(ch chan<- prometheus.Metric) { err = c.collect(ctx, ch) })
+	for _, m := range metrics {
+		if err == nil {
+			slog.Debug(fmt.Sprintf("Collected %v from %v (%v)", formatMetric(e.Descs.metric(m)), e.Endpoint, c.name))
+		}
+		ch <- m
+	}
+	return err
+}
+
+// formatMetric formats a metric as its name, labels and value, a histogram
+// with its count and sum.
+func formatMetric(m prometheus.Metric) string {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return m.Desc().String()
+	}
+
+	name := metricInfos[m.Desc()].Name
+	var labels []string
+	for _, label := range pb.GetLabel() {
+		labels = append(labels, fmt.Sprintf("%v=%q", label.GetName(), label.GetValue()))
+	}
+	if len(labels) > 0 {
+		name += "{" + strings.Join(labels, ",") + "}"
+	}
+
+	switch {
+	case pb.Gauge != nil:
+		return fmt.Sprintf("%v %v", name, pb.GetGauge().GetValue())
+	case pb.Counter != nil:
+		return fmt.Sprintf("%v %v", name, pb.GetCounter().GetValue())
+	case pb.Histogram != nil:
+		return fmt.Sprintf("%v count=%v sum=%v", name, pb.GetHistogram().GetSampleCount(), pb.GetHistogram().GetSampleSum())
+	}
+	return name
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiCollector collects the metrics of one AdGuard API.
+type apiCollector struct {
+	name    string
+	enabled bool
+	collect func(context.Context, chan<- prometheus.Metric) error
+}
+
+// apiCollectors returns the API collectors in the order collect runs them.
+// It's called with collectorsMu held, the collectors keep the status
+// tracker of that moment.
+func (e *Exporter) apiCollectors() []apiCollector {
+	status := e.Status
+	return []apiCollector{
+		{"stats", e.Stats, func(ctx context.Context, ch chan<- prometheus.Metric) error {
+			return e.collectStats(ctx, ch, status)
+		}},
+		{"querylog", e.QueryLog != nil, e.CollectFromQueryLog},
+		{"status", status != nil, func(ctx context.Context, ch chan<- prometheus.Metric) error {
+			return e.collectStatus(ctx, ch, status)
+		}},
+		{"dhcp", e.DHCP, e.CollectFromDHCP},
+		{"clients", e.Clients, e.CollectFromClients},
+		{"dns_info", e.DNSInfo, e.CollectFromDNSInfo},
+		{"filtering", e.Filtering, e.CollectFromFiltering},
+	}
+}
+
+// collectorRun is the outcome of the last run of a collector.
+type collectorRun struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"duration_seconds"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// collectorRuns keeps the last run of each collector for /debug/status.
+type collectorRuns struct {
+	mu   sync.Mutex
+	last map[string]collectorRun
+}
+
+func (c *collectorRuns) record(name string, start, end time.Time, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run := collectorRun{Time: start, Duration: end.Sub(start), Seconds: end.Sub(start).Seconds()}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if c.last == nil {
+		c.last = map[string]collectorRun{}
+	}
+	c.last[name] = run
+}
+
+func (c *collectorRuns) get(name string) (collectorRun, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run, ok := c.last[name]
+	return run, ok
+}
+
+type debugCollector struct {
+	Name    string        `json:"name"`
+	Enabled bool          `json:"enabled"`
+	LastRun *collectorRun `json:"last_run,omitempty"`
+}
+
+type debugStatus struct {
+	Version        string            `json:"version"`
+	Endpoint       string            `json:"endpoint"`
+	AdGuardVersion string            `json:"adguard_version,omitempty"`
+	Flags          map[string]string `json:"flags"`
+	Collectors     []debugCollector  `json:"collectors"`
+	Probes         []string          `json:"probes"`
+}
+
+var debugStatusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>AdGuard Home Exporter status</title></head>
+<body>
+<h1>AdGuard Home Exporter status</h1>
+<p>Version {{.Version}}, collecting from {{.Endpoint}}
+{{- with .AdGuardVersion}} running AdGuard Home {{.}}{{end}}</p>
+<h2>Collectors</h2>
+<table>
+<tr><th>Name</th><th>Enabled</th><th>Last run</th><th>Duration</th><th>Error</th></tr>
+{{- range .Collectors}}
+<tr><td>{{.Name}}</td><td>{{.Enabled}}</td>
+{{- with .LastRun}}<td>{{.Time.Format "2006-01-02T15:04:05Z07:00"}}</td><td>{{.Duration}}</td><td>{{.Error}}</td>{{else}}<td></td><td></td><td></td>{{end}}</tr>
+{{- end}}
+</table>
+<p>Probes: {{range .Probes}}{{.}} {{else}}none{{end}}</p>
+<h2>Configuration</h2>
+<table>
+{{- range $name, $value := .Flags}}
+<tr><td>{{$name}}</td><td>{{$value}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// status returns what /debug/status shows, with secrets redacted.
+func (e *Exporter) status(flags *flag.FlagSet) debugStatus {
+	status := debugStatus{
+		Version:  version,
+		Endpoint: e.Endpoint,
+		Flags:    map[string]string{},
+		Probes:   []string{},
+	}
+	if s := e.currentStatus(); s != nil {
+		status.AdGuardVersion = s.Version()
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		status.Flags[f.Name] = redactFlag(f.Name, f.Value.String())
+	})
+
+	e.collectorsMu.RLock()
+	collectors := e.apiCollectors()
+	e.collectorsMu.RUnlock()
+	for _, c := range collectors {
+		collector := debugCollector{Name: c.name, Enabled: c.enabled}
+		if run, ok := e.runs.get(c.name); ok {
+			run.Error = secrets.Redact(run.Error)
+			collector.LastRun = &run
+		}
+		status.Collectors = append(status.Collectors, collector)
+	}
+
+	for _, probe := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"dns", e.DNSProbe != nil},
+		{"host_checks", e.HostChecks != nil},
+		{"tls", e.TLSProbe != nil},
+		{"upstreams", e.UpstreamProbes != nil},
+	} {
+		if probe.enabled {
+			status.Probes = append(status.Probes, probe.name)
+		}
+	}
+
+	return status
+}
+
+// DebugStatusHandler serves the configuration and the last collections as
+// HTML, or as JSON when the client asks for it. The target parameter picks
+// one of several exporters, the first by default.
+func DebugStatusHandler(flags *flag.FlagSet, exporters func() []*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		e := selectExporter(exporters(), target, true)
+		if e == nil {
+			http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusNotFound)
+			return
+		}
+		status := e.status(flags)
+
+		if strings.Contains(r.Header.Get("Accept"), "application/json") || r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		debugStatusPage.Execute(w, status)
+	})
+}
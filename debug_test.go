@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugTarget(t *testing.T) {
+	stub, other := newAdGuardStub(t), newAdGuardStub(t)
+	const raw = `{"num_dns_queries": 42, "num_blocked_filtering": 7, "num_new_field": 1}`
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(raw))
+	}))
+	exporters := []*Exporter{NewExporter(other.endpoint(), "", ""), NewExporter(stub.endpoint(), "", "")}
+	h := (&BearerAuth{Token: "secrettoken"}).Wrap(DebugTargetHandler(func() []*Exporter { return exporters }))
+
+	get := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/target?target="+target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(stub.endpoint(), "secrettoken")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Target string          `json:"target"`
+		Raw    json.RawMessage `json:"raw"`
+		Parsed Response        `json:"parsed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	var got, want any
+	json.Unmarshal(body.Raw, &got)
+	json.Unmarshal([]byte(raw), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got raw %s, want the upstream %s", body.Raw, raw)
+	}
+	if body.Target != stub.endpoint() || body.Parsed.AllDNSQueries != 42 {
+		t.Errorf("got target %q and parsed %+v", body.Target, body.Parsed)
+	}
+	if other.count("/control/stats") != 0 {
+		t.Error("the other target was requested")
+	}
+
+	if rec := get("http://"+stub.endpoint(), "secrettoken"); rec.Code != http.StatusOK {
+		t.Errorf("target with scheme: got %d, want 200", rec.Code)
+	}
+	if rec := get("unknown:3000", "secrettoken"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown target: got %d, want 404", rec.Code)
+	}
+	if rec := get(stub.endpoint(), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without the token: got %d, want 401", rec.Code)
+	}
+}
+
+func TestDebugStatus(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-username", "admin", "-password", "s3cretpw",
+		"-collector.status", "-web.enable-debug")
+
+	get := func(base, path, accept string) (int, string) {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	get(base, "/metrics", "")
+	// an error message echoing the password must not leak it either, the
+	// collection stops there and the status collector keeps its last run
+	stub.set("/control/stats", map[string]any{"message": "wrong password s3cretpw"})
+	get(base, "/metrics", "")
+	status, body := get(base, "/debug/status", "application/json")
+	if status != http.StatusOK {
+		t.Fatalf("got %d: %s", status, body)
+	}
+	var got debugStatus
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Flags["password"] != redacted || got.Flags["username"] != "admin" {
+		t.Errorf("got flags username %q, password %q", got.Flags["username"], got.Flags["password"])
+	}
+	if got.AdGuardVersion != "v0.107.52" {
+		t.Errorf("got AdGuard version %q, want v0.107.52", got.AdGuardVersion)
+	}
+	runs := map[string]*collectorRun{}
+	for _, c := range got.Collectors {
+		if c.Enabled {
+			runs[c.Name] = c.LastRun
+		}
+	}
+	if run := runs["stats"]; run == nil || !strings.Contains(run.Error, "wrong password "+redacted) {
+		t.Errorf("got stats run %+v, want the redacted error", run)
+	}
+	if run := runs["status"]; run == nil || run.Error != "" || run.Time.IsZero() {
+		t.Errorf("got status run %+v, want a successful run", run)
+	}
+
+	status, html := get(base, "/debug/status", "text/html")
+	if status != http.StatusOK || !strings.Contains(html, "<h1>AdGuard Home Exporter status</h1>") {
+		t.Errorf("got %d:\n%s\nwant the HTML page", status, html)
+	}
+	for _, body := range []string{body, html} {
+		if strings.Contains(body, "s3cretpw") {
+			t.Errorf("got the password in\n%s", body)
+		}
+	}
+
+	disabled := runExporter(t, "-endpoint", stub.URL)
+	if status, _ := get(disabled, "/debug/status", ""); status != http.StatusNotFound {
+		t.Errorf("without -web.enable-debug: got %d, want 404", status)
+	}
+}
+
+func TestLastError(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-metrics-token", "secrettoken")
+
+	get := func(path, token string) (int, string) {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	get("/metrics", "secrettoken")
+	if status, body := get("/debug/last-error", "secrettoken"); status != http.StatusOK || body != "none\n" {
+		t.Errorf("before a failure: got %d: %q, want none", status, body)
+	}
+
+	stub.fail("/control/stats", http.StatusBadGateway)
+	before := time.Now().Truncate(time.Second)
+	get("/metrics", "secrettoken")
+	stub.fail("/control/stats", 0)
+	get("/metrics", "secrettoken")
+
+	// kept after the next success
+	status, body := get("/debug/last-error", "secrettoken")
+	at, message, _ := strings.Cut(strings.TrimSuffix(body, "\n"), " ")
+	if status != http.StatusOK || !strings.Contains(message, "502") {
+		t.Errorf("got %d: %q, want the 502", status, body)
+	}
+	if ts, err := time.Parse(time.RFC3339, at); err != nil || ts.Before(before) || ts.After(time.Now()) {
+		t.Errorf("got time %q, %v, want the time of the failed scrape", at, err)
+	}
+
+	if status, _ := get("/debug/last-error", ""); status != http.StatusUnauthorized {
+		t.Errorf("without the token: got %d, want 401", status)
+	}
+}
+
+func TestDebugLogsMetrics(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	for _, tc := range []struct {
+		level slog.Level
+		want  bool
+	}{
+		{slog.LevelDebug, true},
+		{slog.LevelInfo, false},
+	} {
+		var buf bytes.Buffer
+		slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: tc.level})))
+		collectMetrics(e.Collect)
+
+		want := fmt.Sprintf("Collected adguardhome_dns_queries 100 from %v (stats)", stub.endpoint())
+		if got := strings.Contains(buf.String(), want); got != tc.want {
+			t.Errorf("%v: got\n%s\nwant %q logged %v", tc.level, buf.String(), want, tc.want)
+		}
+	}
+
+	// a failed collection logs no metrics
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	stub.fail("/control/stats", http.StatusBadGateway)
+	collectMetrics(e.Collect)
+	if strings.Contains(buf.String(), "Collected ") {
+		t.Errorf("got\n%s\nwant no metrics of the failed collection", buf.String())
+	}
+}
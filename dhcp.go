@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/netip"
+)
+
+var (
+	dhcpPoolSize = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "dhcp", "pool_size"),
+		"Number of addresses in the DHCP IPv4 range.",
+		nil,
+	)
+	dhcpPoolUsed = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "dhcp", "pool_used"),
+		"Number of active leases within the DHCP IPv4 range.",
+		nil,
+	)
+)
+
+type DHCPLease struct {
+	IP string `json:"ip"`
+}
+
+type DHCPStatusResponse struct {
+	Enabled bool `json:"enabled"`
+	V4      struct {
+		RangeStart string `json:"range_start"`
+		RangeEnd   string `json:"range_end"`
+	} `json:"v4"`
+	Leases       []DHCPLease `json:"leases"`
+	StaticLeases []DHCPLease `json:"static_leases"`
+}
+
+// pool returns the size of the IPv4 range and the number of leases in it.
+// Static leases count too, as long as they fall inside the range.
+func (res DHCPStatusResponse) pool() (size, used int, err error) {
+	start, err := netip.ParseAddr(res.V4.RangeStart)
+	if err != nil || !start.Is4() {
+		return 0, 0, fmt.Errorf("invalid DHCP range start %q", res.V4.RangeStart)
+	}
+	end, err := netip.ParseAddr(res.V4.RangeEnd)
+	if err != nil || !end.Is4() {
+		return 0, 0, fmt.Errorf("invalid DHCP range end %q", res.V4.RangeEnd)
+	}
+	if end.Less(start) {
+		return 0, 0, fmt.Errorf("invalid DHCP range %v-%v", start, end)
+	}
+
+	s, e := start.As4(), end.As4()
+	first := uint32(s[0])<<24 | uint32(s[1])<<16 | uint32(s[2])<<8 | uint32(s[3])
+	last := uint32(e[0])<<24 | uint32(e[1])<<16 | uint32(e[2])<<8 | uint32(e[3])
+	size = int(last-first) + 1
+
+	seen := map[netip.Addr]struct{}{}
+	for _, lease := range append(res.Leases, res.StaticLeases...) {
+		ip, err := netip.ParseAddr(lease.IP)
+		if err != nil || !ip.Is4() || ip.Less(start) || end.Less(ip) {
+			continue
+		}
+		seen[ip] = struct{}{}
+	}
+
+	return size, len(seen), nil
+}
+
+func (e *Exporter) CollectFromDHCP(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var res DHCPStatusResponse
+	if err := e.get(ctx, "/control/dhcp/status", &res); err != nil {
+		return err
+	}
+
+	// nothing to report unless AdGuard is the DHCP server
+	if !res.Enabled {
+		return nil
+	}
+
+	size, used, err := res.pool()
+	if err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		dhcpPoolSize, prometheus.GaugeValue, float64(size),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		dhcpPoolUsed, prometheus.GaugeValue, float64(used),
+	)
+
+	return nil
+}
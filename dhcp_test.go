@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestDHCPPool(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/dhcp/status", map[string]any{
+		"enabled": true,
+		"v4": map[string]any{
+			"gateway_ip":  "192.168.1.1",
+			"subnet_mask": "255.255.255.0",
+			"range_start": "192.168.1.100",
+			"range_end":   "192.168.1.199",
+		},
+		"leases": []map[string]any{
+			{"mac": "00:11:22:33:44:01", "ip": "192.168.1.100", "hostname": "laptop"},
+			{"mac": "00:11:22:33:44:02", "ip": "192.168.1.150", "hostname": "phone"},
+			{"mac": "00:11:22:33:44:03", "ip": "192.168.1.199", "hostname": "tv"},
+			// outside the range
+			{"mac": "00:11:22:33:44:04", "ip": "192.168.1.20", "hostname": "printer"},
+			{"mac": "00:11:22:33:44:05", "ip": "fd00::5", "hostname": "nas"},
+		},
+		"static_leases": []map[string]any{
+			{"mac": "00:11:22:33:44:06", "ip": "192.168.1.120", "hostname": "server"},
+			// also a dynamic lease, counted once
+			{"mac": "00:11:22:33:44:02", "ip": "192.168.1.150", "hostname": "phone"},
+		},
+	})
+	e := NewExporter(stub.endpoint(), "", "")
+	collector := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromDHCP(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP adguardhome_dhcp_pool_size Number of addresses in the DHCP IPv4 range.
+# TYPE adguardhome_dhcp_pool_size gauge
+adguardhome_dhcp_pool_size 100
+# HELP adguardhome_dhcp_pool_used Number of active leases within the DHCP IPv4 range.
+# TYPE adguardhome_dhcp_pool_used gauge
+adguardhome_dhcp_pool_used 4
+`))
+	if err != nil {
+		t.Error(err)
+	}
+
+	stub.set("/control/dhcp/status", map[string]any{"enabled": false})
+	if n := testutil.CollectAndCount(collector); n != 0 {
+		t.Errorf("DHCP disabled: got %d metrics, want none", n)
+	}
+}
+
+func TestDHCPPoolInvalidRange(t *testing.T) {
+	for _, r := range [][2]string{
+		{"", "192.168.1.199"},
+		{"192.168.1.100", "fd00::ff"},
+		{"192.168.1.200", "192.168.1.100"},
+	} {
+		var res DHCPStatusResponse
+		res.V4.RangeStart, res.V4.RangeEnd = r[0], r[1]
+		if _, _, err := res.pool(); err == nil {
+			t.Errorf("%v: got no error", r)
+		}
+	}
+}
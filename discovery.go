@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// staticSource is the source of the -endpoint and -target targets.
+	staticSource = "static"
+
+	// discoveryDebounce is how long a discovery file or Docker has to stay
+	// quiet before targets are refreshed, changes come in bursts.
+	discoveryDebounce = 500 * time.Millisecond
+
+	// minSRVRefresh bounds how often SRV records with a small TTL are
+	// looked up.
+	minSRVRefresh = 5 * time.Second
+)
+
+// TargetSet holds the exporters of the configured and the discovered targets.
+// Discovered targets are registered and unregistered as they come and go,
+// so the series of a vanished target disappear with it.
+type TargetSet struct {
+	Registerer prometheus.Registerer
+
+	// TargetLabel adds the target label to the metrics, SourceLabel the
+	// source of the target as well, PodLabel the Kubernetes pod.
+	TargetLabel, SourceLabel, PodLabel bool
+
+	// CollectInterval collects in the background instead of on scrape,
+	// CacheTTL on scrape but at most once per TTL.
+	CollectInterval time.Duration
+	CacheTTL        time.Duration
+
+	// Exporter gives the settings of discovered targets, the auth module
+	// named by Module their credentials unless the URL or the target has
+	// some (the -username/-password/-token ones if empty).
+	Exporter *Exporter
+	Module   string
+	Modules  map[string]*probeModule
+	// Transport is the base of discovered targets with TLS options.
+	Transport *http.Transport
+
+	mu      sync.RWMutex
+	members []*targetMember
+	changes uint64
+	// ctx is the one of Start, configured targets added later run until
+	// it's done
+	ctx context.Context
+}
+
+type targetMember struct {
+	source    string
+	pod       string
+	module    string
+	target    *target
+	labels    prometheus.Labels
+	exporter  *Exporter
+	collector prometheus.Collector
+	// registered is collector as registered, see registeredCollector
+	registered registeredCollector
+	cached     *CachedCollector
+	cancel     context.CancelFunc
+	// sdLabels are the discovery file labels of the target, for /sd
+	sdLabels map[string]string
+}
+
+// registeredCollector describes a collector as it was when registered. The
+// collectors of an exporter can be switched by a reload, which changes its
+// descriptions; unregistering it needs the original ones.
+type registeredCollector struct {
+	prometheus.Collector
+	descs []*prometheus.Desc
+}
+
+func newRegisteredCollector(c prometheus.Collector) registeredCollector {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	r := registeredCollector{Collector: c}
+	for desc := range ch {
+		r.descs = append(r.descs, desc)
+	}
+	return r
+}
+
+func (c registeredCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+// Add registers the exporter of a configured target.
+func (s *TargetSet) Add(t *target, e *Exporter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.add(staticSource, "", e)
+	if err == nil {
+		m.target = t
+	}
+	return err
+}
+
+// Start collects the configured targets in the background with a
+// CollectInterval, once right away, and runs their upstream probes.
+func (s *TargetSet) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ctx = ctx
+	for _, m := range s.members {
+		if m.source != staticSource {
+			continue
+		}
+		if m.cached != nil && m.cached.Interval > 0 {
+			m.cached.Refresh()
+		}
+		s.start(ctx, m)
+	}
+}
+
+// Replace swaps the configured targets for targets, the exporters of new
+// or changed ones made by build. Targets with the same settings keep their
+// exporter and its state. Nothing changes when an exporter can't be made.
+func (s *TargetSet) Replace(targets []*target, build func(*target) (*Exporter, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkTargets(targets); err != nil {
+		return err
+	}
+	if !s.TargetLabel && !s.SourceLabel && len(targets) != 1 {
+		return errors.New("a single target can't become several or none without a restart, the labels would change")
+	}
+
+	kept := map[*targetMember]bool{}
+	var added []*target
+	var exporters []*Exporter
+	for _, t := range targets {
+		i := slices.IndexFunc(s.members, func(m *targetMember) bool {
+			return m.source == staticSource && m.target != nil && reflect.DeepEqual(*m.target, *t)
+		})
+		if i >= 0 {
+			kept[s.members[i]] = true
+			continue
+		}
+		e, err := build(t)
+		if err != nil {
+			return fmt.Errorf("target %v: %w", t.Endpoint, err)
+		}
+		added = append(added, t)
+		exporters = append(exporters, e)
+	}
+
+	for _, m := range slices.Clone(s.members) {
+		if m.source == staticSource && !kept[m] {
+			slog.Info(fmt.Sprintf("Target %v removed", m.exporter.Endpoint))
+			s.remove(m)
+		}
+	}
+	for i, t := range added {
+		m, err := s.add(staticSource, "", exporters[i])
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to add target %v: %v", t.Endpoint, err))
+			continue
+		}
+		m.target = t
+		if s.ctx != nil {
+			s.start(s.ctx, m)
+		}
+		slog.Info(fmt.Sprintf("Target %v added", t.Endpoint))
+	}
+	return nil
+}
+
+func (s *TargetSet) add(source, pod string, e *Exporter) (*targetMember, error) {
+	labels := prometheus.Labels{}
+	if s.TargetLabel || s.SourceLabel {
+		labels["target"] = e.Endpoint
+	}
+	if s.SourceLabel {
+		labels["source"] = source
+	}
+	if s.PodLabel {
+		// empty for targets that aren't pods, the label names must match
+		labels["pod"] = pod
+	}
+
+	m := &targetMember{source: source, pod: pod, labels: labels, exporter: e}
+	if s.CollectInterval > 0 || s.CacheTTL > 0 {
+		m.cached = &CachedCollector{Collector: e, Interval: s.CollectInterval, TTL: s.CacheTTL}
+		m.collector = m.cached
+	} else {
+		// scrapes overlapping a collection share it
+		m.collector = &CoalescingCollector{Collector: e, Descs: e.Descs}
+	}
+	m.registered = newRegisteredCollector(m.collector)
+	if err := prometheus.WrapRegistererWith(labels, s.Registerer).Register(m.registered); err != nil {
+		return nil, err
+	}
+
+	s.members = append(s.members, m)
+	s.changes++
+	return m, nil
+}
+
+// start runs the background work of a target until it's removed, with a
+// first collection unless there's one already.
+func (s *TargetSet) start(ctx context.Context, m *targetMember) {
+	ctx, m.cancel = context.WithCancel(ctx)
+	if m.cached != nil && m.cached.Interval > 0 {
+		go func() {
+			if m.cached.Generation() == 0 {
+				m.cached.Refresh()
+			}
+			m.cached.Run(ctx)
+		}()
+	}
+	if m.exporter.UpstreamProbes != nil {
+		go m.exporter.RunUpstreamProbes(ctx)
+	}
+}
+
+func (s *TargetSet) remove(m *targetMember) {
+	prometheus.WrapRegistererWith(m.labels, s.Registerer).Unregister(m.registered)
+	if m.cancel != nil {
+		m.cancel()
+	}
+
+	s.members = slices.DeleteFunc(s.members, func(other *targetMember) bool { return other == m })
+	s.changes++
+}
+
+// Exporters returns the exporters of all targets, the static ones first.
+func (s *TargetSet) Exporters() []*Exporter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exporters := make([]*Exporter, 0, len(s.members))
+	for _, m := range s.members {
+		exporters = append(exporters, m.exporter)
+	}
+	return exporters
+}
+
+// Generation changes with every background collection and every target
+// added or removed.
+func (s *TargetSet) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	generation := s.changes
+	for _, m := range s.members {
+		if m.cached != nil {
+			generation += m.cached.Generation()
+		}
+	}
+	return generation
+}
+
+// Update replaces the targets of a discovery source. Targets already known
+// from another source are skipped.
+func (s *TargetSet) Update(ctx context.Context, source string, targets []*target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := map[string]*target{}
+	for _, t := range targets {
+		wanted[t.Endpoint] = t
+	}
+
+	known := map[string]bool{}
+	for _, m := range slices.Clone(s.members) {
+		if m.source != source {
+			known[m.exporter.Endpoint] = true
+			continue
+		}
+		if t, ok := wanted[m.exporter.Endpoint]; ok {
+			known[m.exporter.Endpoint] = true
+			m.sdLabels = t.Labels
+			continue
+		}
+		slog.Info(fmt.Sprintf("Target %v vanished from %v", m.exporter.Endpoint, source))
+		s.remove(m)
+	}
+
+	for _, t := range targets {
+		if known[t.Endpoint] {
+			continue
+		}
+		known[t.Endpoint] = true
+
+		e, module, err := s.exporterFor(t)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid target %v from %v: %v", t.Endpoint, source, err))
+			continue
+		}
+		m, err := s.add(source, t.Pod, e)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to add target %v from %v: %v", t.Endpoint, source, err))
+			continue
+		}
+		m.module, m.sdLabels = module, t.Labels
+		s.start(ctx, m)
+		slog.Info(fmt.Sprintf("Discovered target %v from %v", t.Endpoint, source))
+	}
+}
+
+// exporterFor returns an exporter for a discovered target and the name of
+// the auth module applied to it, if any.
+func (s *TargetSet) exporterFor(t *target) (*Exporter, string, error) {
+	name := s.Module
+	if t.Module != "" {
+		name = t.Module
+	}
+	if t.Username != "" {
+		name = ""
+	}
+	module := s.Modules[name]
+	if name != "" && module == nil {
+		return nil, "", fmt.Errorf("unknown auth module %q", name)
+	}
+
+	e := s.Exporter.forTarget(t)
+	if module != nil {
+		module.apply(e)
+	}
+	if t.Insecure != nil || t.CAFile != "" || t.CertFile != "" {
+		transport, err := t.transport(s.Transport)
+		if err != nil {
+			return nil, "", err
+		}
+		e.Client = &http.Client{Transport: transport}
+	}
+	return e, name, nil
+}
+
+// discoveryFile is a file_sd style list of target groups. Targets are URLs
+// or host:port. Labels don't go on the metrics, as all targets share the
+// label names, but are passed on to the targets of /sd.
+type discoveryFile []struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// readDiscoveryFile reads the targets of a JSON or YAML discovery file, a
+// missing file has none.
+func readDiscoveryFile(path, scheme string) ([]*target, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var groups discoveryFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&groups); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var targets []*target
+	for _, group := range groups {
+		for name := range group.Labels {
+			if !labelNameRE.MatchString(name) {
+				return nil, fmt.Errorf("%q: invalid label name", name)
+			}
+		}
+		for _, s := range group.Targets {
+			if !strings.Contains(s, "://") {
+				s = scheme + "://" + s
+			}
+			t, err := parseTarget(s)
+			if err != nil {
+				return nil, fmt.Errorf("target #%d: %w", len(targets)+1, err)
+			}
+			secrets.Add(t.Password)
+			t.Labels = group.Labels
+			targets = append(targets, t)
+		}
+	}
+	return targets, nil
+}
+
+// RunFileDiscovery keeps the targets of a discovery file up to date until
+// ctx is done. The file is read on changes, debounced, and every interval
+// in case a change went unnoticed. A file that can't be read keeps the
+// targets it had.
+func (s *TargetSet) RunFileDiscovery(ctx context.Context, path string, interval time.Duration) {
+	source := "file:" + path
+	refresh := func() {
+		targets, err := readDiscoveryFile(path, s.Exporter.Scheme)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to read discovery file %v: %v", path, err))
+			return
+		}
+		s.Update(ctx, source, targets)
+	}
+	refresh()
+
+	// watch the directory, files are often replaced rather than written
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(path))
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Unable to watch discovery file %v, re-reading it every %v: %v", path, interval, err))
+	} else {
+		defer watcher.Close()
+		events = watcher.Events
+		watchErrors = watcher.Errors
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	debounce := time.NewTimer(0)
+	<-debounce.C
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+			debounce.Reset(discoveryDebounce)
+		case err := <-watchErrors:
+			// events may have been lost, the file is read again to be sure
+			slog.Warn(fmt.Sprintf("Unable to watch discovery file %v: %v", path, err))
+			debounce.Reset(discoveryDebounce)
+		case <-debounce.C:
+			refresh()
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+// lookupSRV resolves the SRV records of name with the server at addr. It
+// returns the targets and how long they may be cached.
+func lookupSRV(ctx context.Context, name, addr, scheme string) ([]*target, time.Duration, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeSRV)
+
+	c := &dns.Client{}
+	res, _, err := c.ExchangeContext(ctx, msg, addr)
+	if err == nil && res.Truncated {
+		c.Net = "tcp"
+		res, _, err = c.ExchangeContext(ctx, msg, addr)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	switch res.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("%v: %v", name, dns.RcodeToString[res.Rcode])
+	}
+
+	var targets []*target
+	var ttl time.Duration
+	for _, rr := range res.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		host := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		targets = append(targets, &target{Scheme: scheme, Endpoint: host})
+
+		recordTTL := time.Duration(srv.Hdr.Ttl) * time.Second
+		if ttl == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+	}
+	return targets, ttl, nil
+}
+
+// RunSRVDiscovery keeps the targets of an SRV name up to date until ctx is
+// done. The records are looked up again when their TTL expires, within
+// minSRVRefresh and maxInterval. A failed lookup keeps the targets, a name
+// that doesn't exist has none.
+func (s *TargetSet) RunSRVDiscovery(ctx context.Context, name, addr string, maxInterval time.Duration) {
+	source := "dns-srv:" + name
+	for {
+		wait := maxInterval
+		lookupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		targets, ttl, err := lookupSRV(lookupCtx, name, addr, s.Exporter.Scheme)
+		cancel()
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to look up %v: %v", name, err))
+		} else {
+			s.Update(ctx, source, targets)
+			wait = srvRefresh(ttl, maxInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// srvRefresh returns how long to wait before looking up SRV records with
+// ttl again, maxInterval for records without one.
+func srvRefresh(ttl, maxInterval time.Duration) time.Duration {
+	if ttl <= 0 {
+		return maxInterval
+	}
+	return min(max(ttl, minSRVRefresh), maxInterval)
+}
+
+// systemResolver returns the first name server of /etc/resolv.conf.
+func systemResolver() (string, error) {
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	if len(cfg.Servers) == 0 {
+		return "", errors.New("no name server in /etc/resolv.conf")
+	}
+	return net.JoinHostPort(cfg.Servers[0], cfg.Port), nil
+}
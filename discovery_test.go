@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadDiscoveryFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, content string
+		want          []string
+		wantLabels    map[string]string
+		wantErr       string
+	}{
+		{
+			name:    "json",
+			content: `[{"targets": ["adguard1:3000", "https://adguard2:3000"]}, {"targets": ["adguard3"]}]`,
+			want:    []string{"http://adguard1:3000", "https://adguard2:3000", "http://adguard3"},
+		},
+		{
+			name:       "labels",
+			content:    `[{"targets": ["adguard1:3000", "adguard2:3000"], "labels": {"env": "home", "__meta_rack": "a"}}]`,
+			want:       []string{"http://adguard1:3000", "http://adguard2:3000"},
+			wantLabels: map[string]string{"env": "home", "__meta_rack": "a"},
+		},
+		{
+			name:       "yaml",
+			content:    "- targets:\n    - adguard1:3000\n  labels:\n    env: home\n",
+			want:       []string{"http://adguard1:3000"},
+			wantLabels: map[string]string{"env": "home"},
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:    "unknown key",
+			content: `[{"targets": ["adguard1:3000"], "hosts": ["adguard2"]}]`,
+			wantErr: "field hosts not found",
+		},
+		{
+			name:    "invalid label",
+			content: `[{"targets": ["adguard1:3000"], "labels": {"data-center": "eu"}}]`,
+			wantErr: `"data-center": invalid label name`,
+		},
+		{
+			name:    "invalid target",
+			content: `[{"targets": ["adguard1:3000", "ftp://adguard2"]}]`,
+			wantErr: "target #2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "-"))
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			targets, err := readDiscoveryFile(path, "http")
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, target := range targets {
+				got = append(got, target.Scheme+"://"+target.Endpoint)
+				if !maps.Equal(target.Labels, tc.wantLabels) {
+					t.Errorf("%v: got labels %v, want %v", target.Endpoint, target.Labels, tc.wantLabels)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got targets %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	targets, err := readDiscoveryFile(filepath.Join(dir, "missing"), "http")
+	if err != nil || targets != nil {
+		t.Errorf("missing file: got %v, %v, want no targets", targets, err)
+	}
+}
+
+// newTestTargetSet returns a TargetSet labeling the series of its targets,
+// registered on the returned registry.
+func newTestTargetSet() (*TargetSet, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	return &TargetSet{
+		Registerer:  registry,
+		TargetLabel: true,
+		SourceLabel: true,
+		Exporter:    NewExporter("", "", ""),
+	}, registry
+}
+
+func endpoints(s *TargetSet) []string {
+	var endpoints []string
+	for _, e := range s.Exporters() {
+		endpoints = append(endpoints, e.Endpoint)
+	}
+	slices.Sort(endpoints)
+	return endpoints
+}
+
+func TestTargetSetUpdate(t *testing.T) {
+	one, two := newAdGuardStub(t), newAdGuardStub(t)
+	s, registry := newTestTargetSet()
+	if err := s.Add(&target{Scheme: "http", Endpoint: one.endpoint()}, NewExporter(one.endpoint(), "", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.Context()
+	s.Update(ctx, "file:targets.json", []*target{
+		{Scheme: "http", Endpoint: one.endpoint()},
+		{Scheme: "http", Endpoint: two.endpoint()},
+	})
+	// the static target is kept, not discovered again
+	if got := endpoints(s); len(got) != 2 {
+		t.Fatalf("got targets %v, want both stubs once", got)
+	}
+	metrics := exposition(t, registry)
+	for _, want := range []string{
+		`adguardhome_up{source="static",target="` + one.endpoint() + `"} 1`,
+		`adguardhome_up{source="file:targets.json",target="` + two.endpoint() + `"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("missing %v in\n%s", want, metrics)
+		}
+	}
+
+	generation := s.Generation()
+	s.Update(ctx, "file:targets.json", nil)
+	if got := endpoints(s); !slices.Equal(got, []string{one.endpoint()}) {
+		t.Errorf("got targets %v, want the static one only", got)
+	}
+	if s.Generation() == generation {
+		t.Error("generation unchanged by a removed target")
+	}
+	if metrics := exposition(t, registry); strings.Contains(metrics, two.endpoint()) {
+		t.Errorf("series of the vanished target remain:\n%s", metrics)
+	}
+}
+
+func TestRunFileDiscovery(t *testing.T) {
+	one, two := newAdGuardStub(t), newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "targets.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"targets": ["` + one.endpoint() + `"]}]`)
+
+	s, _ := newTestTargetSet()
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		s.RunFileDiscovery(ctx, path, time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "the first target", func() bool { return slices.Equal(endpoints(s), []string{one.endpoint()}) })
+
+	// a burst of writes is read once it settles
+	want := []string{one.endpoint(), two.endpoint()}
+	slices.Sort(want)
+	write(`[]`)
+	write(`[{"targets": ["` + two.endpoint() + `"]}]`)
+	write(`[{"targets": ["` + one.endpoint() + `", "` + two.endpoint() + `"]}]`)
+	time.Sleep(discoveryDebounce / 5)
+	if got := endpoints(s); !slices.Equal(got, []string{one.endpoint()}) {
+		t.Errorf("targets changed to %v before the writes settled", got)
+	}
+	waitFor(t, "both targets", func() bool { return slices.Equal(endpoints(s), want) })
+
+	// a broken file keeps the targets
+	write(`[{"targets": [`)
+	time.Sleep(2 * discoveryDebounce)
+	if got := endpoints(s); !slices.Equal(got, want) {
+		t.Errorf("got targets %v after a broken file, want %v", got, want)
+	}
+
+	write(`[{"targets": ["` + two.endpoint() + `"]}]`)
+	waitFor(t, "the first target to vanish", func() bool { return slices.Equal(endpoints(s), []string{two.endpoint()}) })
+}
+
+// serveSRV answers SRV queries for name with records, other names with
+// NXDOMAIN, and returns the address of the server.
+func serveSRV(t *testing.T, name string, records []*dns.SRV) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		res := new(dns.Msg)
+		res.SetReply(req)
+		if req.Question[0].Name != dns.Fqdn(name) {
+			res.Rcode = dns.RcodeNameError
+		}
+		for _, srv := range records {
+			if res.Rcode == dns.RcodeSuccess {
+				srv.Hdr = dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: srv.Hdr.Ttl}
+				res.Answer = append(res.Answer, srv)
+			}
+		}
+		w.WriteMsg(res)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestLookupSRV(t *testing.T) {
+	addr := serveSRV(t, "_adguard._tcp.home.arpa", []*dns.SRV{
+		{Hdr: dns.RR_Header{Ttl: 300}, Target: "adguard1.home.arpa.", Port: 3000},
+		{Hdr: dns.RR_Header{Ttl: 60}, Target: "adguard2.home.arpa.", Port: 80},
+	})
+
+	targets, ttl, err := lookupSRV(t.Context(), "_adguard._tcp.home.arpa", addr, "https")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, target := range targets {
+		got = append(got, target.Scheme+"://"+target.Endpoint)
+	}
+	if want := []string{"https://adguard1.home.arpa:3000", "https://adguard2.home.arpa:80"}; !slices.Equal(got, want) {
+		t.Errorf("got targets %v, want %v", got, want)
+	}
+	if ttl != time.Minute {
+		t.Errorf("got TTL %v, want the smallest one, 1m", ttl)
+	}
+
+	targets, ttl, err = lookupSRV(t.Context(), "_other._tcp.home.arpa", addr, "https")
+	if err != nil || targets != nil || ttl != 0 {
+		t.Errorf("unknown name: got %v, %v, %v, want no targets", targets, ttl, err)
+	}
+}
+
+func TestSRVRefresh(t *testing.T) {
+	for _, tc := range []struct {
+		ttl, want time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{time.Second, minSRVRefresh},
+		{time.Minute, time.Minute},
+		{time.Hour, 5 * time.Minute},
+	} {
+		if got := srvRefresh(tc.ttl, 5*time.Minute); got != tc.want {
+			t.Errorf("srvRefresh(%v): got %v, want %v", tc.ttl, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// distinctSlots is the number of slots a distinctWindow is divided into,
+// values expire one slot at a time.
+const distinctSlots = 12
+
+// hllPrecision gives 4096 registers, about 1.6% standard error.
+const hllPrecision = 12
+
+// hyperLogLog estimates the number of distinct values in fixed memory.
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return estimate
+}
+
+type distinctSlot struct {
+	start  time.Time
+	exact  map[string]struct{}
+	sketch *hyperLogLog
+}
+
+// distinctWindow counts distinct values seen in a sliding window. Values are
+// kept exactly up to exactLimit per slot, beyond that a slot switches to a
+// HyperLogLog sketch so memory stays bounded.
+type distinctWindow struct {
+	window     time.Duration
+	exactLimit int
+	seed       maphash.Seed
+	slots      []*distinctSlot
+}
+
+func newDistinctWindow(window time.Duration, exactLimit int) *distinctWindow {
+	return &distinctWindow{
+		window:     window,
+		exactLimit: exactLimit,
+		seed:       maphash.MakeSeed(),
+	}
+}
+
+func (d *distinctWindow) slotWidth() time.Duration {
+	return max(d.window/distinctSlots, time.Second)
+}
+
+func (d *distinctWindow) add(value string, t time.Time) {
+	start := t.Truncate(d.slotWidth())
+
+	var slot *distinctSlot
+	for _, s := range d.slots {
+		if s.start.Equal(start) {
+			slot = s
+		}
+	}
+	if slot == nil {
+		slot = &distinctSlot{start: start, exact: map[string]struct{}{}}
+		d.slots = append(d.slots, slot)
+	}
+
+	if slot.sketch != nil {
+		slot.sketch.add(maphash.String(d.seed, value))
+		return
+	}
+
+	slot.exact[value] = struct{}{}
+	if len(slot.exact) > d.exactLimit {
+		slot.sketch = d.sketch(slot.exact)
+		slot.exact = nil
+	}
+}
+
+func (d *distinctWindow) sketch(values map[string]struct{}) *hyperLogLog {
+	h := &hyperLogLog{}
+	for v := range values {
+		h.add(maphash.String(d.seed, v))
+	}
+	return h
+}
+
+// expire drops the slots that ended before the window.
+func (d *distinctWindow) expire(now time.Time) {
+	var slots []*distinctSlot
+	for _, s := range d.slots {
+		if s.start.Add(d.slotWidth()).After(now.Add(-d.window)) {
+			slots = append(slots, s)
+		}
+	}
+	d.slots = slots
+}
+
+// count returns the number of distinct values in the window and whether it's
+// an estimate.
+func (d *distinctWindow) count(now time.Time) (float64, bool) {
+	d.expire(now)
+
+	union := map[string]struct{}{}
+	for _, s := range d.slots {
+		if s.sketch != nil {
+			union = nil
+			break
+		}
+		for v := range s.exact {
+			union[v] = struct{}{}
+		}
+	}
+	if union != nil && len(union) <= d.exactLimit {
+		return float64(len(union)), false
+	}
+
+	h := &hyperLogLog{}
+	for _, s := range d.slots {
+		if s.sketch != nil {
+			h.merge(s.sketch)
+		} else {
+			h.merge(d.sketch(s.exact))
+		}
+	}
+
+	return math.Round(h.estimate()), true
+}
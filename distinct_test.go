@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDistinctWindowExpiry(t *testing.T) {
+	d := newDistinctWindow(time.Hour, 100)
+	t0 := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	d.add("10.0.0.1", t0)
+	d.add("10.0.0.2", t0.Add(30*time.Minute))
+	d.add("10.0.0.1", t0.Add(40*time.Minute))
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{45 * time.Minute, 2},
+		// the first slot of 10.0.0.1 expired, its second sighting remains
+		{70 * time.Minute, 2},
+		{95 * time.Minute, 1},
+		{105 * time.Minute, 0},
+	} {
+		got, estimated := d.count(t0.Add(tc.at))
+		if got != tc.want || estimated {
+			t.Errorf("after %v: got %v (estimated %v), want exactly %v", tc.at, got, estimated, tc.want)
+		}
+	}
+}
+
+func TestDistinctWindowEstimate(t *testing.T) {
+	const limit = 1000
+	d := newDistinctWindow(time.Hour, limit)
+	t0 := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	for i := range limit {
+		d.add(fmt.Sprintf("%d.example", i), t0)
+	}
+	if got, estimated := d.count(t0); got != limit || estimated {
+		t.Fatalf("at the limit: got %v (estimated %v), want exactly %v", got, estimated, limit)
+	}
+
+	// over the limit across slots, each slot still exact
+	for i := range 10 {
+		d.add(fmt.Sprintf("%d.other.example", i), t0.Add(10*time.Minute))
+	}
+	if got, estimated := d.count(t0.Add(10 * time.Minute)); !estimated || math.Abs(got-(limit+10)) > 0.05*(limit+10) {
+		t.Errorf("over the limit across slots: got %v (estimated %v), want an estimate of %v", got, estimated, limit+10)
+	}
+
+	// a slot over the limit switches to a sketch
+	const n = 20000
+	for i := range n {
+		d.add(fmt.Sprintf("%d.more.example", i), t0.Add(20*time.Minute))
+	}
+	want := float64(limit + 10 + n)
+	if got, estimated := d.count(t0.Add(20 * time.Minute)); !estimated || math.Abs(got-want) > 0.05*want {
+		t.Errorf("with a sketch: got %v (estimated %v), want an estimate of %v", got, estimated, want)
+	}
+
+	// and back to exact once the sketch expires
+	if got, estimated := d.count(t0.Add(90 * time.Minute)); got != 0 || estimated {
+		t.Errorf("after expiry: got %v (estimated %v), want exactly 0", got, estimated)
+	}
+}
+
+func TestDistinctMetricsWithoutQueryLog(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	body := exposition(t, registry)
+	if !strings.Contains(body, "adguardhome_dns_queries ") {
+		t.Fatalf("got\n%s\nwant the stats collected", body)
+	}
+	for _, name := range []string{"adguardhome_active_clients", "adguardhome_unique_domains"} {
+		if strings.Contains(body, name) {
+			t.Errorf("got %v without the query log collector", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheOptimisticEnabled = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "cache_optimistic_enabled"),
+		"Whether optimistic caching is enabled (1) or not (0).",
+		nil,
+	)
+	cacheTTLMin = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "cache_ttl_min_seconds"),
+		"Minimum TTL override of cached answers (in seconds, 0 if unset).",
+		nil,
+	)
+	cacheTTLMax = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "cache_ttl_max_seconds"),
+		"Maximum TTL override of cached answers (in seconds, 0 if unset).",
+		nil,
+	)
+	blockedResponseTTL = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "blocked_response_ttl_seconds"),
+		"TTL of the answers to blocked queries (in seconds).",
+		nil,
+	)
+	blockingMode = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "blocking_mode"),
+		"How blocked queries are answered, always 1.",
+		[]string{"mode"},
+	)
+)
+
+// DNSInfoResponse is /control/dns_info. Fields missing from older AdGuard
+// versions are nil and their metrics skipped.
+type DNSInfoResponse struct {
+	UpstreamDNS        []string `json:"upstream_dns"`
+	CacheOptimistic    *bool    `json:"cache_optimistic"`
+	CacheTTLMin        *uint32  `json:"cache_ttl_min"`
+	CacheTTLMax        *uint32  `json:"cache_ttl_max"`
+	BlockingMode       string   `json:"blocking_mode"`
+	BlockedResponseTTL *uint32  `json:"blocked_response_ttl"`
+}
+
+func describeDNSInfo(ch chan<- *prometheus.Desc) {
+	ch <- cacheOptimisticEnabled
+	ch <- cacheTTLMin
+	ch <- cacheTTLMax
+	ch <- blockedResponseTTL
+	ch <- blockingMode
+}
+
+func (e *Exporter) CollectFromDNSInfo(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var res DNSInfoResponse
+	if err := e.get(ctx, "/control/dns_info", &res); err != nil {
+		return err
+	}
+
+	if res.CacheOptimistic != nil {
+		ch <- prometheus.MustNewConstMetric(
+			cacheOptimisticEnabled, prometheus.GaugeValue, boolToFloat(*res.CacheOptimistic),
+		)
+	}
+	for _, ttl := range []struct {
+		desc  *prometheus.Desc
+		value *uint32
+	}{
+		{cacheTTLMin, res.CacheTTLMin},
+		{cacheTTLMax, res.CacheTTLMax},
+		{blockedResponseTTL, res.BlockedResponseTTL},
+	} {
+		if ttl.value != nil {
+			ch <- prometheus.MustNewConstMetric(
+				ttl.desc, prometheus.GaugeValue, float64(*ttl.value),
+			)
+		}
+	}
+
+	if res.BlockingMode != "" {
+		ch <- prometheus.MustNewConstMetric(
+			blockingMode, prometheus.GaugeValue, 1, res.BlockingMode,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestDNSInfoCache(t *testing.T) {
+	names := []string{"adguardhome_cache_optimistic_enabled", "adguardhome_cache_ttl_min_seconds", "adguardhome_cache_ttl_max_seconds"}
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	dnsInfo := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromDNSInfo(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	stub.set("/control/dns_info", map[string]any{
+		"upstream_dns":     []string{"tls://1.1.1.1"},
+		"cache_size":       4194304,
+		"cache_optimistic": true,
+		"cache_ttl_min":    60,
+		"cache_ttl_max":    0,
+	})
+	err := testutil.CollectAndCompare(dnsInfo, strings.NewReader(`
+# HELP adguardhome_cache_optimistic_enabled Whether optimistic caching is enabled (1) or not (0).
+# TYPE adguardhome_cache_optimistic_enabled gauge
+adguardhome_cache_optimistic_enabled 1
+# HELP adguardhome_cache_ttl_max_seconds Maximum TTL override of cached answers (in seconds, 0 if unset).
+# TYPE adguardhome_cache_ttl_max_seconds gauge
+adguardhome_cache_ttl_max_seconds 0
+# HELP adguardhome_cache_ttl_min_seconds Minimum TTL override of cached answers (in seconds, 0 if unset).
+# TYPE adguardhome_cache_ttl_min_seconds gauge
+adguardhome_cache_ttl_min_seconds 60
+`), names...)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// versions before the cache settings
+	stub.set("/control/dns_info", map[string]any{"upstream_dns": []string{"tls://1.1.1.1"}})
+	if n := testutil.CollectAndCount(dnsInfo, names...); n != 0 {
+		t.Errorf("without the fields: got %d series, want none", n)
+	}
+
+	// only with the dns_info collector
+	stub.set("/control/dns_info", map[string]any{"cache_optimistic": true})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	if body := exposition(t, registry); strings.Contains(body, "adguardhome_cache_optimistic_enabled") {
+		t.Errorf("got the cache settings without the dns_info collector:\n%s", body)
+	}
+	e.DNSInfo = true
+	if body := exposition(t, registry); !strings.Contains(body, "adguardhome_cache_optimistic_enabled 1") {
+		t.Errorf("got\n%s\nwant the cache settings with the dns_info collector", body)
+	}
+}
+
+func TestDNSInfoBlockedResponseTTL(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	dnsInfo := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromDNSInfo(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	stub.set("/control/dns_info", map[string]any{
+		"upstream_dns":         []string{"tls://1.1.1.1"},
+		"blocking_mode":        "default",
+		"blocked_response_ttl": 10,
+	})
+	err := testutil.CollectAndCompare(dnsInfo, strings.NewReader(`
+# HELP adguardhome_blocked_response_ttl_seconds TTL of the answers to blocked queries (in seconds).
+# TYPE adguardhome_blocked_response_ttl_seconds gauge
+adguardhome_blocked_response_ttl_seconds 10
+`), "adguardhome_blocked_response_ttl_seconds")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// versions without the field
+	stub.set("/control/dns_info", map[string]any{"upstream_dns": []string{"tls://1.1.1.1"}})
+	if n := testutil.CollectAndCount(dnsInfo, "adguardhome_blocked_response_ttl_seconds"); n != 0 {
+		t.Errorf("without the field: got %d series, want none", n)
+	}
+}
+
+func TestDNSInfoBlockingMode(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	dnsInfo := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromDNSInfo(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	stub.set("/control/dns_info", map[string]any{
+		"blocking_mode":        "custom_ip",
+		"blocking_ipv4":        "192.168.1.1",
+		"blocking_ipv6":        "fd00::1",
+		"blocked_response_ttl": 10,
+	})
+	err := testutil.CollectAndCompare(dnsInfo, strings.NewReader(`
+# HELP adguardhome_blocked_response_ttl_seconds TTL of the answers to blocked queries (in seconds).
+# TYPE adguardhome_blocked_response_ttl_seconds gauge
+adguardhome_blocked_response_ttl_seconds 10
+# HELP adguardhome_blocking_mode How blocked queries are answered, always 1.
+# TYPE adguardhome_blocking_mode gauge
+adguardhome_blocking_mode{mode="custom_ip"} 1
+`), "adguardhome_blocking_mode", "adguardhome_blocked_response_ttl_seconds")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// versions before blocking modes
+	stub.set("/control/dns_info", map[string]any{"upstream_dns": []string{"tls://1.1.1.1"}})
+	if n := testutil.CollectAndCount(dnsInfo, "adguardhome_blocking_mode", "adguardhome_blocked_response_ttl_seconds"); n != 0 {
+		t.Errorf("without the fields: got %d series, want none", n)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	docker "github.com/docker/docker/client"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dockerLabelPrefix starts the container labels read by Docker discovery.
+	dockerLabelPrefix = "adguardhome-exporter."
+
+	// dockerDefaultPort is the port of the AdGuard Home image's web interface.
+	dockerDefaultPort = "80"
+)
+
+// newDockerClient returns a client for the daemon of DOCKER_HOST,
+// DOCKER_TLS_VERIFY and DOCKER_CERT_PATH, the local one by default.
+func newDockerClient() (*docker.Client, error) {
+	return docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+}
+
+// dockerTargets returns the targets of containers labeled
+// adguardhome-exporter.scrape=true at their address in network, in the
+// first network with an address if empty. Containers that can't be
+// scraped are logged and skipped.
+func dockerTargets(containers []container.Summary, network, scheme string) []*target {
+	var targets []*target
+	for _, c := range containers {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		label := func(key string) string {
+			return c.Labels[dockerLabelPrefix+key]
+		}
+		if label("scrape") != "true" {
+			continue
+		}
+
+		port := label("port")
+		if port == "" {
+			port = dockerDefaultPort
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			slog.Error(fmt.Sprintf("Invalid %vport of container %v: %q", dockerLabelPrefix, name, port))
+			continue
+		}
+
+		t := &target{Scheme: scheme, Module: label("auth-module")}
+		if s := label("scheme"); s != "" {
+			if s != "http" && s != "https" {
+				slog.Error(fmt.Sprintf("Invalid %vscheme of container %v: %q", dockerLabelPrefix, name, s))
+				continue
+			}
+			t.Scheme = s
+		}
+
+		ip := dockerAddress(c, network)
+		if ip == "" {
+			slog.Error(fmt.Sprintf("Container %v has no address in network %q", name, network))
+			continue
+		}
+		t.Endpoint = net.JoinHostPort(ip, port)
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// dockerAddress returns the IP address of c in network, or in the first
+// network by name with one if network is empty.
+func dockerAddress(c container.Summary, network string) string {
+	if c.NetworkSettings == nil {
+		return ""
+	}
+	networks := c.NetworkSettings.Networks
+	if network != "" {
+		if settings := networks[network]; settings != nil {
+			return settings.IPAddress
+		}
+		return ""
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if settings := networks[name]; settings != nil && settings.IPAddress != "" {
+			return settings.IPAddress
+		}
+	}
+	return ""
+}
+
+// RunDockerDiscovery keeps the targets of labeled Docker containers up to
+// date until ctx is done. The containers are listed again when one starts
+// or dies, and every interval in case an event went missing. A lost
+// connection is retried with a backoff up to interval, the targets stay as
+// they were meanwhile.
+func (s *TargetSet) RunDockerDiscovery(ctx context.Context, cli *docker.Client, network string, interval time.Duration) {
+	source := "docker:" + cli.DaemonHost()
+	scrape := filters.Arg("label", dockerLabelPrefix+"scrape=true")
+	refresh := func() error {
+		containers, err := cli.ContainerList(ctx, container.ListOptions{Filters: filters.NewArgs(scrape)})
+		if err != nil {
+			return err
+		}
+		s.Update(ctx, source, dockerTargets(containers, network, s.Exporter.Scheme))
+		return nil
+	}
+
+	backoff := time.Second
+	for {
+		// subscribe before listing, so no start or stop falls in between
+		eventCtx, cancel := context.WithCancel(ctx)
+		messages, errs := cli.Events(eventCtx, events.ListOptions{Filters: filters.NewArgs(
+			scrape,
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", string(events.ActionStart)),
+			filters.Arg("event", string(events.ActionDie)),
+		)})
+		err := refresh()
+		if err == nil {
+			backoff = time.Second
+			err = followDockerEvents(ctx, messages, errs, refresh, interval)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Error(fmt.Sprintf("Docker discovery from %v failed, retrying in %v: %v", cli.DaemonHost(), backoff, err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, interval)
+	}
+}
+
+// followDockerEvents refreshes on container events, debounced, and every
+// interval until the event stream or a refresh fails. It returns nil when
+// ctx is done.
+func followDockerEvents(ctx context.Context, messages <-chan events.Message, errs <-chan error, refresh func() error, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	debounce := time.NewTimer(0)
+	<-debounce.C
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case <-messages:
+			debounce.Reset(discoveryDebounce)
+		case <-debounce.C:
+			if err := refresh(); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := refresh(); err != nil {
+				return err
+			}
+		}
+	}
+}
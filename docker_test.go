@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dockerContainer returns a container with the labels and an address per
+// network.
+func dockerContainer(name string, labels map[string]string, addresses map[string]string) container.Summary {
+	networks := map[string]*network.EndpointSettings{}
+	for name, ip := range addresses {
+		networks[name] = &network.EndpointSettings{IPAddress: ip}
+	}
+	return container.Summary{
+		ID:              name + "-id",
+		Names:           []string{"/" + name},
+		Labels:          labels,
+		NetworkSettings: &container.NetworkSettingsSummary{Networks: networks},
+	}
+}
+
+func TestDockerTargets(t *testing.T) {
+	scrape := func(labels ...string) map[string]string {
+		m := map[string]string{dockerLabelPrefix + "scrape": "true"}
+		for i := 0; i+1 < len(labels); i += 2 {
+			m[dockerLabelPrefix+labels[i]] = labels[i+1]
+		}
+		return m
+	}
+	containers := []container.Summary{
+		dockerContainer("home", scrape("port", "3000", "auth-module", "home"), map[string]string{"bridge": "172.17.0.2"}),
+		dockerContainer("default-port", scrape(), map[string]string{"frontend": "172.18.0.3", "backend": "172.19.0.3"}),
+		dockerContainer("tls", scrape("scheme", "https", "port", "443"), map[string]string{"backend": "172.19.0.4"}),
+		dockerContainer("unlabeled", nil, map[string]string{"bridge": "172.17.0.5"}),
+		dockerContainer("off", map[string]string{dockerLabelPrefix + "scrape": "false"}, map[string]string{"bridge": "172.17.0.6"}),
+		dockerContainer("bad-port", scrape("port", "http"), map[string]string{"bridge": "172.17.0.7"}),
+		dockerContainer("bad-scheme", scrape("scheme", "ftp"), map[string]string{"bridge": "172.17.0.8"}),
+		dockerContainer("no-address", scrape(), map[string]string{"bridge": ""}),
+		{ID: "no-networks", Labels: scrape()},
+	}
+
+	for _, tc := range []struct {
+		network string
+		want    []*target
+	}{
+		// the first network by name with an address
+		{"", []*target{
+			{Scheme: "http", Endpoint: "172.17.0.2:3000", Module: "home"},
+			{Scheme: "http", Endpoint: "172.19.0.3:80"},
+			{Scheme: "https", Endpoint: "172.19.0.4:443"},
+		}},
+		{"backend", []*target{
+			{Scheme: "http", Endpoint: "172.19.0.3:80"},
+			{Scheme: "https", Endpoint: "172.19.0.4:443"},
+		}},
+	} {
+		if got := dockerTargets(containers, tc.network, "http"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("network %q: got %+v, want %+v", tc.network, got, tc.want)
+		}
+	}
+}
+
+// dockerAPI is a Docker daemon serving the containers and streaming the
+// events sent to it, until the stream is dropped.
+type dockerAPI struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	containers []container.Summary
+	streams    int
+	events     chan events.Message
+	drop       chan struct{}
+}
+
+func newDockerAPI(t *testing.T) *dockerAPI {
+	t.Helper()
+
+	d := &dockerAPI{events: make(chan events.Message), drop: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.45/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("filters"), dockerLabelPrefix+"scrape=true") {
+			t.Errorf("got filters %q, want the scrape label", r.URL.Query().Get("filters"))
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		json.NewEncoder(w).Encode(d.containers)
+	})
+	mux.HandleFunc("/v1.45/events", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.streams++
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case m := <-d.events:
+				json.NewEncoder(w).Encode(m)
+				w.(http.Flusher).Flush()
+			case <-d.drop:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	d.Server = httptest.NewServer(mux)
+	t.Cleanup(d.Close)
+	return d
+}
+
+func (d *dockerAPI) setContainers(containers ...container.Summary) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.containers = containers
+}
+
+func (d *dockerAPI) streamCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.streams
+}
+
+func TestRunDockerDiscovery(t *testing.T) {
+	one, two := newAdGuardStub(t), newAdGuardStub(t)
+	adguard := func(stub *adguardStub) container.Summary {
+		_, port, _ := strings.Cut(stub.endpoint(), ":")
+		return dockerContainer("adguard-"+port, map[string]string{
+			dockerLabelPrefix + "scrape": "true",
+			dockerLabelPrefix + "port":   port,
+		}, map[string]string{"bridge": "127.0.0.1"})
+	}
+	api := newDockerAPI(t)
+	api.setContainers(adguard(one))
+	cli, err := docker.NewClientWithOpts(docker.WithHost("tcp://"+api.Listener.Addr().String()), docker.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	s, _ := newTestTargetSet()
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		s.RunDockerDiscovery(ctx, cli, "", time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "the running container", func() bool { return slices.Equal(endpoints(s), []string{one.endpoint()}) })
+
+	// a container starting
+	api.setContainers(adguard(one), adguard(two))
+	api.events <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart, Actor: events.Actor{ID: "adguard-id"}}
+	want := []string{one.endpoint(), two.endpoint()}
+	slices.Sort(want)
+	waitFor(t, "the started container", func() bool { return slices.Equal(endpoints(s), want) })
+
+	// a container dying while the event stream is down, caught up on
+	// reconnecting
+	close(api.drop)
+	api.setContainers(adguard(two))
+	waitFor(t, "the event stream to reconnect", func() bool { return api.streamCount() >= 2 })
+	waitFor(t, "the stopped container", func() bool { return slices.Equal(endpoints(s), []string{two.endpoint()}) })
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"strings"
+)
+
+// domainLabeler returns the function mapping domains to label values for
+// an aggregation mode: "exact" keeps domains as they are, "etld+1" collapses
+// them to their registrable domain.
+func domainLabeler(mode string) (func(string) string, error) {
+	switch mode {
+	case "exact":
+		return exactDomain, nil
+	case "etld+1":
+		return etldPlusOne, nil
+	}
+
+	return nil, fmt.Errorf("unknown domain aggregation %q", mode)
+}
+
+func exactDomain(domain string) string {
+	return domain
+}
+
+// etldPlusOne maps a domain to its registrable domain using the public
+// suffix list, e.g. r3---sn-xyz.googlevideo.com to googlevideo.com. IP
+// literals, single-label names and public suffixes pass through untouched.
+func etldPlusOne(domain string) string {
+	name := strings.ToLower(strings.TrimSuffix(domain, "."))
+	if net.ParseIP(name) != nil || !strings.Contains(name, ".") {
+		return domain
+	}
+
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return domain
+	}
+
+	return registrable
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETLDPlusOne(t *testing.T) {
+	for _, tc := range []struct{ domain, want string }{
+		{"r3---sn-xyz.googlevideo.com", "googlevideo.com"},
+		{"googlevideo.com", "googlevideo.com"},
+		{"a.b.example.co.uk", "example.co.uk"},
+		{"co.uk", "co.uk"},
+		{"www.example.com.", "example.com"},
+		{"WWW.Example.COM", "example.com"},
+		{"shop.bücher.de", "bücher.de"},
+		{"shop.xn--bcher-kva.de", "xn--bcher-kva.de"},
+		{"user.github.io", "user.github.io"},
+		{"localhost", "localhost"},
+		{"router.", "router."},
+		{"192.168.1.1", "192.168.1.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"", ""},
+	} {
+		if got := etldPlusOne(tc.domain); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.domain, got, tc.want)
+		}
+	}
+}
+
+func TestDomainAggregationConsistent(t *testing.T) {
+	label, err := domainLabeler("etld+1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := domainLabeler("etld"); err == nil {
+		t.Error("unknown mode: got no error")
+	}
+
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.DomainLabel = label
+	stats := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.collect(t.Context(), ch, "stats"); err != nil {
+			t.Error(err)
+		}
+	})
+	err = testutil.CollectAndCompare(stats, strings.NewReader(`
+# HELP adguardhome_top_queried_domains Number of DNS queries for the top queried domains in the stats window.
+# TYPE adguardhome_top_queried_domains gauge
+adguardhome_top_queried_domains{domain="example.co.uk"} 3
+adguardhome_top_queried_domains{domain="example.org"} 5
+`), "adguardhome_top_queried_domains")
+	if err != nil {
+		t.Error(err)
+	}
+
+	q := NewQueryLog(1000, []float64{0.01})
+	q.DomainLabel = label
+	now := time.Now()
+	q.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+	q.Update(queries(now, "10.0.0.1", "a.b.example.co.uk", "www.example.org", "example.org."))
+	err = testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_querylog_domain_queries_total DNS queries per domain from the query log.
+# TYPE adguardhome_querylog_domain_queries_total counter
+adguardhome_querylog_domain_queries_total{domain="example.co.uk"} 1
+adguardhome_querylog_domain_queries_total{domain="example.org"} 2
+`), "adguardhome_querylog_domain_queries_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxCachedExpositions bounds the representations kept per generation, one
+// per combination of Accept and Accept-Encoding headers.
+const maxCachedExpositions = 16
+
+// ExpositionCache answers 304 when the client has the last full response of
+// the current generation of the CachedCollectors already. The ETag is strong,
+// a hash of the exact bytes of the response, kept once per generation and
+// representation, so gzip and the OpenMetrics format each get their own.
+// The exporter's own metrics change with every request, so each full
+// response gets a new ETag; a 304 only tells the target metrics didn't
+// change since that response.
+type ExpositionCache struct {
+	Generation func() uint64
+
+	mu         sync.Mutex
+	generation uint64
+	etags      map[string]string
+}
+
+// bufferedResponse keeps a response in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (c *ExpositionCache) get(key string, generation uint64) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return ""
+	}
+	return c.etags[key]
+}
+
+func (c *ExpositionCache) put(key string, generation uint64, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case generation < c.generation:
+		// rendered before a newer snapshot came in
+		return
+	case generation != c.generation || c.etags == nil:
+		c.generation = generation
+		c.etags = map[string]string{}
+	}
+	if _, ok := c.etags[key]; ok || len(c.etags) < maxCachedExpositions {
+		c.etags[key] = etag
+	}
+}
+
+// strongETag returns the strong ETag of body.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchETag tells whether an If-None-Match header lists etag, compared
+// byte for byte, a weak validator never matches.
+func matchETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap returns next, the handler of all metrics, answering 304 while the
+// target metrics didn't change.
+func (c *ExpositionCache) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		generation := c.Generation()
+		key := r.Header.Get("Accept") + "\n" + r.Header.Get("Accept-Encoding")
+		w.Header().Set("Vary", "Accept, Accept-Encoding")
+
+		// a 304 carries no representation headers besides these
+		if etag := c.get(key, generation); etag != "" && matchETag(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		buf := &bufferedResponse{header: http.Header{}}
+		next.ServeHTTP(buf, r)
+		maps.Copy(w.Header(), buf.header)
+		// only a complete exposition is worth revalidating
+		if buf.status == http.StatusOK {
+			etag := strongETag(buf.body.Bytes())
+			c.put(key, generation, etag)
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExpositionCache(t *testing.T) {
+	targets := prometheus.NewRegistry()
+	queries := prometheus.NewGauge(prometheus.GaugeOpts{Name: "adguardhome_queries", Help: "Queries."})
+	targets.MustRegister(queries)
+	self := prometheus.NewRegistry()
+	scrapes := prometheus.NewCounter(prometheus.CounterOpts{Name: "exporter_scrapes_total", Help: "Scrapes."})
+	self.MustRegister(scrapes)
+
+	var generation atomic.Uint64
+	cache := &ExpositionCache{Generation: generation.Load}
+	handler := cache.Wrap(promhttp.HandlerFor(prometheus.Gatherers{self, targets}, promhttp.HandlerOpts{}))
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		scrapes.Inc()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag != strongETag(first.Body.Bytes()) {
+		t.Fatalf("first scrape: got %d with ETag %q, want the hash of the body", first.Code, etag)
+	}
+	if !strings.Contains(first.Body.String(), "exporter_scrapes_total 1") {
+		t.Errorf("first scrape lacks the self metrics:\n%s", first.Body)
+	}
+
+	if rec := get(etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("revalidation: got %d with %d bytes and ETag %q, want an empty 304 with %q",
+			rec.Code, rec.Body.Len(), rec.Header().Get("ETag"), etag)
+	}
+	// only the exact ETag matches
+	for _, ifNoneMatch := range []string{"W/" + etag, strings.ToUpper(etag), strings.Trim(etag, `"`)} {
+		if rec := get(ifNoneMatch); rec.Code != http.StatusOK {
+			t.Errorf("If-None-Match %q: got %d, want 200", ifNoneMatch, rec.Code)
+		}
+	}
+
+	// the self metrics are fresh, and with them the ETag
+	rec := get("")
+	if got := rec.Header().Get("ETag"); got == etag || got != strongETag(rec.Body.Bytes()) {
+		t.Errorf("got ETag %q, want the hash of the new body", got)
+	}
+	if !strings.Contains(rec.Body.String(), "exporter_scrapes_total 6") {
+		t.Errorf("self metrics not gathered fresh:\n%s", rec.Body)
+	}
+	etag = rec.Header().Get("ETag")
+
+	queries.Set(42)
+	generation.Add(1)
+	rec = get(etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("new snapshot: got %d with ETag %q, want 200 with a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+	if !strings.Contains(rec.Body.String(), "adguardhome_queries 42") {
+		t.Errorf("new snapshot not served:\n%s", rec.Body)
+	}
+}
+
+func TestExpositionCacheRepresentations(t *testing.T) {
+	targets := prometheus.NewRegistry()
+	targets.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "adguardhome_queries", Help: "Queries."}))
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: true}
+	cache := &ExpositionCache{Generation: func() uint64 { return 1 }}
+	handler := cache.Wrap(promhttp.HandlerFor(targets, opts))
+
+	etags := map[string]bool{}
+	for _, header := range []struct{ accept, encoding string }{
+		{"", ""},
+		{"", "gzip"},
+		{"application/openmetrics-text; version=1.0.0", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", header.accept)
+		req.Header.Set("Accept-Encoding", header.encoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept %q, Accept-Encoding %q: got %d", header.accept, header.encoding, rec.Code)
+		}
+		etags[rec.Header().Get("ETag")] = true
+	}
+	if len(etags) != 3 {
+		t.Errorf("got %d ETags for 3 representations", len(etags))
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x" ,"abc" `, true},
+		{`*`, true},
+		{`W/"abc"`, false},
+		{`"ABC"`, false},
+		{`abc`, false},
+		{`"abcd"`, false},
+		{``, false},
+	} {
+		if got := matchETag(tc.header, `"abc"`); got != tc.want {
+			t.Errorf("matchETag(%q): got %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
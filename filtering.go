@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	filteringEnabled = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "filtering_enabled"),
+		"Whether filtering with the block lists is enabled (1) or not (0), independent of protection.",
+		nil,
+	)
+	filterLastUpdated = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "filter_last_updated_timestamp_seconds"),
+		"When an enabled filter list was last updated.",
+		[]string{"id", "name"},
+	)
+	filtersStale = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "filters_stale"),
+		"Number of enabled filter lists not updated within the threshold.",
+		[]string{"threshold"},
+	)
+	totalRules = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "total_rules"),
+		"Number of rules of the enabled block lists plus the user rules.",
+		nil,
+	)
+)
+
+// FilteringStatusResponse is /control/filtering/status.
+type FilteringStatusResponse struct {
+	Enabled          bool     `json:"enabled"`
+	Filters          []Filter `json:"filters"`
+	WhitelistFilters []Filter `json:"whitelist_filters"`
+	UserRules        []string `json:"user_rules"`
+}
+
+// Filter is a block or allow list. LastUpdated is RFC 3339 and empty before
+// the first download.
+type Filter struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	RulesCount  int    `json:"rules_count"`
+	LastUpdated string `json:"last_updated"`
+}
+
+func describeFiltering(ch chan<- *prometheus.Desc) {
+	ch <- filteringEnabled
+	ch <- filterLastUpdated
+	ch <- filtersStale
+	ch <- totalRules
+}
+
+// totalRules counts the rules of the enabled block lists and the user rules,
+// leaving out blank lines and comments.
+func (res *FilteringStatusResponse) totalRules() int {
+	total := 0
+	for _, filter := range res.Filters {
+		if filter.Enabled {
+			total += filter.RulesCount
+		}
+	}
+	for _, rule := range res.UserRules {
+		rule = strings.TrimSpace(rule)
+		if rule != "" && !strings.HasPrefix(rule, "!") && !strings.HasPrefix(rule, "#") {
+			total++
+		}
+	}
+	return total
+}
+
+func (e *Exporter) CollectFromFiltering(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var res FilteringStatusResponse
+	if err := e.get(ctx, "/control/filtering/status", &res); err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		filteringEnabled, prometheus.GaugeValue, boolToFloat(res.Enabled),
+	)
+
+	now := time.Now()
+	stale := 0
+	for _, filter := range append(res.Filters, res.WhitelistFilters...) {
+		if !filter.Enabled {
+			continue
+		}
+		// a filter never downloaded counts as stale
+		updated, err := time.Parse(time.RFC3339, filter.LastUpdated)
+		if err != nil || now.Sub(updated) > e.FilterStaleAfter {
+			stale++
+		}
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			filterLastUpdated, prometheus.GaugeValue, float64(updated.Unix()),
+			strconv.FormatInt(filter.ID, 10), filter.Name,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		totalRules, prometheus.GaugeValue, float64(res.totalRules()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		filtersStale, prometheus.GaugeValue, float64(stale), e.FilterStaleAfter.String(),
+	)
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilteringEnabled(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Status, e.Filtering = &Status{}, true
+
+	for _, tc := range []struct {
+		name                  string
+		protection, filtering bool
+		want                  []string
+	}{
+		{"filtering lists disabled", true, false, []string{"adguardhome_filtering_enabled 0", "adguardhome_protection_enabled 1"}},
+		{"protection off", false, true, []string{"adguardhome_filtering_enabled 1", "adguardhome_protection_enabled 0"}},
+	} {
+		stub.set("/control/status", map[string]any{"version": "v0.107.52", "protection_enabled": tc.protection, "running": true})
+		stub.set("/control/filtering/status", map[string]any{"enabled": tc.filtering, "filters": []any{}})
+
+		var got []string
+		for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) {
+			if err := e.collect(t.Context(), ch); err != nil {
+				t.Error(err)
+			}
+		}) {
+			if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_filtering_enabled ") || strings.HasPrefix(line, "adguardhome_protection_enabled ") {
+				got = append(got, line)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%v: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFiltersStale(t *testing.T) {
+	stub := newAdGuardStub(t)
+	now := time.Now().Truncate(time.Second)
+	fresh, stale := now.Add(-time.Hour), now.Add(-10*24*time.Hour)
+	stub.set("/control/filtering/status", map[string]any{
+		"enabled": true,
+		"filters": []map[string]any{
+			{"id": 1, "name": "Fresh", "enabled": true, "rules_count": 10, "last_updated": fresh.Format(time.RFC3339)},
+			{"id": 2, "name": "Stale", "enabled": true, "rules_count": 10, "last_updated": stale.Format(time.RFC3339)},
+			{"id": 3, "name": "Never downloaded", "enabled": true, "rules_count": 0, "last_updated": ""},
+			{"id": 4, "name": "Disabled", "enabled": false, "rules_count": 10, "last_updated": stale.Format(time.RFC3339)},
+		},
+		"whitelist_filters": []map[string]any{
+			{"id": 5, "name": "Allow", "enabled": true, "rules_count": 2, "last_updated": stale.Format(time.RFC3339)},
+		},
+	})
+	e := NewExporter(stub.endpoint(), "", "")
+	e.FilterStaleAfter = 7 * 24 * time.Hour
+
+	var got []string
+	for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromFiltering(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	}) {
+		if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_filter") {
+			got = append(got, line)
+		}
+	}
+	slices.Sort(got)
+	want := []string{
+		fmt.Sprintf(`adguardhome_filter_last_updated_timestamp_seconds{id="1",name="Fresh"} %v`, float64(fresh.Unix())),
+		fmt.Sprintf(`adguardhome_filter_last_updated_timestamp_seconds{id="2",name="Stale"} %v`, float64(stale.Unix())),
+		fmt.Sprintf(`adguardhome_filter_last_updated_timestamp_seconds{id="5",name="Allow"} %v`, float64(stale.Unix())),
+		`adguardhome_filtering_enabled 1`,
+		// the stale, never downloaded and allow lists, not the disabled one
+		`adguardhome_filters_stale{threshold="168h0m0s"} 3`,
+	}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTotalRules(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		res  FilteringStatusResponse
+		want int
+	}{
+		{"enabled filters and user rules", FilteringStatusResponse{
+			Filters: []Filter{
+				{ID: 1, Enabled: true, RulesCount: 1000},
+				{ID: 2, Enabled: true, RulesCount: 234},
+				{ID: 3, Enabled: false, RulesCount: 5000},
+			},
+			// allow lists don't block
+			WhitelistFilters: []Filter{{ID: 4, Enabled: true, RulesCount: 50}},
+			UserRules:        []string{"||ads.example.com^", "", "  ", "! a comment", "# another", "@@||example.org^"},
+		}, 1236},
+		{"nil lists", FilteringStatusResponse{}, 0},
+	} {
+		if got := tc.res.totalRules(); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	// as collected from a fixture
+	stub := newAdGuardStub(t)
+	stub.set("/control/filtering/status", map[string]any{
+		"enabled":    true,
+		"filters":    []map[string]any{{"id": 1, "enabled": true, "rules_count": 1000}, {"id": 2, "enabled": false, "rules_count": 10}},
+		"user_rules": []string{"||ads.example.com^", ""},
+	})
+	e := NewExporter(stub.endpoint(), "", "")
+	var got []string
+	for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromFiltering(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	}) {
+		if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_total_rules ") {
+			got = append(got, line)
+		}
+	}
+	if !slices.Equal(got, []string{"adguardhome_total_rules 1001"}) {
+		t.Errorf("got %q, want adguardhome_total_rules 1001", got)
+	}
+}
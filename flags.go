@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+)
+
+// stringsFlag is a repeatable flag, each value taken as it is.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// setList sets a comma separated list, as a repeatable flag takes from env,
+// one value at a time.
+func (s *stringsFlag) setList(list string) {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			s.Set(v)
+		}
+	}
+}
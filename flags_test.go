@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStringsFlag(t *testing.T) {
+	var s stringsFlag
+	s.Set("Accept: text/plain, application/json")
+	s.Set("site=eu,west")
+	if want := []string{"Accept: text/plain, application/json", "site=eu,west"}; !slices.Equal(s, want) {
+		t.Errorf("command line: got %q, want %q", s, want)
+	}
+
+	s = nil
+	s.setList(" http://a:3000, ,http://b:3000 ")
+	if want := []string{"http://a:3000", "http://b:3000"}; !slices.Equal(s, want) {
+		t.Errorf("env: got %q, want %q", s, want)
+	}
+}
+
+func TestFlagValuesWithCommas(t *testing.T) {
+	stub := newAdGuardStub(t)
+	var (
+		mu   sync.Mutex
+		seen http.Header
+	)
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = r.Header.Clone()
+		mu.Unlock()
+		w.Write([]byte(`{"num_dns_queries": 100}`))
+	}))
+	config := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(config, []byte("target:\n  - url: "+stub.URL+"\n    username: admin\n    password: se,cret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"-target", "http://admin:se,cret@" + stub.endpoint()},
+		{"-config.file", config},
+	} {
+		base := runExporter(t, append(args,
+			"-header", "Accept: text/plain, application/json", "-metrics.const-label", "site=eu,west")...)
+		res, err := http.Get(base + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if !strings.Contains(string(body), `adguardhome_dns_queries{site="eu,west"} 100`) {
+			t.Errorf("%q: got\n%s\nwant the label value with its comma", args, body)
+		}
+		mu.Lock()
+		for name, want := range map[string]string{
+			"Authorization": basicAuthHeader("admin", "se,cret"),
+			"Accept":        "text/plain, application/json",
+		} {
+			if got := seen.Get(name); got != want {
+				t.Errorf("%q: %v: got %q, want %q", args, name, got, want)
+			}
+		}
+		mu.Unlock()
+	}
+
+	// env still takes a list
+	other := newAdGuardStub(t)
+	t.Setenv("ADGUARD_TARGET", stub.URL+", "+other.URL)
+	base := runExporter(t)
+	res, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	for _, s := range []*adguardStub{stub, other} {
+		if want := `adguardhome_up{target="` + s.endpoint() + `"} 1`; !strings.Contains(string(body), want) {
+			t.Errorf("got\n%s\nwant %s", body, want)
+		}
+	}
+}
+
+func TestReloadTargetsWithCommas(t *testing.T) {
+	stub := newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("collector:\n  status: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	s := &TargetSet{Registerer: registry, TargetLabel: true, Exporter: NewExporter("", "", "")}
+	r := newTestReloader(t, s, path)
+	r.TargetURLs = []string{"http://admin:se,cret@" + stub.endpoint()}
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := endpoints(s); !slices.Equal(got, []string{stub.endpoint()}) {
+		t.Fatalf("got targets %v, want the -target kept", got)
+	}
+	exposition(t, registry)
+	if got, want := stub.authorization(), basicAuthHeader("admin", "se,cret"); got != want {
+		t.Errorf("got Authorization %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http/httpguts"
+	"net/http"
+	"strings"
+)
+
+// parseHeaders parses Name: value pairs into headers for the requests to
+// AdGuard, with proxyAuth (user:password) as Proxy-Authorization.
+func parseHeaders(pairs []string, proxyAuth string) (http.Header, error) {
+	headers := http.Header{}
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%q: expected Name: value", name)
+		case !httpguts.ValidHeaderFieldName(name):
+			return nil, fmt.Errorf("%q: invalid header name", name)
+		case !httpguts.ValidHeaderFieldValue(value):
+			return nil, fmt.Errorf("%v: invalid header value", name)
+		}
+		headers.Add(name, value)
+	}
+
+	if proxyAuth != "" {
+		if headers.Get("Proxy-Authorization") != "" {
+			return nil, errors.New("Proxy-Authorization given as a header and by -proxy-auth")
+		}
+		if !strings.Contains(proxyAuth, ":") {
+			return nil, errors.New("-proxy-auth: expected user:password")
+		}
+		headers.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(proxyAuth)))
+	}
+
+	return headers, nil
+}
+
+// checkHeaders rejects headers the exporter sets itself: Authorization
+// unless AdGuard gets no credentials, and Cookie with a session.
+func checkHeaders(headers http.Header, noAuth, session bool) error {
+	if headers.Get("Authorization") != "" && !noAuth {
+		return errors.New("Authorization would replace the AdGuard credentials, use -proxy-auth or another header for a front proxy, or -no-auth")
+	}
+	if headers.Get("Cookie") != "" && session {
+		return errors.New("Cookie would replace the -auth.session cookie")
+	}
+	return nil
+}
+
+// secretHeader tells whether a header likely carries a credential, whose
+// value is redacted.
+func secretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"auth", "cookie", "token", "key", "secret", "password"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeaders adds the configured headers to req, Host as its host.
+func (e *Exporter) setHeaders(req *http.Request) {
+	for name, values := range e.Headers {
+		if name == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders([]string{"X-Proxy-Token: proxytoken", "Host: adguard.internal"}, "proxy:proxypw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"X-Proxy-Token":       "proxytoken",
+		"Host":                "adguard.internal",
+		"Proxy-Authorization": basicAuthHeader("proxy", "proxypw"),
+	} {
+		if got := headers.Get(name); got != want {
+			t.Errorf("%v: got %q, want %q", name, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		pairs     []string
+		proxyAuth string
+		err       string
+	}{
+		{[]string{"X-Proxy-Token"}, "", "expected Name: value"},
+		{[]string{"X Proxy: x"}, "", "invalid header name"},
+		{[]string{"Proxy-Authorization: Basic x"}, "proxy:proxypw", "given as a header and by -proxy-auth"},
+		{nil, "proxy", "expected user:password"},
+	} {
+		if _, err := parseHeaders(tc.pairs, tc.proxyAuth); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q, %q: got %v, want %q", tc.pairs, tc.proxyAuth, err, tc.err)
+		}
+	}
+
+	for _, tc := range []struct {
+		header          string
+		noAuth, session bool
+		ok              bool
+	}{
+		{"Authorization", false, false, false},
+		{"Authorization", true, false, true},
+		{"Cookie", false, true, false},
+		{"Cookie", false, false, true},
+		{"Proxy-Authorization", false, false, true},
+	} {
+		err := checkHeaders(http.Header{tc.header: {"x"}}, tc.noAuth, tc.session)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v with noAuth %v, session %v: got %v", tc.header, tc.noAuth, tc.session, err)
+		}
+	}
+}
+
+func TestProxyAndAdGuardCredentials(t *testing.T) {
+	stub := newAdGuardStub(t)
+	var (
+		mu   sync.Mutex
+		seen http.Header
+	)
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = r.Header.Clone()
+		mu.Unlock()
+		w.Write([]byte(`{"num_dns_queries": 100}`))
+	}))
+	base := runExporter(t, "-endpoint", stub.URL, "-username", "admin", "-password", "secretpw",
+		"-header", "X-Proxy-Token: proxytoken", "-proxy-auth", "proxy:proxypw")
+
+	res, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	for name, want := range map[string]string{
+		"Authorization":       basicAuthHeader("admin", "secretpw"),
+		"Proxy-Authorization": basicAuthHeader("proxy", "proxypw"),
+		"X-Proxy-Token":       "proxytoken",
+	} {
+		if got := seen.Get(name); got != want {
+			t.Errorf("%v: got %q, want %q", name, got, want)
+		}
+	}
+
+	// an Authorization header would silently replace the AdGuard credentials
+	out, err := exporterOutput(t, "-endpoint", stub.URL, "-username", "admin", "-password", "secretpw",
+		"-header", "Authorization: Bearer proxytoken")
+	if err == nil || !strings.Contains(out, "Authorization would replace the AdGuard credentials") {
+		t.Errorf("got %v:\n%s\nwant the conflicting Authorization rejected", err, out)
+	}
+}
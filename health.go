@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// health tracks the outcome of the collections for /readyz.
+type health struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastErr     error
+
+	// the last failure, kept after later successes for /debug/last-error
+	lastFailure     error
+	lastFailureTime time.Time
+
+	// for the systemd watchdog
+	inFlight     int
+	lastProgress time.Time
+}
+
+func (h *health) start(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.inFlight == 0 {
+		h.lastProgress = now
+	}
+	h.inFlight++
+}
+
+func (h *health) record(err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.inFlight--
+	h.lastProgress = now
+	h.lastErr = err
+	if err == nil {
+		h.lastSuccess = now
+	} else {
+		h.lastFailure, h.lastFailureTime = err, now
+	}
+}
+
+// failure returns the last failed collection, err is nil if none failed.
+func (h *health) failure() (err error, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.lastFailure, h.lastFailureTime
+}
+
+// stuck tells whether collections are running without any finishing for
+// longer than timeout.
+func (h *health) stuck(timeout time.Duration, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.inFlight > 0 && now.Sub(h.lastProgress) > timeout
+}
+
+// ready tells whether a collection succeeded, within window if it's set, and
+// why not otherwise.
+func (h *health) ready(window time.Duration, now time.Time) (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case h.lastSuccess.IsZero() && h.lastErr != nil:
+		return false, fmt.Sprintf("no successful collection yet: %v", h.lastErr)
+	case h.lastSuccess.IsZero():
+		return false, "no collection yet"
+	case window > 0 && now.Sub(h.lastSuccess) > window:
+		reason := fmt.Sprintf("no successful collection in %v", window)
+		if h.lastErr != nil {
+			reason = fmt.Sprintf("%v: %v", reason, h.lastErr)
+		}
+		return false, reason
+	}
+
+	return true, ""
+}
+
+// HealthzHandler answers 200 as long as the process serves requests.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadyzHandler answers 200 once the exporter collected successfully and
+// stays ready from then on, unless window requires a success within it. With
+// several exporters one ready target is enough, so a broken one doesn't take
+// the others out of service.
+func ReadyzHandler(window time.Duration, exporters func() []*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		exporters := exporters()
+		var ready bool
+		var reasons []string
+		for _, e := range exporters {
+			ok, reason := e.health.ready(window, now)
+			if ok {
+				ready = true
+				break
+			}
+			if len(exporters) > 1 {
+				reason = fmt.Sprintf("%v: %v", e.Endpoint, reason)
+			}
+			reasons = append(reasons, reason)
+		}
+		reason := strings.Join(reasons, "; ")
+		if len(exporters) == 0 {
+			reason = "no targets"
+		}
+
+		status, body := http.StatusOK, map[string]string{"status": "ready"}
+		if !ready {
+			status, body = http.StatusServiceUnavailable, map[string]string{"status": "not ready", "reason": reason}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	exporters := func() []*Exporter { return []*Exporter{e} }
+
+	readyz := func(window time.Duration) (int, map[string]string) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		ReadyzHandler(window, exporters).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("got %q: %v", rec.Body, err)
+		}
+		return rec.Code, body
+	}
+	collect := func() { collectMetrics(e.Collect) }
+
+	if status, body := readyz(0); status != http.StatusServiceUnavailable || body["reason"] != "no collection yet" {
+		t.Errorf("before collecting: got %d %v", status, body)
+	}
+
+	stub.fail("/control/stats", http.StatusInternalServerError)
+	collect()
+	if status, body := readyz(0); status != http.StatusServiceUnavailable || !strings.HasPrefix(body["reason"], "no successful collection yet: ") {
+		t.Errorf("after a failure: got %d %v", status, body)
+	}
+
+	stub.fail("/control/stats", 0)
+	collect()
+	if status, body := readyz(0); status != http.StatusOK || body["status"] != "ready" {
+		t.Errorf("after a success: got %d %v", status, body)
+	}
+
+	// ready stays ready through failures without a window
+	stub.fail("/control/stats", http.StatusInternalServerError)
+	collect()
+	collect()
+	if status, body := readyz(0); status != http.StatusOK {
+		t.Errorf("failures after a success: got %d %v", status, body)
+	}
+	if status, body := readyz(time.Nanosecond); status != http.StatusServiceUnavailable || !strings.Contains(body["reason"], "no successful collection in 1ns: ") {
+		t.Errorf("failures after a success with a window: got %d %v", status, body)
+	}
+}
+
+func TestHealthReadyWindow(t *testing.T) {
+	var h health
+	t0 := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	h.start(t0)
+	h.record(nil, t0)
+	h.start(t0.Add(time.Minute))
+	h.record(errors.New("connection refused"), t0.Add(time.Minute))
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want bool
+	}{
+		{time.Minute, true},
+		{5 * time.Minute, true},
+		{5*time.Minute + time.Second, false},
+	} {
+		if got, reason := h.ready(5*time.Minute, t0.Add(tc.at)); got != tc.want {
+			t.Errorf("after %v: got %v (%v), want %v", tc.at, got, reason, tc.want)
+		}
+	}
+
+	// a success makes it ready again
+	h.start(t0.Add(10 * time.Minute))
+	h.record(nil, t0.Add(10*time.Minute))
+	if got, reason := h.ready(5*time.Minute, t0.Add(11*time.Minute)); !got {
+		t.Errorf("after recovering: got not ready (%v)", reason)
+	}
+}
+
+func TestReadyzConcurrentCollections(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	h := ReadyzHandler(0, func() []*Exporter { return []*Exporter{e} })
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			collectMetrics(e.Collect)
+		}()
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		}()
+	}
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got %d after the collections, want 200", rec.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	HealthzHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok\n" {
+		t.Errorf("got %d %q", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newKubernetesClient returns a client for the cluster of kubeconfig, or
+// for the cluster the exporter runs in, or else of $KUBECONFIG and
+// ~/.kube/config if kubeconfig is empty.
+func newKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfig != "" {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else if cfg, err = rest.InClusterConfig(); errors.Is(err, rest.ErrNotInCluster) {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		cfg, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// parseService parses a namespace/name Service reference.
+func parseService(s string) (namespace, name string, err error) {
+	namespace, name, ok := strings.Cut(s, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%q: expected namespace/name", s)
+	}
+	return namespace, name, nil
+}
+
+// endpointSliceTargets returns the ready endpoints of slices as targets, at
+// port, a port name or number, or at the first port of a slice if empty.
+// Terminating endpoints aren't ready and drop out.
+func endpointSliceTargets(slices []*discoveryv1.EndpointSlice, port, scheme string) []*target {
+	var targets []*target
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		endpointPort := slicePort(slice, port)
+		if endpointPort == "" {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			// a missing condition means ready
+			if (endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready) || len(endpoint.Addresses) == 0 {
+				continue
+			}
+			t := &target{Scheme: scheme, Endpoint: net.JoinHostPort(endpoint.Addresses[0], endpointPort)}
+			if ref := endpoint.TargetRef; ref != nil && ref.Kind == "Pod" {
+				t.Pod = ref.Name
+			}
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// slicePort returns the number of port in slice, or "" if it has none.
+func slicePort(slice *discoveryv1.EndpointSlice, port string) string {
+	if _, err := strconv.Atoi(port); err == nil {
+		return port
+	}
+	for _, p := range slice.Ports {
+		if p.Port != nil && (port == "" || p.Name != nil && *p.Name == port) {
+			return strconv.Itoa(int(*p.Port))
+		}
+	}
+	return ""
+}
+
+// RunKubernetesDiscovery keeps the targets of the ready endpoints of a
+// Service up to date until ctx is done. The EndpointSlices of the Service are
+// watched by an informer, which lists them again every resync.
+func (s *TargetSet) RunKubernetesDiscovery(ctx context.Context, cs kubernetes.Interface, service, port string, resync time.Duration) {
+	namespace, name, _ := parseService(service)
+	source := "kubernetes:" + service
+
+	factory := informers.NewSharedInformerFactoryWithOptions(cs, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = discoveryv1.LabelServiceName + "=" + name
+		}),
+	)
+	informer := factory.Discovery().V1().EndpointSlices()
+	lister := informer.Lister().EndpointSlices(namespace)
+
+	refresh := func() {
+		slices, err := lister.List(labels.Everything())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to list endpoints of %v: %v", service, err))
+			return
+		}
+		s.Update(ctx, source, endpointSliceTargets(slices, port, s.Exporter.Scheme))
+	}
+	// resyncs come as updates, refreshing on them is harmless
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { refresh() },
+		UpdateFunc: func(any, any) { refresh() },
+		DeleteFunc: func(any) { refresh() },
+	})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Unable to watch endpoints of %v: %v", service, err))
+		return
+	}
+
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseService(t *testing.T) {
+	if namespace, name, err := parseService("dns/adguard-home"); err != nil || namespace != "dns" || name != "adguard-home" {
+		t.Errorf("got %q, %q, %v, want dns, adguard-home", namespace, name, err)
+	}
+	for _, s := range []string{"adguard-home", "/adguard-home", "dns/", "dns/adguard/home"} {
+		if _, _, err := parseService(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
+
+// endpointSlice returns a slice of the Service name with a web port and an
+// endpoint per pod address, pods given as name=address, ready unless
+// prefixed with "!".
+func endpointSlice(name, slice string, port int32, pods ...string) *discoveryv1.EndpointSlice {
+	webName := "web"
+	s := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      slice,
+			Namespace: "dns",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: name},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &webName, Port: &port}},
+	}
+	for _, pod := range pods {
+		pod, notReady := strings.CutPrefix(pod, "!")
+		podName, address, _ := strings.Cut(pod, "=")
+		ready := !notReady
+		s.Endpoints = append(s.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: podName},
+		})
+	}
+	return s
+}
+
+func TestEndpointSliceTargets(t *testing.T) {
+	unnamed := int32(8080)
+	fqdn := endpointSlice("adguard-home", "fqdn", 3000, "adguard-home-2=adguard.example.com")
+	fqdn.AddressType = discoveryv1.AddressTypeFQDN
+	noCondition := endpointSlice("adguard-home", "no-condition", 3000, "adguard-home-3=10.0.0.3")
+	noCondition.Endpoints[0].Conditions.Ready = nil
+	endpointSlices := []*discoveryv1.EndpointSlice{
+		endpointSlice("adguard-home", "ipv4", 3000, "adguard-home-0=10.0.0.1", "!adguard-home-1=10.0.0.2"),
+		fqdn,
+		noCondition,
+		{AddressType: discoveryv1.AddressTypeIPv6, Ports: []discoveryv1.EndpointPort{{Port: &unnamed}},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"fd00::4"}}}},
+	}
+
+	for _, tc := range []struct {
+		port string
+		want []*target
+	}{
+		{"", []*target{
+			{Scheme: "http", Endpoint: "10.0.0.1:3000", Pod: "adguard-home-0"},
+			{Scheme: "http", Endpoint: "10.0.0.3:3000", Pod: "adguard-home-3"},
+			{Scheme: "http", Endpoint: "[fd00::4]:8080"},
+		}},
+		// the slice without the named port is left out
+		{"web", []*target{
+			{Scheme: "http", Endpoint: "10.0.0.1:3000", Pod: "adguard-home-0"},
+			{Scheme: "http", Endpoint: "10.0.0.3:3000", Pod: "adguard-home-3"},
+		}},
+		{"80", []*target{
+			{Scheme: "http", Endpoint: "10.0.0.1:80", Pod: "adguard-home-0"},
+			{Scheme: "http", Endpoint: "10.0.0.3:80", Pod: "adguard-home-3"},
+			{Scheme: "http", Endpoint: "[fd00::4]:80"},
+		}},
+	} {
+		if got := endpointSliceTargets(endpointSlices, tc.port, "http"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("port %q: got %+v, want %+v", tc.port, got, tc.want)
+		}
+	}
+}
+
+func TestRunKubernetesDiscovery(t *testing.T) {
+	one, two := newAdGuardStub(t), newAdGuardStub(t)
+	port := func(stub *adguardStub) int32 {
+		_, p, _ := strings.Cut(stub.endpoint(), ":")
+		n, _ := strconv.Atoi(p)
+		return int32(n)
+	}
+	cs := fake.NewClientset(
+		endpointSlice("adguard-home", "adguard-home-a", port(one), "adguard-home-0=127.0.0.1"),
+		// another Service
+		endpointSlice("unbound", "unbound-a", port(two), "unbound-0=127.0.0.1"),
+	)
+	s, registry := newTestTargetSet()
+	s.PodLabel = true
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		s.RunKubernetesDiscovery(ctx, cs, "dns/adguard-home", "web", time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "the ready pod", func() bool { return slices.Equal(endpoints(s), []string{one.endpoint()}) })
+	if metrics := exposition(t, registry); !strings.Contains(metrics, `adguardhome_up{pod="adguard-home-0",source="kubernetes:dns/adguard-home",target="`+one.endpoint()+`"} 1`) {
+		t.Errorf("got\n%s\nwant the pod label", metrics)
+	}
+
+	discovery := cs.DiscoveryV1().EndpointSlices("dns")
+	if _, err := discovery.Create(ctx, endpointSlice("adguard-home", "adguard-home-b", port(two), "adguard-home-1=127.0.0.1"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{one.endpoint(), two.endpoint()}
+	slices.Sort(want)
+	waitFor(t, "the added pod", func() bool { return slices.Equal(endpoints(s), want) })
+
+	// a terminating pod isn't ready any more
+	if _, err := discovery.Update(ctx, endpointSlice("adguard-home", "adguard-home-a", port(one), "!adguard-home-0=127.0.0.1"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the terminating pod to go", func() bool { return slices.Equal(endpoints(s), []string{two.endpoint()}) })
+
+	if err := discovery.Delete(ctx, "adguard-home-b", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the deleted slice to go", func() bool { return len(endpoints(s)) == 0 })
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+var landingPage = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>AdGuard Home Exporter</title></head>
+<body>
+<h1>AdGuard Home Exporter</h1>
+<p>Version {{.Version}}</p>
+<ul>
+{{- range .Links}}
+<li><a href="{{.Path}}">{{.Text}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type landingLink struct {
+	Path, Text string
+}
+
+// LandingPageHandler serves a page linking to the given routes at root and
+// 404 for any other path it receives.
+func LandingPageHandler(root string, links []landingLink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != root {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		landingPage.Execute(w, struct {
+			Version string
+			Links   []landingLink
+		}{version, links})
+	})
+}
+
+// webRoutes returns the prefix routes are served under, without a trailing
+// slash, and the base links are rendered with. Without a route prefix the
+// path of the external URL is used, as a proxy usually forwards it as is.
+func webRoutes(routePrefix, externalURL string) (prefix, linkBase string, err error) {
+	if externalURL != "" {
+		u, err := url.Parse(externalURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "", "", fmt.Errorf("Invalid -web.external-url %q: must be an absolute URL", externalURL)
+		}
+		if routePrefix == "" {
+			routePrefix = u.Path
+		}
+		linkBase = strings.TrimSuffix(externalURL, "/")
+	}
+
+	prefix = strings.Trim(routePrefix, "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	if externalURL == "" {
+		linkBase = prefix
+	}
+
+	return prefix, linkBase, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLandingPage(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-path", "/adguard/metrics")
+
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		res, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	status, body := get("/")
+	if status != http.StatusOK {
+		t.Fatalf("got %d, want 200", status)
+	}
+	for _, want := range []string{
+		"<h1>AdGuard Home Exporter</h1>",
+		"Version " + version,
+		`<a href="/adguard/metrics">Metrics</a>`,
+		`<a href="/healthz">Health</a>`,
+		`<a href="/readyz">Readiness</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got\n%s\nwant %v", body, want)
+		}
+	}
+	if strings.Contains(body, `href="/metrics"`) {
+		t.Errorf("got\n%s\nwant no link to the default path", body)
+	}
+
+	if status, _ := get("/adguard/metrics"); status != http.StatusOK {
+		t.Errorf("linked metrics path: got %d, want 200", status)
+	}
+	for _, path := range []string{"/metrics", "/unknown"} {
+		if status, _ := get(path); status != http.StatusNotFound {
+			t.Errorf("%v: got %d, want 404", path, status)
+		}
+	}
+}
+
+func TestWebRoutes(t *testing.T) {
+	for _, tc := range []struct {
+		routePrefix, externalURL string
+		prefix, linkBase         string
+	}{
+		{"", "", "", ""},
+		{"/", "", "", ""},
+		{"exporters/adguard", "", "/exporters/adguard", "/exporters/adguard"},
+		{"/exporters/adguard/", "", "/exporters/adguard", "/exporters/adguard"},
+		{"", "https://proxy.example/exporters/adguard/", "/exporters/adguard", "https://proxy.example/exporters/adguard"},
+		{"/adguard", "https://proxy.example/exporters/adguard", "/adguard", "https://proxy.example/exporters/adguard"},
+		{"", "https://proxy.example", "", "https://proxy.example"},
+	} {
+		prefix, linkBase, err := webRoutes(tc.routePrefix, tc.externalURL)
+		if err != nil || prefix != tc.prefix || linkBase != tc.linkBase {
+			t.Errorf("webRoutes(%q, %q): got %q, %q, %v, want %q, %q", tc.routePrefix, tc.externalURL, prefix, linkBase, err, tc.prefix, tc.linkBase)
+		}
+	}
+
+	for _, externalURL := range []string{"/exporters/adguard", "proxy.example"} {
+		if _, _, err := webRoutes("", externalURL); err == nil {
+			t.Errorf("webRoutes(%q): got no error, want a relative URL rejected", externalURL)
+		}
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-path", "/adguard/metrics",
+		"-web.external-url", "https://proxy.example/exporters/adguard/")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/exporters/adguard/adguard/metrics", http.StatusOK},
+		{"/exporters/adguard/healthz", http.StatusOK},
+		{"/exporters/adguard/", http.StatusOK},
+		{"/exporters/adguard/metrics", http.StatusNotFound},
+		{"/adguard/metrics", http.StatusNotFound},
+		{"/", http.StatusFound},
+	} {
+		res, err := client.Get(base + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%v: got %d, want %d", tc.path, res.StatusCode, tc.status)
+		}
+
+		switch tc.path {
+		case "/exporters/adguard/":
+			for _, want := range []string{
+				`<a href="https://proxy.example/exporters/adguard/adguard/metrics">Metrics</a>`,
+				`<a href="https://proxy.example/exporters/adguard/healthz">Health</a>`,
+			} {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("got\n%s\nwant %v", body, want)
+				}
+			}
+		case "/":
+			if location := res.Header.Get("Location"); location != "/exporters/adguard/" {
+				t.Errorf("/: got redirect to %q, want /exporters/adguard/", location)
+			}
+		}
+	}
+}
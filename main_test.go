@@ -0,0 +1,1066 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	username, password, token := "flag-user", "flag-secretpw", "flag-token"
+	err := readSecretFiles([]secretFile{
+		{write("username", "admin\n"), &username},
+		{write("password", "file-secretpw\r\n"), &password},
+		// without a file the flag or env value stays
+		{"", &token},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "admin" || password != "file-secretpw" || token != "flag-token" {
+		t.Errorf("got %q, %q, %q, want the files to win and the newlines trimmed", username, password, token)
+	}
+
+	token = "flag-token"
+	err = readSecretFiles([]secretFile{{filepath.Join(dir, "missing"), &token}})
+	if err == nil || !strings.Contains(err.Error(), "missing") || token != "flag-token" {
+		t.Errorf("unreadable file: got %v with token %q, want an error naming it", err, token)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.MaxResponseBytes = 1024
+
+	for _, tc := range []struct {
+		name    string
+		size    int
+		gzip    bool
+		wantErr bool
+	}{
+		{"at the limit", 1024, false, false},
+		{"oversized", 1025, false, true},
+		{"oversized once decompressed", 64 << 10, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := []byte(`"` + strings.Repeat("a", tc.size-2) + `"`)
+				if tc.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+					zw := gzip.NewWriter(w)
+					zw.Write(body)
+					zw.Close()
+					return
+				}
+				w.Write(body)
+			}))
+
+			body, err := e.getRaw(t.Context(), "/control/stats")
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "response exceeds 1024 bytes") {
+					t.Errorf("got error %v, want the limit exceeded", err)
+				}
+				return
+			}
+			if err != nil || len(body) != tc.size {
+				t.Errorf("got %d bytes and error %v, want %d bytes", len(body), err, tc.size)
+			}
+		})
+	}
+}
+
+// TestMain runs the exporter's main instead of the tests when
+// runExporter starts the test binary as the exporter.
+func TestMain(m *testing.M) {
+	if os.Getenv("ADGUARD_EXPORTER_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var listeningRE = regexp.MustCompile(`Listening on (?:([0-9.]+:[0-9]+))?`)
+
+// runExporter starts the exporter with args on a free port and returns its
+// base URL once it listens, with the host localhost on a Unix socket. It's
+// stopped with the test.
+func runExporter(t *testing.T, args ...string) string {
+	t.Helper()
+
+	_, base := startExporter(t, args...)
+	return base
+}
+
+// startExporter is runExporter returning the process too.
+func startExporter(t *testing.T, args ...string) (*exec.Cmd, string) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], append([]string{"-address", "127.0.0.1:0"}, args...)...)
+	cmd.Env = append(os.Environ(), "ADGUARD_EXPORTER_TEST_MAIN=1")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	listening := make(chan string, 1)
+	var log strings.Builder
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		seen := false
+		// keep reading, so the exporter never blocks on its log
+		for scanner.Scan() {
+			log.WriteString(scanner.Text() + "\n")
+			if m := listeningRE.FindStringSubmatch(scanner.Text()); m != nil && !seen {
+				seen = true
+				listening <- cmp.Or(m[1], "localhost")
+			}
+		}
+		close(listening)
+	}()
+
+	select {
+	case addr, ok := <-listening:
+		if !ok {
+			t.Fatalf("exporter exited:\n%s", log.String())
+		}
+		return cmd, "http://" + addr
+	case <-time.After(10 * time.Second):
+		t.Fatal("exporter didn't start listening")
+	}
+	return nil, ""
+}
+
+// exporterOutput runs the exporter with args until it exits and returns its
+// output.
+func exporterOutput(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], append([]string{"-address", "127.0.0.1:0"}, args...)...)
+	cmd.Env = append(os.Environ(), "ADGUARD_EXPORTER_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestRoutePrefix(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-route-prefix", "/adguard-exporter/")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/adguard-exporter/metrics", http.StatusOK},
+		{"/adguard-exporter/healthz", http.StatusOK},
+		{"/adguard-exporter/", http.StatusOK},
+		{"/adguard-exporter/status", http.StatusOK},
+		{"/", http.StatusFound},
+		{"/metrics", http.StatusNotFound},
+		{"/healthz", http.StatusNotFound},
+	} {
+		res, err := client.Get(base + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%v: got %d, want %d", tc.path, res.StatusCode, tc.status)
+		}
+		if tc.path == "/adguard-exporter/metrics" && !strings.Contains(string(body), "adguardhome_dns_queries 100") {
+			t.Errorf("%v: got\n%s\nwant the AdGuard metrics", tc.path, body)
+		}
+		if tc.path == "/" && res.Header.Get("Location") != "/adguard-exporter/" {
+			t.Errorf("/: got redirect to %q, want /adguard-exporter/", res.Header.Get("Location"))
+		}
+	}
+}
+
+func TestStatsCountersAndGauges(t *testing.T) {
+	stub := newAdGuardStub(t)
+
+	for _, tc := range []struct {
+		name             string
+		gauges, counters bool
+		want             []string
+	}{
+		{"default", true, false, []string{"adguardhome_dns_queries"}},
+		{"both", true, true, []string{"adguardhome_dns_queries", "adguardhome_dns_queries_total"}},
+		{"counters only", false, true, []string{"adguardhome_dns_queries_total"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewExporter(stub.endpoint(), "", "")
+			e.Gauges, e.Counters = tc.gauges, tc.counters
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(e)
+
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			types := map[string]string{}
+			for _, family := range families {
+				if strings.HasPrefix(family.GetName(), "adguardhome_dns_queries") {
+					types[family.GetName()] = family.GetType().String()
+					if v := family.GetMetric()[0]; v.GetGauge().GetValue()+v.GetCounter().GetValue() != 100 {
+						t.Errorf("%v: got %v, want 100", family.GetName(), v)
+					}
+				}
+			}
+			want := map[string]string{}
+			for _, name := range tc.want {
+				want[name] = "GAUGE"
+				if strings.HasSuffix(name, "_total") {
+					want[name] = "COUNTER"
+				}
+			}
+			if !maps.Equal(types, want) {
+				t.Errorf("got %v, want %v", types, want)
+			}
+		})
+	}
+}
+
+func TestOnceAndServe(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		args   []string
+		want   int
+	}{
+		{"without", 0, nil, 0},
+		{"warmup", 0, []string{"-once-and-serve"}, 1},
+		{"failed warmup", http.StatusInternalServerError, []string{"-once-and-serve"}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := newAdGuardStub(t)
+			stub.fail("/control/stats", tc.status)
+			base := runExporter(t, append([]string{"-endpoint", stub.URL}, tc.args...)...)
+
+			// the warmup is done by the time the exporter listens
+			if got := min(stub.count("/control/stats"), 1); got != tc.want {
+				t.Errorf("got %d requests before listening, want %d", stub.count("/control/stats"), tc.want)
+			}
+			res, err := http.Get(base + "/healthz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("got %d from /healthz, want 200", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestWebTLS(t *testing.T) {
+	stub := newAdGuardStub(t)
+	server := newTestCert(t, nil)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	for file, data := range map[string][]byte{certFile: server.cert, keyFile: server.key} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	base := strings.Replace(runExporter(t, "-endpoint", stub.URL, "-web.tls-cert", certFile, "-web.tls-key", keyFile), "http://", "https://", 1)
+	roots := x509.NewCertPool()
+	roots.AddCert(server.parsed)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: true}}
+	res, err := client.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "adguardhome_dns_queries 100") {
+		t.Errorf("got %d:\n%s\nwant the AdGuard metrics", res.StatusCode, body)
+	}
+	if res.ProtoMajor != 2 {
+		t.Errorf("got %v, want HTTP/2", res.Proto)
+	}
+
+	// plain HTTP stays the default
+	plain := runExporter(t, "-endpoint", stub.URL)
+	if res, err := http.Get(plain + "/metrics"); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("plain HTTP: got %v, %v", res, err)
+	} else {
+		res.Body.Close()
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-web.tls-cert", certFile}, "must be set together"},
+		{[]string{"-web.tls-cert", certFile, "-web.tls-key", certFile}, "Invalid TLS keypair"},
+	} {
+		out, err := exporterOutput(t, append([]string{"-endpoint", stub.URL}, tc.args...)...)
+		if err == nil || !strings.Contains(out, tc.want) {
+			t.Errorf("%v: got %v:\n%s\nwant an error with %q", tc.args, err, out, tc.want)
+		}
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	stub := newAdGuardStub(t)
+	tls12 := httptest.NewUnstartedServer(stub.Config.Handler)
+	tls12.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	tls12.StartTLS()
+	t.Cleanup(tls12.Close)
+
+	for _, tc := range []struct {
+		version string
+		want    string
+	}{
+		{"1.2", "adguardhome_up 1"},
+		{"1.3", "adguardhome_up 0"},
+	} {
+		base := runExporter(t, "-endpoint", tls12.URL, "-tls-min-version", tc.version)
+		res, err := http.Get(base + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(body), tc.want+"\n") {
+			t.Errorf("-tls-min-version %v against TLS 1.2: got\n%s\nwant %v", tc.version, body, tc.want)
+		}
+	}
+
+	if out, err := exporterOutput(t, "-endpoint", tls12.URL, "-tls-min-version", "1.1"); err == nil || !strings.Contains(out, "must be 1.2 or 1.3") {
+		t.Errorf("-tls-min-version 1.1: got %v:\n%s\nwant an error", err, out)
+	}
+}
+
+func TestProcessingTimeMilliseconds(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.ProcessingTimeMilliseconds = true
+	stats := collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromAPI(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+
+	seconds := testutil.ToFloat64(collectorOf(stats, "adguardhome_processing_time"))
+	milliseconds := testutil.ToFloat64(collectorOf(stats, "adguardhome_processing_time_milliseconds"))
+	if seconds != 0.012 || milliseconds != 1000*seconds {
+		t.Errorf("got %v s and %v ms, want 0.012 s and 1000 times that in ms", seconds, milliseconds)
+	}
+
+	e.ProcessingTimeMilliseconds = false
+	if n := testutil.CollectAndCount(stats, "adguardhome_processing_time_milliseconds"); n != 0 {
+		t.Errorf("without the flag: got %d series, want none", n)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(releaseOnce)
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"num_dns_queries": 100, "num_blocked_filtering": 10, "avg_processing_time": 0.01}`))
+	}))
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	cmd, base := startExporter(t, "-endpoint", stub.URL, "-querylog.enabled", "-state-file", stateFile)
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	type scrape struct {
+		status int
+		body   string
+		err    error
+	}
+	scraped := make(chan scrape, 1)
+	go func() {
+		res, err := http.Get(base + "/metrics")
+		if err != nil {
+			scraped <- scrape{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		scraped <- scrape{res.StatusCode, string(body), err}
+	}()
+	waitFor(t, "the scrape to reach AdGuard", func() bool { return stub.count("/control/stats") == 1 })
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exited:
+		t.Fatalf("exited during the scrape: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Error("new connection accepted after SIGTERM")
+	}
+
+	releaseOnce()
+	got := <-scraped
+	if got.err != nil || got.status != http.StatusOK || !strings.Contains(got.body, "adguardhome_up 1\n") {
+		t.Errorf("in-flight scrape: got %d %v:\n%s", got.status, got.err, got.body)
+	}
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("got exit %v, want 0", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("didn't exit after the scrape")
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Errorf("state not saved: %v", err)
+	}
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	stub := newAdGuardStub(t)
+
+	for _, tc := range []struct {
+		args []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"-metrics.runtime=false"}, false},
+	} {
+		base := runExporter(t, append([]string{"-endpoint", stub.URL}, tc.args...)...)
+		res, err := http.Get(base + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if got := strings.Contains(string(body), "\ngo_goroutines "); got != tc.want {
+			t.Errorf("%v: got go_goroutines %v, want %v", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestPprof(t *testing.T) {
+	stub := newAdGuardStub(t)
+	get := func(base, path, token string) (int, string) {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	disabled := runExporter(t, "-endpoint", stub.URL)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine"} {
+		if status, _ := get(disabled, path, ""); status != http.StatusNotFound {
+			t.Errorf("disabled %v: got %d, want 404", path, status)
+		}
+	}
+
+	enabled := runExporter(t, "-endpoint", stub.URL, "-web.enable-pprof", "-metrics-token", "secrettoken")
+	if status, body := get(enabled, "/debug/pprof/", "secrettoken"); status != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Errorf("enabled index: got %d:\n%s", status, body)
+	}
+	if status, body := get(enabled, "/debug/pprof/goroutine?debug=1", "secrettoken"); status != http.StatusOK || !strings.HasPrefix(body, "goroutine profile: total ") {
+		t.Errorf("enabled goroutine profile: got %d:\n%.200s", status, body)
+	}
+	if status, _ := get(enabled, "/debug/pprof/", ""); status != http.StatusUnauthorized {
+		t.Errorf("enabled without the token: got %d, want 401", status)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-web.read-header-timeout", "200ms")
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a slowloris client never finishes its headers
+	if _, err := conn.Write([]byte("GET /metrics HTTP/1.1\r\nHost: exporter\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	_, err = io.ReadAll(conn)
+	if elapsed := time.Since(start); err != nil || elapsed < 150*time.Millisecond {
+		t.Errorf("got the connection closed after %v (%v), want after the 200ms header timeout", elapsed, err)
+	}
+}
+
+func TestExcludeTopEntries(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/stats", map[string]any{
+		"num_dns_queries":       100,
+		"num_blocked_filtering": 10,
+		"avg_processing_time":   0.01,
+		"top_queried_domains":   []map[string]int{{"example.org": 5}, {"Uptime.Example.NET": 9}, {"4.3.2.1.in-addr.arpa": 3}},
+		"top_blocked_domains":   []map[string]int{{"ads.example.com": 4}, {"telemetry.example.net": 2}},
+		"top_clients":           []map[string]int{{"192.168.1.2": 7}, {"192.168.1.5": 20}, {"10.0.0.9": 1}},
+	})
+	e := NewExporter(stub.endpoint(), "", "")
+	e.ExcludeClients = []string{"192.168.1.5", "10.*"}
+	e.ExcludeDomains = []string{"uptime.example.net", "*.arpa", "telemetry.*"}
+
+	var got []string
+	for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) {
+		if err := e.CollectFromAPI(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	}) {
+		if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_top_") {
+			got = append(got, line)
+		}
+	}
+	slices.Sort(got)
+	want := []string{
+		`adguardhome_top_blocked_domains{domain="ads.example.com"} 4`,
+		`adguardhome_top_clients{client="192.168.1.2"} 7`,
+		`adguardhome_top_queried_domains{domain="example.org"} 5`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	stub := newAdGuardStub(t)
+	// t.TempDir can exceed the length limit of socket paths
+	dir, err := os.MkdirTemp("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "exporter.sock")
+
+	cmd, base := startExporter(t, "-endpoint", stub.URL, "-address", "unix://"+path, "-web.socket-mode", "0600")
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := client.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "adguardhome_dns_queries 100") {
+		t.Errorf("got %d:\n%s\nwant the AdGuard metrics", res.StatusCode, body)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("got socket %v, %v, want mode 0600", info, err)
+	}
+
+	// the socket is removed on shutdown
+	cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("got exit %v, want 0", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for the socket after shutdown, want it removed", err)
+	}
+}
+
+func TestHealthAddressAllowList(t *testing.T) {
+	stub := newAdGuardStub(t)
+	for _, tc := range []struct {
+		cidr   string
+		status int
+	}{
+		{"10.0.0.0/8", http.StatusForbidden},
+		{"127.0.0.0/8", http.StatusOK},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		health := l.Addr().String()
+		l.Close()
+		runExporter(t, "-endpoint", stub.URL, "-web.health-address", health, "-web.allow-cidr", tc.cidr)
+
+		res, err := http.Get("http://" + health + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("-web.allow-cidr %v: got %d, want %d", tc.cidr, res.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestHealthAddress(t *testing.T) {
+	stub := newAdGuardStub(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := l.Addr().String()
+	l.Close()
+	cmd, base := startExporter(t, "-endpoint", stub.URL, "-web.health-address", health)
+
+	for _, tc := range []struct {
+		url    string
+		status int
+	}{
+		{base + "/metrics", http.StatusOK},
+		{"http://" + health + "/healthz", http.StatusOK},
+		// ready after the scrape above
+		{"http://" + health + "/readyz", http.StatusOK},
+		{"http://" + health + "/metrics", http.StatusNotFound},
+		{"http://" + health + "/", http.StatusNotFound},
+		{base + "/healthz", http.StatusNotFound},
+		{base + "/readyz", http.StatusNotFound},
+	} {
+		res, err := http.Get(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%v: got %d, want %d", tc.url, res.StatusCode, tc.status)
+		}
+	}
+
+	// a health address already in use fails the start
+	if out, err := exporterOutput(t, "-endpoint", stub.URL, "-web.health-address", health); err == nil || !strings.Contains(out, "-web.health-address") {
+		t.Errorf("got %v:\n%s\nwant the health address failing to bind", err, out)
+	}
+
+	// both listeners shut down together
+	cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("got exit %v, want 0", err)
+	}
+	if _, err := http.Get("http://" + health + "/healthz"); err == nil {
+		t.Error("got the health listener serving after shutdown")
+	}
+}
+
+func TestStatsWithoutCounters(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/stats", map[string]any{"message": "control API disabled by proxy"})
+	e := NewExporter(stub.endpoint(), "", "")
+
+	var up []string
+	for _, m := range collectMetrics(e.Collect) {
+		if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_up ") || strings.HasPrefix(line, "adguardhome_dns_queries ") {
+			up = append(up, line)
+		}
+	}
+	if !slices.Equal(up, []string{"adguardhome_up 0"}) {
+		t.Errorf("got %q, want only adguardhome_up 0", up)
+	}
+
+	var res Response
+	err := json.Unmarshal([]byte(`{"message":"control API disabled by proxy"}`), &res)
+	if err == nil || !strings.Contains(err.Error(), "control API disabled by proxy") {
+		t.Errorf("got %v, want the message in the error", err)
+	}
+	if err := json.Unmarshal([]byte(`{"num_dns_queries":0}`), &res); err != nil {
+		t.Errorf("got %v for a zero counter, want it accepted", err)
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	stub := newAdGuardStub(t)
+
+	for _, tc := range []struct {
+		name        string
+		args        []string
+		contentType string
+		want        []string
+	}{
+		{"enabled", nil, "application/openmetrics-text", []string{
+			"# TYPE adguardhome_dns_queries counter\n",
+			"adguardhome_dns_queries_total 100.0\n",
+			"# TYPE adguardhome_dns_queries gauge\n",
+			"# EOF\n",
+		}},
+		{"disabled", []string{"-web.enable-openmetrics=false"}, "text/plain", []string{
+			"# TYPE adguardhome_dns_queries_total counter\n",
+			"adguardhome_dns_queries_total 100\n",
+			"# TYPE adguardhome_dns_queries gauge\n",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base := runExporter(t, append([]string{"-endpoint", stub.URL, "-metrics.counters"}, tc.args...)...)
+			req, err := http.NewRequest(http.MethodGet, base+"/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if contentType := res.Header.Get("Content-Type"); !strings.HasPrefix(contentType, tc.contentType) {
+				t.Errorf("got Content-Type %q, want %v", contentType, tc.contentType)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("got\n%s\nwant %q", body, want)
+				}
+			}
+			if tc.name == "disabled" && strings.Contains(string(body), "# EOF") {
+				t.Errorf("got\n%s\nwant no # EOF in the text format", body)
+			}
+		})
+	}
+}
+
+func TestUpstreamsActive(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	active := func() string {
+		for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) {
+			if err := e.CollectFromAPI(t.Context(), ch); err != nil {
+				t.Error(err)
+			}
+		}) {
+			if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_upstreams_active ") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	for _, tc := range []struct {
+		responses []map[string]int
+		want      string
+	}{
+		{[]map[string]int{{"tls://1.1.1.1": 50}, {"8.8.8.8": 0}}, "adguardhome_upstreams_active 1"},
+		{[]map[string]int{{"tls://1.1.1.1": 50}, {"8.8.8.8": 3}, {"https://dns.quad9.net/dns-query": 1}}, "adguardhome_upstreams_active 3"},
+		{[]map[string]int{{"tls://1.1.1.1": 50}, {"8.8.8.8": 3}, {"https://dns.quad9.net/dns-query": 0}}, "adguardhome_upstreams_active 2"},
+		{[]map[string]int{}, "adguardhome_upstreams_active 0"},
+		// older versions don't report responses per upstream
+		{nil, ""},
+	} {
+		stub.set("/control/stats", map[string]any{
+			"num_dns_queries":         100,
+			"avg_processing_time":     0.01,
+			"top_upstreams_responses": tc.responses,
+		})
+		if got := active(); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.responses, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint, scheme string
+		want, wantScheme string
+	}{
+		{"adguard:3000", "http", "adguard:3000", "http"},
+		{"adguard:3000", "https", "adguard:3000", "https"},
+		{"http://adguard:3000/", "http", "adguard:3000", "http"},
+		{"https://adguard:3000", "http", "adguard:3000", "https"},
+		{" HTTP://adguard:3000// ", "https", "adguard:3000", "http"},
+		{"\tadguard.home.arpa\n", "http", "adguard.home.arpa", "http"},
+		{"https://[::1]:3000/", "http", "[::1]:3000", "https"},
+		{"adguard:3000/", "HTTPS", "adguard:3000", "https"},
+	} {
+		endpoint, scheme := normalizeEndpoint(tc.endpoint, tc.scheme)
+		if endpoint != tc.want || scheme != tc.wantScheme {
+			t.Errorf("normalizeEndpoint(%q, %q): got %q, %q, want %q, %q", tc.endpoint, tc.scheme, endpoint, scheme, tc.want, tc.wantScheme)
+		}
+	}
+}
+
+func TestEndpointFromEnvironment(t *testing.T) {
+	stub := newAdGuardStub(t)
+	t.Setenv("ADGUARD_ENDPOINT", " HTTP://"+stub.endpoint()+"/ ")
+	base := runExporter(t)
+
+	res, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "adguardhome_up 1") {
+		t.Errorf("got\n%s\nwant the normalized endpoint collected", body)
+	}
+}
+
+func TestMetricsHandlerInstrumentation(t *testing.T) {
+	stub := newAdGuardStub(t)
+	release, _ := slowStats(stub)
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(releaseOnce)
+	base := runExporter(t, "-endpoint", stub.URL, "-web.max-requests-in-flight", "1")
+	get := func() (int, string) {
+		res, err := http.Get(base + "/metrics")
+		if err != nil {
+			t.Error(err)
+			return 0, ""
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	first := make(chan int)
+	go func() {
+		status, _ := get()
+		first <- status
+	}()
+	waitFor(t, "the first scrape to reach AdGuard", func() bool { return stub.count("/control/stats") == 1 })
+	if status, _ := get(); status != http.StatusServiceUnavailable {
+		t.Errorf("concurrent scrape: got %d, want 503", status)
+	}
+	releaseOnce()
+	if status := <-first; status != http.StatusOK {
+		t.Errorf("first scrape: got %d, want 200", status)
+	}
+
+	// a scrape sees the requests before it
+	_, body := get()
+	for _, want := range []string{
+		`promhttp_metric_handler_requests_total{code="200"} 1`,
+		`promhttp_metric_handler_requests_total{code="503"} 1`,
+		`promhttp_metric_handler_requests_in_flight 1`,
+		`adguardhome_exporter_http_request_duration_seconds_count{code="200",handler="/metrics"} 1`,
+		`adguardhome_exporter_http_request_duration_seconds_count{code="503",handler="/metrics"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("got\n%s\nwant %s", body, want)
+		}
+	}
+	_, body = get()
+	if !strings.Contains(body, `promhttp_metric_handler_requests_total{code="200"} 2`+"\n") {
+		t.Errorf("got\n%s\nwant the 200 counter moved", body)
+	}
+}
+
+func TestProtobufNegotiation(t *testing.T) {
+	stub := newAdGuardStub(t)
+
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"live", nil},
+		// the exposition cache and collect[] wrap the handler
+		{"collect interval", []string{"-collect-interval", "1h"}},
+		{"collect param", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base := runExporter(t, append([]string{"-endpoint", stub.URL}, tc.args...)...)
+			url := base + "/metrics"
+			if tc.name == "collect param" {
+				url += "?collect[]=stats"
+			}
+			scrape := func(accept string) (expfmt.Format, map[string]*dto.MetricFamily) {
+				t.Helper()
+
+				req, _ := http.NewRequest(http.MethodGet, url, nil)
+				req.Header.Set("Accept", accept)
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer res.Body.Close()
+				format := expfmt.ResponseFormat(res.Header)
+				families := map[string]*dto.MetricFamily{}
+				dec := expfmt.NewDecoder(res.Body, format)
+				for {
+					var family dto.MetricFamily
+					if err := dec.Decode(&family); err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatalf("decoding %v: %v", format, err)
+					}
+					families[family.GetName()] = &family
+				}
+				return format, families
+			}
+
+			for _, accept := range []struct {
+				header string
+				want   expfmt.FormatType
+			}{
+				{"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited", expfmt.TypeProtoDelim},
+				{"", expfmt.TypeTextPlain},
+			} {
+				format, families := scrape(accept.header)
+				if format.FormatType() != accept.want {
+					t.Errorf("Accept %q: got format %v", accept.header, format)
+				}
+				queries := families["adguardhome_dns_queries"]
+				if queries == nil || queries.GetMetric()[0].GetGauge().GetValue() != 100 {
+					t.Errorf("Accept %q: got %v, want adguardhome_dns_queries 100", accept.header, queries)
+				}
+			}
+		})
+	}
+}
+
+func TestEndpointTimeout(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/filtering/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Status, e.Filtering = &Status{}, true
+	e.EndpointTimeout = 100 * time.Millisecond
+
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		max     time.Duration
+	}{
+		{"endpoint timeout", 10 * time.Second, 2 * time.Second},
+		// a shorter scrape timeout still wins
+		{"scrape timeout", 20 * time.Millisecond, 90 * time.Millisecond},
+	} {
+		ctx, cancel := context.WithTimeout(t.Context(), tc.timeout)
+		start := time.Now()
+		var err error
+		var got []string
+		for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) { err = e.collect(ctx, ch) }) {
+			if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_collector_success") {
+				got = append(got, line)
+			}
+		}
+		elapsed := time.Since(start)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%v: got error %v, want the deadline exceeded", tc.name, err)
+		}
+		if elapsed > tc.max {
+			t.Errorf("%v: collection took %v, want at most %v", tc.name, elapsed, tc.max)
+		}
+		if tc.name == "endpoint timeout" {
+			// the endpoints before the slow one complete
+			want := []string{
+				`adguardhome_collector_success{collector="stats"} 1`,
+				`adguardhome_collector_success{collector="status"} 1`,
+				`adguardhome_collector_success{collector="filtering"} 0`,
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		}
+	}
+}
+
+func TestPortEnvironment(t *testing.T) {
+	stub := newAdGuardStub(t)
+	freePort := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	}
+	serving := func(port string) bool {
+		res, err := http.Get("http://127.0.0.1:" + port + "/healthz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	}
+
+	for _, tc := range []struct {
+		name           string
+		flag, variable bool
+	}{
+		{"PORT", false, false},
+		{"address flag", true, false},
+		{"address variable", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			port, other := freePort(), freePort()
+			args := []string{"-endpoint", stub.URL}
+			env := append(os.Environ(), "ADGUARD_EXPORTER_TEST_MAIN=1", "PORT="+port)
+			if tc.flag {
+				args = append(args, "-address", "127.0.0.1:"+other)
+			}
+			if tc.variable {
+				env = append(env, "ADGUARD_ADDRESS=127.0.0.1:"+other)
+			}
+			cmd := exec.Command(os.Args[0], args...)
+			cmd.Env = env
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				cmd.Process.Kill()
+				cmd.Wait()
+			})
+
+			want, unused := port, other
+			if tc.flag || tc.variable {
+				want, unused = other, port
+			}
+			waitFor(t, "the exporter to listen on "+want, func() bool { return serving(want) })
+			if serving(unused) {
+				t.Errorf("got the exporter listening on %v too", unused)
+			}
+		})
+	}
+}
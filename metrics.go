@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	gaugeMetric     = "gauge"
+	counterMetric   = "counter"
+	histogramMetric = "histogram"
+)
+
+// metricInfo keeps what a prometheus.Desc doesn't expose, for -list-metrics.
+type metricInfo struct {
+	Name, Type, Help string
+	Labels           []string
+}
+
+var metricInfos = map[*prometheus.Desc]metricInfo{}
+
+// defaultDescs are the descs of newDesc, named under the default namespace.
+var defaultDescs []*prometheus.Desc
+
+// newDesc creates a Desc and records its type, name, help and labels.
+func newDesc(metricType, fqName, help string, labels []string) *prometheus.Desc {
+	desc := recordDesc(metricInfo{
+		Name:   fqName,
+		Type:   metricType,
+		Help:   help,
+		Labels: labels,
+	})
+	defaultDescs = append(defaultDescs, desc)
+	return desc
+}
+
+func recordDesc(info metricInfo) *prometheus.Desc {
+	desc := prometheus.NewDesc(info.Name, info.Help, info.Labels, nil)
+	metricInfos[desc] = info
+	return desc
+}
+
+// listMetrics writes every metric the exporter can produce, taken from the
+// Describe output of an exporter with all optional collectors enabled, named
+// under ns.
+func listMetrics(w io.Writer, ns string) error {
+	descs := newDescSet(ns, nil)
+	e := NewExporter("", "", "")
+	e.Descs = descs
+	e.Counters = true
+	e.Session = &Session{}
+	e.ProcessingTimeMilliseconds = true
+	e.QueryLog = NewQueryLog(0, nil)
+	e.QueryLog.UpstreamHistograms = true
+	e.Status = &Status{}
+	e.DHCP = true
+	e.Clients = true
+	e.DNSInfo = true
+	e.Filtering = true
+	e.QueryLogFile = "querylog.json"
+	e.StaleMaxAge = time.Minute
+	e.DNSProbe = &DNSProbe{}
+	e.HostChecks = &HostChecks{}
+	e.TLSProbe = &TLSProbe{DoT: true, DoH: true}
+	e.UpstreamProbes = &UpstreamProbes{}
+
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		(&CoalescingCollector{Collector: e, Descs: descs}).Describe(ch)
+		(&Reloader{Descs: descs}).Describe(ch)
+		close(ch)
+	}()
+
+	var infos []metricInfo
+	for desc := range ch {
+		infos = append(infos, metricInfos[desc])
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tLABELS\tHELP")
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", info.Name, info.Type, strings.Join(info.Labels, ","), info.Help)
+	}
+
+	return tw.Flush()
+}
+
+// checkNamespace rejects a -metrics.namespace that can't start a metric
+// name.
+func checkNamespace(ns string) error {
+	if !labelNameRE.MatchString(ns) || strings.HasPrefix(ns, "__") {
+		return fmt.Errorf("%q: invalid metric name prefix", ns)
+	}
+	return nil
+}
+
+// withNamespace returns name under ns instead of the default namespace.
+func withNamespace(name, ns string) string {
+	if rest, ok := strings.CutPrefix(name, namespace+"_"); ok {
+		return ns + "_" + rest
+	}
+	return name
+}
+
+// metricsDropped returns the metrics left out by an allowlist of short
+// names, the names without the namespace as in -list-metrics. up is never
+// left out, an empty list leaves out nothing.
+func metricsDropped(list string) (map[string]bool, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	allowed := map[string]bool{}
+	for _, short := range strings.Split(list, ",") {
+		if short = strings.TrimSpace(short); short != "" {
+			allowed[namespace+"_"+short] = true
+		}
+	}
+
+	known := map[string]bool{}
+	dropped := map[string]bool{}
+	for _, desc := range defaultDescs {
+		info := metricInfos[desc]
+		known[info.Name] = true
+		if !allowed[info.Name] && info.Name != namespace+"_up" {
+			dropped[info.Name] = true
+		}
+	}
+	for name := range allowed {
+		if !known[name] {
+			return nil, fmt.Errorf("%q: unknown metric, see -list-metrics", strings.TrimPrefix(name, namespace+"_"))
+		}
+	}
+	return dropped, nil
+}
+
+// descSet holds the descs the metrics are exposed with: those of newDesc
+// named under another namespace, without the dropped ones. The metrics are
+// collected with the default descs and sent on with these, metrics of other
+// namespaces like go_ stay as they are. A nil descSet exposes the default
+// descs.
+type descSet struct {
+	descs   map[*prometheus.Desc]*prometheus.Desc
+	dropped map[*prometheus.Desc]bool
+}
+
+// newDescSet builds the descs of the metrics under ns, leaving out the
+// dropped names of metricsDropped.
+func newDescSet(ns string, dropped map[string]bool) *descSet {
+	if ns == namespace && len(dropped) == 0 {
+		return nil
+	}
+
+	d := &descSet{
+		descs:   map[*prometheus.Desc]*prometheus.Desc{},
+		dropped: map[*prometheus.Desc]bool{},
+	}
+	for _, desc := range defaultDescs {
+		info := metricInfos[desc]
+		if dropped[info.Name] {
+			d.dropped[desc] = true
+			continue
+		}
+		if ns != namespace {
+			info.Name = withNamespace(info.Name, ns)
+			d.descs[desc] = recordDesc(info)
+		}
+	}
+	return d
+}
+
+// describe sends the descs of describe as exposed.
+func (d *descSet) describe(ch chan<- *prometheus.Desc, describe func(chan<- *prometheus.Desc)) {
+	if d == nil {
+		describe(ch)
+		return
+	}
+
+	inner := make(chan *prometheus.Desc)
+	go func() {
+		describe(inner)
+		close(inner)
+	}()
+	for desc := range inner {
+		if d.dropped[desc] {
+			continue
+		}
+		if exposed, ok := d.descs[desc]; ok {
+			desc = exposed
+		}
+		ch <- desc
+	}
+}
+
+// collect sends the metrics of collect as exposed.
+func (d *descSet) collect(ch chan<- prometheus.Metric, collect func(chan<- prometheus.Metric)) {
+	if d == nil {
+		collect(ch)
+		return
+	}
+
+	inner := make(chan prometheus.Metric)
+	go func() {
+		collect(inner)
+		close(inner)
+	}()
+	for m := range inner {
+		if !d.dropped[m.Desc()] {
+			ch <- d.metric(m)
+		}
+	}
+}
+
+// metric returns m with its desc as exposed.
+func (d *descSet) metric(m prometheus.Metric) prometheus.Metric {
+	if d == nil {
+		return m
+	}
+	if exposed, ok := d.descs[m.Desc()]; ok {
+		return exposedMetric{Metric: m, desc: exposed}
+	}
+	return m
+}
+
+// exposedMetric is a metric sent on with another desc.
+type exposedMetric struct {
+	prometheus.Metric
+	desc *prometheus.Desc
+}
+
+func (m exposedMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseLabels parses key=value pairs into constant labels. Names must be
+// valid, not reserved and not clash with the labels of the metrics.
+func parseLabels(pairs []string) (prometheus.Labels, error) {
+	used := map[string]bool{}
+	for _, info := range metricInfos {
+		for _, label := range info.Labels {
+			used[label] = true
+		}
+	}
+
+	labels := prometheus.Labels{}
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%q: expected key=value", pair)
+		case !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__"):
+			return nil, fmt.Errorf("%q: invalid label name", name)
+		case used[name]:
+			return nil, fmt.Errorf("%q: already used by metrics", name)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("%q: given twice", name)
+		}
+		labels[name] = value
+	}
+
+	return labels, nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListMetrics(t *testing.T) {
+	var b bytes.Buffer
+	if err := listMetrics(&b, namespace); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(b.String(), "\n")
+
+	for _, want := range [][]string{
+		{"adguardhome_dns_queries", "gauge"},
+		{"adguardhome_up", "gauge"},
+		{"adguardhome_upstream_query_duration_seconds", "histogram", "address"},
+	} {
+		found := false
+		for _, line := range lines {
+			if fields := strings.Fields(line); len(fields) >= len(want) && fields[0] == want[0] {
+				found = strings.Join(fields[:len(want)], " ") == strings.Join(want, " ")
+				break
+			}
+		}
+		if !found {
+			t.Errorf("got\n%s\nwant a line starting with %q", b.String(), want)
+		}
+	}
+
+	b.Reset()
+	if err := listMetrics(&b, "adguard"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "\nadguard_dns_queries ") || strings.Contains(b.String(), "adguardhome_") {
+		t.Errorf("got\n%s\nwant the metrics under the adguard namespace", b.String())
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	for _, tc := range []struct {
+		pairs []string
+		want  prometheus.Labels
+		err   string
+	}{
+		{[]string{"region=eu", "site="}, prometheus.Labels{"region": "eu", "site": ""}, ""},
+		{[]string{"region"}, nil, "expected key=value"},
+		{[]string{"1region=eu"}, nil, "invalid label name"},
+		{[]string{"__name__=x"}, nil, "invalid label name"},
+		{[]string{"domain=x"}, nil, "already used by metrics"},
+		{[]string{"site=a", "site=b"}, nil, "given twice"},
+	} {
+		got, err := parseLabels(tc.pairs)
+		switch {
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: got error %v, want %q", tc.pairs, err, tc.err)
+		case tc.err == "" && (err != nil || !maps.Equal(got, tc.want)):
+			t.Errorf("%q: got %v, %v, want %v", tc.pairs, got, err, tc.want)
+		}
+	}
+}
+
+func TestConstLabelsScraped(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-label", "region=eu", "-label", "site=home")
+
+	res, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	for _, want := range []string{
+		`adguardhome_dns_queries{region="eu",site="home"} 100`,
+		`adguardhome_up{region="eu",site="home"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("got\n%s\nwant %s", body, want)
+		}
+	}
+
+	if out, err := exporterOutput(t, "-endpoint", stub.URL, "-label", "bad-name=x"); err == nil || !strings.Contains(out, "invalid label name") {
+		t.Errorf("got %v:\n%s\nwant an invalid label name", err, out)
+	}
+}
+
+func TestConstLabelsEveryFamily(t *testing.T) {
+	stub := newAdGuardStub(t)
+	config := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(config, []byte("metrics:\n  const-label: [site=parents, env=prod]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"-metrics.const-label", "site=parents", "-metrics.const-label", "env=prod"},
+		{"-config.file", config},
+	} {
+		base := runExporter(t, append([]string{"-endpoint", stub.URL, "-collector.status"}, args...)...)
+
+		for _, path := range []string{"/metrics", "/metrics?collect[]=status", "/probe?target=" + stub.endpoint()} {
+			res, err := http.Get(base + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parser expfmt.TextParser
+			families, err := parser.TextToMetricFamilies(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
+			if len(families) < 5 {
+				t.Errorf("%v: got %d families", path, len(families))
+			}
+			for name, mf := range families {
+				for _, m := range mf.GetMetric() {
+					labels := map[string]string{}
+					for _, label := range m.GetLabel() {
+						labels[label.GetName()] = label.GetValue()
+					}
+					if labels["site"] != "parents" || labels["env"] != "prod" {
+						t.Errorf("%q %v: %v got labels %v", args, path, name, labels)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMetricsNamespace(t *testing.T) {
+	for ns, ok := range map[string]bool{
+		"adguard":        true,
+		"adguard_legacy": true,
+		"_adguard":       true,
+		"1adguard":       false,
+		"adguard-home":   false,
+		"__adguard":      false,
+		"":               false,
+	} {
+		if err := checkNamespace(ns); (err == nil) != ok {
+			t.Errorf("%q: got %v", ns, err)
+		}
+	}
+
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-metrics.namespace", "adguard_legacy", "-collector.status")
+
+	for _, path := range []string{"/metrics", "/metrics?collect[]=stats&collect[]=status"} {
+		res, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		for _, want := range []string{
+			"adguard_legacy_up 1",
+			"adguard_legacy_dns_queries 100",
+			`adguard_legacy_collector_success{collector="status"} 1`,
+			`adguard_legacy_exporter_requests_total{code="200",endpoint="stats"}`,
+		} {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("%v: got\n%s\nwant %s", path, body, want)
+			}
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			name := strings.Fields(strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE "))[0]
+			if !strings.HasPrefix(name, "adguard_legacy_") && !strings.HasPrefix(name, "go_") &&
+				!strings.HasPrefix(name, "process_") && !strings.HasPrefix(name, "promhttp_") {
+				t.Errorf("%v: got %q, want the custom namespace", path, line)
+			}
+		}
+	}
+
+	if out, err := exporterOutput(t, "-endpoint", stub.URL, "-metrics.namespace", "adguard-home"); err == nil || !strings.Contains(out, "invalid metric name prefix") {
+		t.Errorf("got %v:\n%s\nwant the namespace rejected", err, out)
+	}
+}
+
+func TestDescSet(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Descs = newDescSet("agh", map[string]bool{"adguardhome_blocked_dns_queries": true})
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, family := range families {
+		got = append(got, family.GetName())
+	}
+	if !slices.Contains(got, "agh_dns_queries") || slices.Contains(got, "agh_blocked_dns_queries") {
+		t.Errorf("got %v, want agh_dns_queries without agh_blocked_dns_queries", got)
+	}
+	for _, name := range got {
+		if !strings.HasPrefix(name, "agh_") {
+			t.Errorf("got %v, want the agh namespace", name)
+		}
+	}
+
+	// the exposed descs are known by their exposed names
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		e.Describe(ch)
+		close(ch)
+	}()
+	for desc := range ch {
+		if name := metricInfos[desc].Name; !strings.HasPrefix(name, "agh_") {
+			t.Errorf("got %q for %v, want the agh namespace", name, desc)
+		}
+	}
+}
+
+func TestMetricsAllowlist(t *testing.T) {
+	if dropped, err := metricsDropped(" "); err != nil || dropped != nil {
+		t.Errorf("empty list: got %v, %v, want nothing dropped", dropped, err)
+	}
+	dropped, err := metricsDropped("dns_queries, top_clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"adguardhome_dns_queries":         false,
+		"adguardhome_top_clients":         false,
+		"adguardhome_up":                  false,
+		"adguardhome_blocked_dns_queries": true,
+	} {
+		if dropped[name] != want {
+			t.Errorf("%v: got dropped %v, want %v", name, dropped[name], want)
+		}
+	}
+
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-metrics", "dns_queries,top_clients", "-collector.status")
+	res, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for name := range families {
+		if strings.HasPrefix(name, namespace+"_") {
+			got = append(got, name)
+		}
+	}
+	slices.Sort(got)
+	if want := []string{"adguardhome_dns_queries", "adguardhome_top_clients", "adguardhome_up"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if out, err := exporterOutput(t, "-endpoint", stub.URL, "-metrics", "dns_queries,dns_querys"); err == nil || !strings.Contains(out, `"dns_querys": unknown metric`) {
+		t.Errorf("got %v:\n%s\nwant the unknown metric rejected", err, out)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var probeSuccess = newDesc(gaugeMetric,
+	"probe_success",
+	"Whether the probe succeeded (1) or not (0).",
+	nil,
+)
+
+// probeCollector collects the /control/stats metrics of one target and keeps
+// the error, it leaves the query log and other stateful collectors alone so
+// probing doesn't take entries away from /metrics.
+type probeCollector struct {
+	ctx      context.Context
+	exporter *Exporter
+	err      error
+}
+
+// Describe sends nothing, which makes it an unchecked collector.
+func (c *probeCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.Descs.collect(ch, func(ch chan<- prometheus.Metric) {
+		c.err = c.exporter.CollectFromAPI(c.ctx, ch)
+		ch <- prometheus.MustNewConstMetric(up, prometheus.GaugeValue, boolToFloat(c.err == nil))
+		ch <- prometheus.MustNewConstMetric(probeSuccess, prometheus.GaugeValue, boolToFloat(c.err == nil))
+	})
+}
+
+// ProbeHandler scrapes the target named by the target parameter on demand.
+// The targets of the given exporters can be probed by endpoint, with probes
+// also allowed targets by URL with the credentials of the auth_module
+// parameter. As with the blackbox exporter a failed probe still answers 200,
+// Prometheus drops the body of any other status, with probe_success 0 and
+// the reason logged and as a comment for curl output. Only bad requests get
+// an error status.
+func ProbeHandler(probes *ProbeTargets, exporters func() []*Exporter, constLabels prometheus.Labels) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, module := r.URL.Query().Get("target"), r.URL.Query().Get("auth_module")
+
+		exporter := selectExporter(exporters(), target, false)
+		if exporter == nil || module != "" {
+			if probes == nil {
+				http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusNotFound)
+				return
+			}
+			var status int
+			var err error
+			if exporter, status, err = probes.exporter(target, module); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+		}
+
+		c := &probeCollector{ctx: r.Context(), exporter: exporter}
+		registry := prometheus.NewRegistry()
+		prometheus.WrapRegistererWith(constLabels, registry).MustRegister(c)
+		mfs, err := registry.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		format := expfmt.NewFormat(expfmt.TypeTextPlain)
+		w.Header().Set("Content-Type", string(format))
+		if c.err != nil {
+			slog.Error(fmt.Sprintf("Probe of %v failed: %v: %v", exporter.Endpoint, probeFailureReason(c.err), c.err))
+			fmt.Fprintf(w, "# %v: %v\n", probeFailureReason(c.err), c.err)
+		}
+
+		enc := expfmt.NewEncoder(w, format)
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// probeFailureReason gives a short reason for a failed probe.
+func probeFailureReason(err error) string {
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden):
+		return "auth failed"
+	case errors.As(err, &statusErr):
+		return "unexpected status"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "connection failed"
+	}
+	return "collection failed"
+}
+
+// ProbeTargets lets /probe scrape targets named by URL rather than only the
+// configured ones, so relabeling in Prometheus drives the target list. The
+// credentials come from auth modules, never from the request.
+type ProbeTargets struct {
+	// Exporter gives the settings for the probed targets.
+	Exporter *Exporter
+
+	// Allow holds glob patterns of the hosts that may be probed, matched
+	// against the host and host:port of the target.
+	Allow []string
+
+	Modules map[string]*probeModule
+}
+
+// probeModule is a -probe.auth-module, credentials and TLS options of a
+// target with the client built for them.
+type probeModule struct {
+	options *target
+	client  *http.Client
+}
+
+// parseAuthModule parses a -probe.auth-module, name?options, and builds its
+// client on base.
+func parseAuthModule(s string, base *http.Transport) (string, *probeModule, error) {
+	name, rawQuery, _ := strings.Cut(s, "?")
+	if name == "" {
+		return "", nil, errors.New("missing name")
+	}
+	options := &target{}
+	if err := options.setOptions(rawQuery, true); err != nil {
+		return "", nil, fmt.Errorf("%v: %w", name, err)
+	}
+	if options.Username == "" && options.Password != "" {
+		return "", nil, fmt.Errorf("%v: password without username", name)
+	}
+
+	transport, err := options.transport(base)
+	if err != nil {
+		return "", nil, fmt.Errorf("%v: %w", name, err)
+	}
+	secrets.Add(options.Password)
+
+	return name, &probeModule{options: options, client: &http.Client{Transport: transport}}, nil
+}
+
+// apply gives e the credentials, timeout and client of the module, a nil
+// module means no credentials at all.
+func (m *probeModule) apply(e *Exporter) {
+	e.Username, e.Password, e.Token = "", "", ""
+	e.ownCredentials = true
+	e.NoAuth = m == nil || m.options.Username == ""
+	if m == nil {
+		return
+	}
+
+	e.Username, e.Password = m.options.Username, m.options.Password
+	if m.options.Timeout > 0 {
+		e.EndpointTimeout = m.options.Timeout
+	}
+	e.Client = m.client
+}
+
+// exporter returns an exporter for a target URL with the credentials of the
+// module, and the status to answer with if that isn't possible.
+func (p *ProbeTargets) exporter(rawTarget, moduleName string) (*Exporter, int, error) {
+	var module *probeModule
+	if moduleName != "" {
+		if module = p.Modules[moduleName]; module == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown auth module %q", moduleName)
+		}
+	}
+
+	t, err := parseTarget(rawTarget)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid target: %w", err)
+	}
+	// parseTarget succeeded, so this can't fail
+	u, _ := url.Parse(rawTarget)
+	if u.User != nil || u.RawQuery != "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid target %v: credentials and options come from auth modules", u.Redacted())
+	}
+	if !matchDomain(p.Allow, u.Hostname()) && !matchDomain(p.Allow, u.Host) {
+		return nil, http.StatusForbidden, fmt.Errorf("target %v isn't allowed", u.Host)
+	}
+
+	e := p.Exporter.forTarget(t)
+	module.apply(e)
+
+	return e, 0, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	dnsProbeSuccess = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "dns_probe_success"),
+		"Whether the DNS probe got a NOERROR or NXDOMAIN answer (1) or not (0).",
+		nil,
+	)
+	dnsProbeDuration = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "dns_probe_duration_seconds"),
+		"Duration of the DNS probe (in seconds).",
+		nil,
+	)
+	dnsProbeRcode = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "dns_probe_rcode"),
+		"Response code of the DNS probe answer.",
+		nil,
+	)
+)
+
+// DNSProbe sends a real DNS query to AdGuard's resolver, independent of the
+// API being healthy.
+type DNSProbe struct {
+	Target   string
+	Protocol string
+	Timeout  time.Duration
+
+	name  string
+	qtype uint16
+}
+
+// NewDNSProbe parses a query like "example.com A", the type defaults to A.
+func NewDNSProbe(target, protocol, query string, timeout time.Duration) (*DNSProbe, error) {
+	if protocol != "udp" && protocol != "tcp" {
+		return nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+
+	fields := strings.Fields(query)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid query %q", query)
+	}
+
+	qtype := dns.TypeA
+	if len(fields) == 2 {
+		t, ok := dns.StringToType[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("unknown query type %q", fields[1])
+		}
+		qtype = t
+	}
+
+	return &DNSProbe{
+		Target:   target,
+		Protocol: protocol,
+		Timeout:  timeout,
+		name:     dns.Fqdn(fields[0]),
+		qtype:    qtype,
+	}, nil
+}
+
+func (p *DNSProbe) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dnsProbeSuccess
+	ch <- dnsProbeDuration
+	ch <- dnsProbeRcode
+}
+
+func (p *DNSProbe) Collect(ch chan<- prometheus.Metric) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(p.name, p.qtype)
+
+	c := &dns.Client{Net: p.Protocol, Timeout: p.Timeout}
+
+	start := time.Now()
+	res, _, err := c.Exchange(msg, p.Target)
+	duration := time.Since(start)
+
+	success := err == nil && (res.Rcode == dns.RcodeSuccess || res.Rcode == dns.RcodeNameError)
+	if err != nil {
+		slog.Error(fmt.Sprintf("DNS probe failed: %v", err))
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		dnsProbeSuccess, prometheus.GaugeValue, boolToFloat(success),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		dnsProbeDuration, prometheus.GaugeValue, duration.Seconds(),
+	)
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			dnsProbeRcode, prometheus.GaugeValue, float64(res.Rcode),
+		)
+	}
+}
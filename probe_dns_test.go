@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveDNS answers A queries for adguard-probe.example.com over network,
+// refuses any other, and returns the address of the server.
+func serveDNS(t *testing.T, network string) string {
+	t.Helper()
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		res := new(dns.Msg)
+		res.SetReply(req)
+		if q := req.Question[0]; q.Name != "adguard-probe.example.com." || q.Qtype != dns.TypeA {
+			res.Rcode = dns.RcodeRefused
+		} else {
+			res.Answer = append(res.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+		}
+		w.WriteMsg(res)
+	})
+
+	server := &dns.Server{Handler: handler}
+	var addr string
+	if network == "tcp" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Listener, addr = l, l.Addr().String()
+	} else {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.PacketConn, addr = conn, conn.LocalAddr().String()
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return addr
+}
+
+func TestDNSProbe(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	for _, tc := range []struct {
+		name, protocol, query string
+		target                func() string
+		want                  string
+	}{
+		{"udp", "udp", "adguard-probe.example.com", func() string { return serveDNS(t, "udp") }, `
+adguardhome_dns_probe_rcode 0
+adguardhome_dns_probe_success 1
+`},
+		{"tcp", "tcp", "adguard-probe.example.com A", func() string { return serveDNS(t, "tcp") }, `
+adguardhome_dns_probe_rcode 0
+adguardhome_dns_probe_success 1
+`},
+		{"refused", "udp", "adguard-probe.example.com AAAA", func() string { return serveDNS(t, "udp") }, `
+adguardhome_dns_probe_rcode 5
+adguardhome_dns_probe_success 0
+`},
+		{"unreachable", "tcp", "adguard-probe.example.com", func() string { return closedAddr }, `
+adguardhome_dns_probe_success 0
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			probe, err := NewDNSProbe(tc.target(), tc.protocol, tc.query, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range collectMetrics(probe.Collect) {
+				if line := formatMetric(m); !strings.HasPrefix(line, "adguardhome_dns_probe_duration_seconds ") {
+					got = append(got, line)
+				}
+			}
+			slices.Sort(got)
+			if want := strings.Split(strings.TrimSpace(tc.want), "\n"); !slices.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if n := testutil.CollectAndCount(probe, "adguardhome_dns_probe_duration_seconds"); n != 1 {
+				t.Errorf("got %d durations, want 1", n)
+			}
+		})
+	}
+
+	for _, query := range []string{"", "a.example A extra", "a.example BOGUS"} {
+		if _, err := NewDNSProbe("127.0.0.1:53", "udp", query, time.Second); err == nil {
+			t.Errorf("%q: got no error", query)
+		}
+	}
+	if _, err := NewDNSProbe("127.0.0.1:53", "doh", "a.example", time.Second); err == nil {
+		t.Error("unknown protocol: got no error")
+	}
+}
+
+func TestDNSProbeFailureKeepsAPIMetrics(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.DNSProbe, err = NewDNSProbe(closed.Addr().String(), "tcp", "adguard-probe.example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	body := exposition(t, registry)
+	for _, want := range []string{"adguardhome_dns_probe_success 0", "adguardhome_up 1", "adguardhome_dns_queries 100"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got\n%s\nwant %v", body, want)
+		}
+	}
+}
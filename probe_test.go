@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/prometheus/common/expfmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandlerFailure(t *testing.T) {
+	ok, unauthorized, broken := newAdGuardStub(t), newAdGuardStub(t), newAdGuardStub(t)
+	unauthorized.fail("/control/stats", http.StatusUnauthorized)
+	broken.fail("/control/stats", http.StatusInternalServerError)
+	exporters := []*Exporter{
+		NewExporter(ok.endpoint(), "", ""),
+		NewExporter(unauthorized.endpoint(), "", ""),
+		NewExporter(broken.endpoint(), "", ""),
+	}
+	h := ProbeHandler(nil, func() []*Exporter { return exporters }, nil)
+
+	for _, tc := range []struct {
+		target string
+		status int
+		reason string
+		up     float64
+	}{
+		{ok.endpoint(), http.StatusOK, "", 1},
+		{unauthorized.endpoint(), http.StatusOK, "# auth failed: /control/stats: unexpected status 401 Unauthorized\n", 0},
+		{broken.endpoint(), http.StatusOK, "# unexpected status: /control/stats: unexpected status 500 Internal Server Error\n", 0},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+tc.target, nil))
+		body := rec.Body.String()
+
+		if rec.Code != tc.status || !strings.HasPrefix(body, tc.reason) {
+			t.Errorf("%v: got %d:\n%s\nwant %d with %q", tc.target, rec.Code, body, tc.status, tc.reason)
+		}
+		// the reason is a comment, the exposition stays valid
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(strings.NewReader(body))
+		if err != nil {
+			t.Errorf("%v: invalid exposition: %v", tc.target, err)
+			continue
+		}
+		// Prometheus only ingests a 200, failed probes included
+		for _, name := range []string{"adguardhome_up", "probe_success"} {
+			if got := families[name].GetMetric()[0].GetGauge().GetValue(); got != tc.up {
+				t.Errorf("%v: got %v %v, want %v", tc.target, name, got, tc.up)
+			}
+		}
+	}
+
+	for _, path := range []string{"/probe?target=unknown:3000", "/probe"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%v: got %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestProbeTargets(t *testing.T) {
+	stub, other := newAdGuardStub(t), newAdGuardStub(t)
+	name, module, err := parseAuthModule("home?username=admin&password=secretpw", &http.Transport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probes := &ProbeTargets{
+		Exporter: NewExporter("", "default", "defaultpw"),
+		Allow:    []string{stub.endpoint()},
+		Modules:  map[string]*probeModule{name: module},
+	}
+	h := ProbeHandler(probes, func() []*Exporter { return nil }, nil)
+
+	for _, tc := range []struct {
+		name, query   string
+		status        int
+		authorization string
+	}{
+		{"valid", "target=" + stub.URL + "&auth_module=home", http.StatusOK, basicAuthHeader("admin", "secretpw")},
+		// never the credentials of the exporter
+		{"without module", "target=" + stub.URL, http.StatusOK, ""},
+		{"unknown module", "target=" + stub.URL + "&auth_module=work", http.StatusBadRequest, ""},
+		{"disallowed target", "target=" + other.URL + "&auth_module=home", http.StatusForbidden, ""},
+		{"credentials in the target", "target=http://admin:secretpw@" + stub.endpoint(), http.StatusBadRequest, ""},
+		{"options in the target", "target=" + url.QueryEscape(stub.URL+"?insecure=true"), http.StatusBadRequest, ""},
+		{"invalid target", "target=" + stub.endpoint(), http.StatusBadRequest, ""},
+	} {
+		requests := stub.count("/control/stats")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?"+tc.query, nil))
+		body := rec.Body.String()
+
+		if rec.Code != tc.status {
+			t.Errorf("%v: got %d:\n%s\nwant %d", tc.name, rec.Code, body, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			if stub.count("/control/stats") != requests || other.count("/control/stats") != 0 {
+				t.Errorf("%v: got AdGuard requested", tc.name)
+			}
+			if strings.Contains(body, "secretpw") {
+				t.Errorf("%v: got the password in %q", tc.name, body)
+			}
+			continue
+		}
+		// each probe has a registry of its own
+		if strings.Count(body, "\nprobe_success 1\n") != 1 {
+			t.Errorf("%v: got\n%s\nwant probe_success 1 once", tc.name, body)
+		}
+		if got := stub.authorization(); got != tc.authorization {
+			t.Errorf("%v: got Authorization %q, want %q", tc.name, got, tc.authorization)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+)
+
+type tlsProbeDescs struct {
+	success, duration, version, notAfter *prometheus.Desc
+}
+
+func newTLSProbeDescs(protocol, name string) tlsProbeDescs {
+	return tlsProbeDescs{
+		success: newDesc(gaugeMetric,
+			prometheus.BuildFQName(namespace, protocol, "probe_success"),
+			fmt.Sprintf("Whether the %v probe succeeded (1) or not (0).", name),
+			nil,
+		),
+		duration: newDesc(gaugeMetric,
+			prometheus.BuildFQName(namespace, protocol, "probe_handshake_duration_seconds"),
+			fmt.Sprintf("TLS handshake duration of the %v probe (in seconds).", name),
+			nil,
+		),
+		version: newDesc(gaugeMetric,
+			prometheus.BuildFQName(namespace, protocol, "probe_tls_version_info"),
+			fmt.Sprintf("TLS version negotiated by the %v probe.", name),
+			[]string{"version"},
+		),
+		notAfter: newDesc(gaugeMetric,
+			prometheus.BuildFQName(namespace, protocol, "probe_cert_not_after_timestamp_seconds"),
+			fmt.Sprintf("Expiry of the leaf certificate served to the %v probe (unix time).", name),
+			nil,
+		),
+	}
+}
+
+var (
+	dotProbe = newTLSProbeDescs("dot", "DNS-over-TLS")
+	dohProbe = newTLSProbeDescs("doh", "DNS-over-HTTPS")
+)
+
+type TLSStatusResponse struct {
+	Enabled    bool   `json:"enabled"`
+	ServerName string `json:"server_name"`
+	PortHTTPS  int    `json:"port_https"`
+	PortDoT    int    `json:"port_dns_over_tls"`
+}
+
+// TLSProbe connects to the encrypted DNS listeners, to catch a listener
+// serving a stale certificate while the one on disk is fine. Targets default
+// to the endpoint host with the ports from /control/tls/status.
+type TLSProbe struct {
+	DoT, DoH  bool
+	DoTTarget string
+	DoHURL    string
+	Timeout   time.Duration
+}
+
+func (p *TLSProbe) Describe(ch chan<- *prometheus.Desc) {
+	for _, enabled := range []struct {
+		enabled bool
+		descs   tlsProbeDescs
+	}{
+		{p.DoT, dotProbe},
+		{p.DoH, dohProbe},
+	} {
+		if enabled.enabled {
+			ch <- enabled.descs.success
+			ch <- enabled.descs.duration
+			ch <- enabled.descs.version
+			ch <- enabled.descs.notAfter
+		}
+	}
+}
+
+func (e *Exporter) CollectFromTLSProbe(ctx context.Context, ch chan<- prometheus.Metric) {
+	p := e.TLSProbe
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	dotTarget, dohURL, serverName := p.DoTTarget, p.DoHURL, ""
+	if (p.DoT && dotTarget == "") || (p.DoH && dohURL == "") {
+		var status TLSStatusResponse
+		if err := e.get(ctx, "/control/tls/status", &status); err != nil {
+			slog.Error(fmt.Sprintf("Unable to get TLS status for the probes: %v", err))
+		} else if status.Enabled {
+			host, _, err := net.SplitHostPort(e.Endpoint)
+			if err != nil {
+				host = e.Endpoint
+			}
+			serverName = status.ServerName
+			if dotTarget == "" && status.PortDoT != 0 {
+				dotTarget = net.JoinHostPort(host, strconv.Itoa(status.PortDoT))
+			}
+			if dohURL == "" && status.PortHTTPS != 0 {
+				dohURL = fmt.Sprintf("https://%v/dns-query", net.JoinHostPort(host, strconv.Itoa(status.PortHTTPS)))
+			}
+		}
+	}
+
+	if p.DoT {
+		state, duration, err := probeDoT(ctx, dotTarget, serverName)
+		collectTLSProbe(ch, dotProbe, state, duration, err)
+	}
+	if p.DoH {
+		state, duration, err := probeDoH(ctx, dohURL, serverName)
+		collectTLSProbe(ch, dohProbe, state, duration, err)
+	}
+}
+
+func collectTLSProbe(ch chan<- prometheus.Metric, descs tlsProbeDescs, state *tls.ConnectionState, duration time.Duration, err error) {
+	if err != nil {
+		slog.Error(fmt.Sprintf("TLS probe failed: %v", err))
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		descs.success, prometheus.GaugeValue, boolToFloat(err == nil),
+	)
+	if state == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		descs.duration, prometheus.GaugeValue, duration.Seconds(),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		descs.version, prometheus.GaugeValue, 1, tls.VersionName(state.Version),
+	)
+	if len(state.PeerCertificates) > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			descs.notAfter, prometheus.GaugeValue, float64(state.PeerCertificates[0].NotAfter.Unix()),
+		)
+	}
+}
+
+// the probes look at the certificate as served, valid or not
+func probeTLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	}
+}
+
+func probeDoT(ctx context.Context, target, serverName string) (*tls.ConnectionState, time.Duration, error) {
+	if target == "" {
+		return nil, 0, fmt.Errorf("no DNS-over-TLS target")
+	}
+
+	dialer := &tls.Dialer{Config: probeTLSConfig(serverName)}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	duration := time.Since(start)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	return &state, duration, nil
+}
+
+func probeDoH(ctx context.Context, url, serverName string) (*tls.ConnectionState, time.Duration, error) {
+	if url == "" {
+		return nil, 0, fmt.Errorf("no DNS-over-HTTPS target")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("adguard-probe.example.com.", dns.TypeA)
+	msg.Id = 0
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var start time.Time
+	var duration time.Duration
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { start = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { duration = time.Since(start) },
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet,
+		fmt.Sprintf("%v?dns=%v", url, base64.RawURLEncoding.EncodeToString(query)), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	probeClient := http.Client{Transport: &http.Transport{
+		TLSClientConfig:   probeTLSConfig(serverName),
+		ForceAttemptHTTP2: true,
+		DisableKeepAlives: true,
+	}}
+	response, err := probeClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return response.TLS, duration, fmt.Errorf("%v: unexpected status %v", url, response.Status)
+	}
+
+	return response.TLS, duration, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveDoT accepts TLS connections with cert and completes their handshake,
+// returning the address listened on.
+func serveDoT(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestTLSProbe(t *testing.T) {
+	testCert := newTestCert(t, nil, "dns.home.arpa")
+	cert, err := tls.X509KeyPair(testCert.cert, testCert.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := serveDoT(t, cert)
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dns-query" || r.URL.Query().Get("dns") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+	}))
+	doh.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	doh.StartTLS()
+	t.Cleanup(doh.Close)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	port := func(addr string) int {
+		_, p, _ := net.SplitHostPort(strings.TrimPrefix(addr, "https://"))
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	notAfter := fmt.Sprint(float64(testCert.parsed.NotAfter.Unix()))
+	up := []string{
+		"adguardhome_doh_probe_cert_not_after_timestamp_seconds " + notAfter,
+		"adguardhome_doh_probe_success 1",
+		`adguardhome_doh_probe_tls_version_info{version="TLS 1.3"} 1`,
+		"adguardhome_dot_probe_cert_not_after_timestamp_seconds " + notAfter,
+		"adguardhome_dot_probe_success 1",
+		`adguardhome_dot_probe_tls_version_info{version="TLS 1.3"} 1`,
+	}
+
+	for _, tc := range []struct {
+		name      string
+		tlsStatus any
+		probe     TLSProbe
+		want      []string
+	}{
+		{
+			name:      "ports from the TLS status",
+			tlsStatus: map[string]any{"enabled": true, "server_name": "dns.home.arpa", "port_https": port(doh.URL), "port_dns_over_tls": port(dot)},
+			probe:     TLSProbe{DoT: true, DoH: true},
+			want:      up,
+		},
+		{
+			name:  "targets from flags",
+			probe: TLSProbe{DoT: true, DoH: true, DoTTarget: dot, DoHURL: doh.URL + "/dns-query"},
+			want:  up,
+		},
+		{
+			name:  "listener down",
+			probe: TLSProbe{DoT: true, DoTTarget: closed.Addr().String()},
+			want:  []string{"adguardhome_dot_probe_success 0"},
+		},
+		{
+			name:      "TLS disabled",
+			tlsStatus: map[string]any{"enabled": false},
+			probe:     TLSProbe{DoT: true, DoH: true},
+			want:      []string{"adguardhome_doh_probe_success 0", "adguardhome_dot_probe_success 0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := newAdGuardStub(t)
+			if tc.tlsStatus != nil {
+				stub.set("/control/tls/status", tc.tlsStatus)
+			}
+			e := NewExporter(stub.endpoint(), "", "")
+			e.TLSProbe = &tc.probe
+			e.TLSProbe.Timeout = 5 * time.Second
+
+			var got []string
+			for _, m := range collectMetrics(func(ch chan<- prometheus.Metric) { e.CollectFromTLSProbe(t.Context(), ch) }) {
+				if line := formatMetric(m); !strings.Contains(line, "_handshake_duration_seconds ") {
+					got = append(got, line)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	upstreamProbeSuccess = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "upstream_probe_success"),
+		"Whether the upstream was reachable from the exporter (1) or not (0).",
+		[]string{"address"},
+	)
+	upstreamProbeDuration = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "upstream_probe_duration_seconds"),
+		"Duration of the upstream probe (in seconds).",
+		[]string{"address"},
+	)
+)
+
+type upstreamProbeResult struct {
+	success  bool
+	duration time.Duration
+}
+
+// UpstreamProbes checks the upstreams configured in AdGuard directly, on its
+// own interval so a slow upstream never delays a scrape. Only plain, tcp://,
+// tls:// and https:// upstreams are probed.
+type UpstreamProbes struct {
+	Interval    time.Duration
+	Timeout     time.Duration
+	Concurrency int
+
+	// RootCAs verify tls:// and https:// upstreams, nil for the system roots.
+	RootCAs *x509.CertPool
+
+	mu      sync.Mutex
+	results map[string]upstreamProbeResult
+}
+
+func (p *UpstreamProbes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upstreamProbeSuccess
+	ch <- upstreamProbeDuration
+}
+
+func (p *UpstreamProbes) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for address, result := range p.results {
+		ch <- prometheus.MustNewConstMetric(
+			upstreamProbeSuccess, prometheus.GaugeValue, boolToFloat(result.success), address,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			upstreamProbeDuration, prometheus.GaugeValue, result.duration.Seconds(), address,
+		)
+	}
+}
+
+// RunUpstreamProbes probes the upstreams every Interval until ctx is done.
+func (e *Exporter) RunUpstreamProbes(ctx context.Context) {
+	ticker := time.NewTicker(e.UpstreamProbes.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.probeUpstreams(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Unable to probe upstreams: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Exporter) probeUpstreams(ctx context.Context) error {
+	p := e.UpstreamProbes
+
+	var info DNSInfoResponse
+	if err := e.get(ctx, "/control/dns_info", &info); err != nil {
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, max(p.Concurrency, 1))
+		results = map[string]upstreamProbeResult{}
+	)
+	for _, upstream := range parseUpstreams(info.UpstreamDNS) {
+		probe := upstreamProbeFor(upstream, p.RootCAs)
+		if probe == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe(ctx)
+			result := upstreamProbeResult{success: err == nil, duration: time.Since(start)}
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Upstream %v unreachable: %v", upstream, err))
+			}
+
+			mu.Lock()
+			results[upstream] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.results = results
+	p.mu.Unlock()
+
+	return nil
+}
+
+// parseUpstreams extracts the servers from AdGuard's upstream lines, which
+// may be comments or scoped to domains like "[/example.com/]8.8.8.8 8.8.4.4".
+func parseUpstreams(lines []string) []string {
+	seen := map[string]struct{}{}
+	var upstreams []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[/") {
+			end := strings.Index(line, "/]")
+			if end < 0 {
+				continue
+			}
+			line = line[end+2:]
+		}
+
+		for _, upstream := range strings.Fields(line) {
+			// "#" sends a domain to the default upstreams
+			if upstream == "#" {
+				continue
+			}
+			if _, ok := seen[upstream]; !ok {
+				seen[upstream] = struct{}{}
+				upstreams = append(upstreams, upstream)
+			}
+		}
+	}
+
+	return upstreams
+}
+
+// upstreamProbeFor returns the probe for an upstream, or nil if its scheme
+// isn't supported.
+func upstreamProbeFor(upstream string, rootCAs *x509.CertPool) func(context.Context) error {
+	if !strings.Contains(upstream, "://") {
+		return dnsUpstreamProbe("udp", withDefaultPort(upstream, "53"))
+	}
+
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		return dnsUpstreamProbe(u.Scheme, withDefaultPort(u.Host, "53"))
+	case "tls":
+		return func(ctx context.Context) error {
+			dialer := &tls.Dialer{Config: &tls.Config{ServerName: u.Hostname(), RootCAs: rootCAs}}
+			conn, err := dialer.DialContext(ctx, "tcp", withDefaultPort(u.Host, "853"))
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	case "https":
+		return func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, upstream, nil)
+			if err != nil {
+				return err
+			}
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:             http.ProxyFromEnvironment,
+				TLSClientConfig:   &tls.Config{RootCAs: rootCAs},
+				DisableKeepAlives: true,
+			}}
+			response, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			response.Body.Close()
+			if response.StatusCode >= http.StatusInternalServerError {
+				return fmt.Errorf("unexpected status %v", response.Status)
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// dnsUpstreamProbe succeeds on any DNS response, the rcode doesn't matter.
+func dnsUpstreamProbe(network, address string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		msg := new(dns.Msg)
+		msg.SetQuestion(".", dns.TypeNS)
+
+		c := &dns.Client{Net: network}
+		_, _, err := c.ExchangeContext(ctx, msg, address)
+		return err
+	}
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
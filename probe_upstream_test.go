@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUpstreams(t *testing.T) {
+	got := parseUpstreams([]string{
+		"# comment",
+		"tls://1.1.1.1",
+		"[/example.com/]8.8.8.8 tcp://8.8.4.4",
+		"[/home.arpa/local/]#",
+		"[/broken.example/",
+		"  https://dns.example/dns-query  ",
+		"8.8.8.8",
+	})
+	want := []string{"tls://1.1.1.1", "8.8.8.8", "tcp://8.8.4.4", "https://dns.example/dns-query"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUpstreamProbes(t *testing.T) {
+	testCert := newTestCert(t, nil)
+	cert, err := tls.X509KeyPair(testCert.cert, testCert.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(testCert.parsed)
+
+	udp, tcp, dot := serveDNS(t, "udp"), serveDNS(t, "tcp"), serveDoT(t, cert)
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	doh.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	doh.StartTLS()
+	t.Cleanup(doh.Close)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	stub := newAdGuardStub(t)
+	stub.set("/control/dns_info", map[string]any{"upstream_dns": []string{
+		udp,
+		"[/example.com/]tcp://" + tcp,
+		"tls://" + dot,
+		doh.URL + "/dns-query",
+		"tcp://" + closed.Addr().String(),
+		"quic://dns.example",
+	}})
+	e := NewExporter(stub.endpoint(), "", "")
+	e.UpstreamProbes = &UpstreamProbes{Timeout: 5 * time.Second, Concurrency: 2, RootCAs: roots}
+	if err := e.probeUpstreams(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, m := range collectMetrics(e.UpstreamProbes.Collect) {
+		if line := formatMetric(m); strings.HasPrefix(line, "adguardhome_upstream_probe_success") {
+			got = append(got, line)
+		}
+	}
+	slices.Sort(got)
+	want := []string{
+		`adguardhome_upstream_probe_success{address="` + doh.URL + `/dns-query"} 1`,
+		`adguardhome_upstream_probe_success{address="` + udp + `"} 1`,
+		`adguardhome_upstream_probe_success{address="tcp://` + closed.Addr().String() + `"} 0`,
+		`adguardhome_upstream_probe_success{address="tcp://` + tcp + `"} 1`,
+		`adguardhome_upstream_probe_success{address="tls://` + dot + `"} 1`,
+	}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the system roots don't trust the test certificate
+	e.UpstreamProbes.RootCAs = nil
+	if err := e.probeUpstreams(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range collectMetrics(e.UpstreamProbes.Collect) {
+		if line := formatMetric(m); strings.Contains(line, `"tls://`) && strings.HasPrefix(line, "adguardhome_upstream_probe_success") && !strings.HasSuffix(line, " 0") {
+			t.Errorf("got %v with an untrusted certificate, want 0", line)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	queryDuration = newDesc(histogramMetric,
+		prometheus.BuildFQName(namespace, "", "query_duration_seconds"),
+		"DNS query processing time from the query log (in seconds).",
+		nil,
+	)
+	upstreamQueryDuration = newDesc(histogramMetric,
+		prometheus.BuildFQName(namespace, "", "upstream_query_duration_seconds"),
+		"DNS query processing time from the query log per upstream (in seconds).",
+		[]string{"address"},
+	)
+	querylogDomainQueries = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "querylog", "domain_queries_total"),
+		"DNS queries per domain from the query log.",
+		[]string{"domain"},
+	)
+	querylogClientQueries = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "querylog", "client_queries_total"),
+		"DNS queries per client from the query log.",
+		[]string{"client"},
+	)
+	ecsQueries = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "", "dns_queries_with_ecs_total"),
+		"DNS queries from the query log carrying EDNS Client Subnet information.",
+		nil,
+	)
+	dnsQueriesByProtocol = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "", "dns_queries_by_protocol_total"),
+		"DNS queries from the query log by client protocol.",
+		[]string{"protocol"},
+	)
+	dnsQueryErrors = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "", "dns_query_errors_total"),
+		"DNS queries from the query log answered with SERVFAIL, as when the upstreams failed.",
+		nil,
+	)
+	querylogIgnoredEntries = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "querylog", "ignored_entries_total"),
+		"Query log entries dropped by the ignore lists.",
+		nil,
+	)
+	activeClients = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "active_clients"),
+		"Distinct clients in the query log window.",
+		nil,
+	)
+	activeClientsEstimated = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "active_clients_estimated"),
+		"Whether active_clients is an estimate (1) or exact (0).",
+		nil,
+	)
+	uniqueDomains = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "unique_domains"),
+		"Distinct domains in the query log window.",
+		nil,
+	)
+	uniqueDomainsEstimated = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "unique_domains_estimated"),
+		"Whether unique_domains is an estimate (1) or exact (0).",
+		nil,
+	)
+	querylogLabelValuesDropped = newDesc(counterMetric,
+		prometheus.BuildFQName(namespace, "querylog", "label_values_dropped_total"),
+		"Label values folded into \"other\" by the label limits.",
+		[]string{"label"},
+	)
+)
+
+type QueryLogEntry struct {
+	Cached      bool      `json:"cached"`
+	Client      string    `json:"client"`
+	ClientProto string    `json:"client_proto"`
+	ECS         string    `json:"ecs"`
+	ElapsedMs   string    `json:"elapsedMs"`
+	Reason      string    `json:"reason"`
+	Status      string    `json:"status"`
+	Time        time.Time `json:"time"`
+	Upstream    string    `json:"upstream"`
+	Question    struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"question"`
+}
+
+type QueryLogResponse struct {
+	Data []QueryLogEntry `json:"data"`
+}
+
+// histogram accumulates observations for a const histogram.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) metric(desc *prometheus.Desc, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.buckets))
+	for i, b := range h.buckets {
+		buckets[b] = h.counts[i]
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets, labels...)
+}
+
+// otherLabel replaces the label values dropped by a label limit.
+const otherLabel = "other"
+
+// labelLimit caps the distinct values of a label per update. The values with
+// the most queries of the update (ties by name) keep their label, the rest
+// is folded into otherLabel so an update adds a bounded number of series.
+type labelLimit struct {
+	dropped uint64
+}
+
+func newLabelLimit() *labelLimit {
+	return &labelLimit{}
+}
+
+// apply returns the label value each counted value is exported as.
+func (l *labelLimit) apply(counts map[string]int, max int) map[string]string {
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	labels := make(map[string]string, len(values))
+	for i, v := range values {
+		if max <= 0 || i < max {
+			labels[v] = v
+		} else {
+			labels[v] = otherLabel
+			l.dropped++
+		}
+	}
+
+	return labels
+}
+
+// QueryLog keeps the state accumulated from the AdGuard query log between
+// scrapes. Only entries newer than the cursor are counted.
+type QueryLog struct {
+	Limit              int
+	Buckets            []float64
+	UpstreamHistograms bool
+
+	// caps on distinct label values, 0 means unlimited
+	MaxDomains, MaxClients, MaxUpstreams int
+
+	// entries matching these patterns aren't counted at all
+	IgnoreClients, IgnoreDomains []string
+
+	// DomainLabel maps domains to label values, see domainLabeler.
+	DomainLabel func(string) string
+
+	// Window is the time active clients and unique domains are counted
+	// over, exactly up to DistinctLimit values and estimated beyond.
+	Window        time.Duration
+	DistinctLimit int
+
+	// StateFile persists the cursor and counters across restarts when set.
+	StateFile string
+
+	mu               sync.Mutex
+	cursor           time.Time
+	restored         bool
+	duration         *histogram
+	upstreamDuration map[string]*histogram
+	domainQueries    map[string]uint64
+	clientQueries    map[string]uint64
+	protocolQueries  map[string]uint64
+	ecsQueries       uint64
+	queryErrors      uint64
+	ignoredEntries   uint64
+	domainLimit      *labelLimit
+	clientLimit      *labelLimit
+	upstreamLimit    *labelLimit
+	activeClients    *distinctWindow
+	uniqueDomains    *distinctWindow
+}
+
+func NewQueryLog(limit int, buckets []float64) *QueryLog {
+	q := &QueryLog{
+		Limit:       limit,
+		Buckets:     buckets,
+		DomainLabel: exactDomain,
+	}
+	q.reset()
+	return q
+}
+
+func (q *QueryLog) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queryDuration
+	ch <- querylogDomainQueries
+	ch <- querylogClientQueries
+	ch <- ecsQueries
+	ch <- dnsQueriesByProtocol
+	ch <- dnsQueryErrors
+	ch <- querylogIgnoredEntries
+	ch <- activeClients
+	ch <- activeClientsEstimated
+	ch <- uniqueDomains
+	ch <- uniqueDomainsEstimated
+	ch <- querylogLabelValuesDropped
+	if q.UpstreamHistograms {
+		ch <- upstreamQueryDuration
+	}
+}
+
+// Update counts the entries newer than the cursor. Entries are expected
+// newest first, as returned by AdGuard. The first update only positions the
+// cursor so the existing log isn't counted.
+func (q *QueryLog) Update(entries []QueryLogEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	// a log older than the restored cursor means AdGuard was reset or
+	// replaced, the saved counters don't apply anymore
+	if q.restored {
+		q.restored = false
+		if entries[0].Time.Before(q.cursor) {
+			slog.Warn("Query log is older than the saved cursor, starting fresh")
+			q.reset()
+		}
+	}
+
+	if q.activeClients == nil {
+		q.activeClients = newDistinctWindow(q.Window, q.DistinctLimit)
+		q.uniqueDomains = newDistinctWindow(q.Window, q.DistinctLimit)
+	}
+
+	// nothing is counted on the first update, but the existing log still
+	// fills the distinct windows
+	if q.cursor.IsZero() {
+		for _, entry := range entries {
+			if !q.ignored(entry) {
+				q.addDistinct(entry)
+			}
+		}
+		q.cursor = entries[0].Time
+		return
+	}
+
+	var fresh []QueryLogEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Time.After(q.cursor) {
+			continue
+		}
+		if q.ignored(entries[i]) {
+			q.ignoredEntries++
+			continue
+		}
+		fresh = append(fresh, entries[i])
+	}
+
+	// limits are applied on the counts of this cycle, before any series
+	// is created
+	domains, clients, upstreams := map[string]int{}, map[string]int{}, map[string]int{}
+	for _, entry := range fresh {
+		domains[q.DomainLabel(entry.Question.Name)]++
+		clients[entry.Client]++
+		if q.UpstreamHistograms && !entry.Cached && entry.Upstream != "" {
+			upstreams[entry.Upstream]++
+		}
+	}
+	domainLabels := q.domainLimit.apply(domains, q.MaxDomains)
+	clientLabels := q.clientLimit.apply(clients, q.MaxClients)
+	upstreamLabels := q.upstreamLimit.apply(upstreams, q.MaxUpstreams)
+
+	for _, entry := range fresh {
+		q.domainQueries[domainLabels[q.DomainLabel(entry.Question.Name)]]++
+		q.clientQueries[clientLabels[entry.Client]]++
+		q.addDistinct(entry)
+		q.protocolQueries[protocolLabel(entry.ClientProto)]++
+		if entry.ECS != "" {
+			q.ecsQueries++
+		}
+		if entry.Status == "SERVFAIL" {
+			q.queryErrors++
+		}
+
+		elapsed, err := strconv.ParseFloat(entry.ElapsedMs, 64)
+		if err != nil {
+			continue
+		}
+		seconds := elapsed / 1000
+
+		q.duration.observe(seconds)
+
+		// cached answers didn't go to an upstream
+		if q.UpstreamHistograms && !entry.Cached && entry.Upstream != "" {
+			address := upstreamLabels[entry.Upstream]
+			h, ok := q.upstreamDuration[address]
+			if !ok {
+				h = newHistogram(q.Buckets)
+				q.upstreamDuration[address] = h
+			}
+			h.observe(seconds)
+		}
+	}
+
+	if entries[0].Time.After(q.cursor) {
+		q.cursor = entries[0].Time
+	}
+}
+
+func (q *QueryLog) addDistinct(entry QueryLogEntry) {
+	if entry.Time.Before(time.Now().Add(-q.Window)) {
+		return
+	}
+
+	q.activeClients.add(entry.Client, entry.Time)
+	q.uniqueDomains.add(q.DomainLabel(entry.Question.Name), entry.Time)
+}
+
+// ignored reports whether the entry matches the ignore lists, domains are
+// matched case-insensitively.
+func (q *QueryLog) ignored(entry QueryLogEntry) bool {
+	return matchClient(q.IgnoreClients, entry.Client) || matchDomain(q.IgnoreDomains, entry.Question.Name)
+}
+
+// protocolLabel normalizes a client protocol, the query log
+// reports plain DNS as an empty client_proto.
+func protocolLabel(protocol string) string {
+	switch protocol = strings.ToLower(protocol); protocol {
+	case "", "dns", "udp", "tcp":
+		return "plain"
+	default:
+		return protocol
+	}
+}
+
+// matchClient tells whether a client matches one of the patterns.
+func matchClient(patterns []string, client string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, client); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchDomain tells whether a domain matches one of the patterns, ignoring
+// case and the trailing dot.
+func matchDomain(patterns []string, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), domain); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q *QueryLog) reset() {
+	q.cursor = time.Time{}
+	q.duration = newHistogram(q.Buckets)
+	q.upstreamDuration = map[string]*histogram{}
+	q.domainQueries = map[string]uint64{}
+	q.clientQueries = map[string]uint64{}
+	q.protocolQueries = map[string]uint64{}
+	q.ecsQueries = 0
+	q.queryErrors = 0
+	q.ignoredEntries = 0
+	q.domainLimit = newLabelLimit()
+	q.clientLimit = newLabelLimit()
+	q.upstreamLimit = newLabelLimit()
+	q.activeClients = nil
+	q.uniqueDomains = nil
+}
+
+func (q *QueryLog) Collect(ch chan<- prometheus.Metric) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch <- q.duration.metric(queryDuration)
+
+	for domain, v := range q.domainQueries {
+		ch <- prometheus.MustNewConstMetric(
+			querylogDomainQueries, prometheus.CounterValue, float64(v), domain,
+		)
+	}
+	for client, v := range q.clientQueries {
+		ch <- prometheus.MustNewConstMetric(
+			querylogClientQueries, prometheus.CounterValue, float64(v), client,
+		)
+	}
+
+	for protocol, v := range q.protocolQueries {
+		ch <- prometheus.MustNewConstMetric(
+			dnsQueriesByProtocol, prometheus.CounterValue, float64(v), protocol,
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		ecsQueries, prometheus.CounterValue, float64(q.ecsQueries),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		dnsQueryErrors, prometheus.CounterValue, float64(q.queryErrors),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		querylogIgnoredEntries, prometheus.CounterValue, float64(q.ignoredEntries),
+	)
+
+	if q.activeClients != nil {
+		now := time.Now()
+		for _, distinct := range []struct {
+			window          *distinctWindow
+			desc, estimated *prometheus.Desc
+		}{
+			{q.activeClients, activeClients, activeClientsEstimated},
+			{q.uniqueDomains, uniqueDomains, uniqueDomainsEstimated},
+		} {
+			count, estimated := distinct.window.count(now)
+			ch <- prometheus.MustNewConstMetric(
+				distinct.desc, prometheus.GaugeValue, count,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				distinct.estimated, prometheus.GaugeValue, boolToFloat(estimated),
+			)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		querylogLabelValuesDropped, prometheus.CounterValue, float64(q.domainLimit.dropped), "domain",
+	)
+	ch <- prometheus.MustNewConstMetric(
+		querylogLabelValuesDropped, prometheus.CounterValue, float64(q.clientLimit.dropped), "client",
+	)
+
+	if q.UpstreamHistograms {
+		for address, h := range q.upstreamDuration {
+			ch <- h.metric(upstreamQueryDuration, address)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			querylogLabelValuesDropped, prometheus.CounterValue, float64(q.upstreamLimit.dropped), "address",
+		)
+	}
+}
+
+func (e *Exporter) CollectFromQueryLog(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var res QueryLogResponse
+	if err := e.get(ctx, fmt.Sprintf("/control/querylog?limit=%d", e.QueryLog.Limit), &res); err != nil {
+		return err
+	}
+
+	e.QueryLog.Update(res.Data)
+	e.QueryLog.Collect(ch)
+
+	if e.QueryLog.StateFile != "" {
+		if err := e.QueryLog.SaveState(e.QueryLog.StateFile, e.Endpoint); err != nil {
+			slog.Error(fmt.Sprintf("Unable to save state: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// parseBuckets parses a comma separated list of increasing bucket bounds.
+func parseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+
+	if !sort.Float64sAreSorted(buckets) {
+		return nil, fmt.Errorf("buckets must be in increasing order")
+	}
+
+	return buckets, nil
+}
+
+// validatePatterns checks the glob syntax of ignore list patterns.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"os"
+)
+
+var querylogSizeBytes = newDesc(gaugeMetric,
+	prometheus.BuildFQName(namespace, "querylog", "size_bytes"),
+	"Size of the query log on disk, including the rotated file (in bytes).",
+	nil,
+)
+
+// collectQueryLogSize reports the size of AdGuard's query log file. The API
+// doesn't expose it, so it's only available when the exporter can read
+// AdGuard's data directory. Nothing is exported when the file is missing.
+func (e *Exporter) collectQueryLogSize(ch chan<- prometheus.Metric) {
+	var size int64
+	found := false
+	// AdGuard rotates querylog.json into querylog.json.1
+	for _, name := range []string{e.QueryLogFile, e.QueryLogFile + ".1"} {
+		info, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("Unable to stat query log: %v", err))
+			return
+		}
+		size += info.Size()
+		found = true
+	}
+	if !found {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		querylogSizeBytes, prometheus.GaugeValue, float64(size),
+	)
+}
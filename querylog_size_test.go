@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueryLogSize(t *testing.T) {
+	dir := t.TempDir()
+	e := NewExporter("", "", "")
+	e.QueryLogFile = filepath.Join(dir, "querylog.json")
+	size := collectorFunc(e.collectQueryLogSize)
+
+	if n := testutil.CollectAndCount(size); n != 0 {
+		t.Errorf("without a query log: got %d metrics, want none", n)
+	}
+
+	write := func(name string, n int) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("x", n)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("querylog.json", 300)
+	if got := testutil.ToFloat64(size); got != 300 {
+		t.Errorf("got %v, want 300", got)
+	}
+
+	// the rotated file counts too
+	write("querylog.json.1", 1000)
+	if got := testutil.ToFloat64(size); got != 1300 {
+		t.Errorf("with the rotated file: got %v, want 1300", got)
+	}
+}
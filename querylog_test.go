@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryEntry returns a query log entry of client asking for domain.
+func queryEntry(at time.Time, client, domain string) QueryLogEntry {
+	entry := QueryLogEntry{Client: client, Time: at, ElapsedMs: "1.5", Status: "NOERROR"}
+	entry.Question.Name = domain
+	return entry
+}
+
+// queries returns entries newest first, as AdGuard does, one per domain
+// given, the first one the newest.
+func queries(start time.Time, client string, domains ...string) []QueryLogEntry {
+	entries := make([]QueryLogEntry, len(domains))
+	for i, domain := range domains {
+		entries[i] = queryEntry(start.Add(-time.Duration(i)*time.Second), client, domain)
+	}
+	return entries
+}
+
+func TestQueryLogLabelLimit(t *testing.T) {
+	q := NewQueryLog(1000, []float64{0.01})
+	q.MaxDomains = 2
+	now := time.Now()
+	q.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+
+	// c.example and b.example tie, b.example wins by name
+	q.Update(queries(now.Add(-30*time.Minute), "10.0.0.1",
+		"a.example", "a.example", "a.example", "c.example", "b.example", "d.example"))
+	err := testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_querylog_domain_queries_total DNS queries per domain from the query log.
+# TYPE adguardhome_querylog_domain_queries_total counter
+adguardhome_querylog_domain_queries_total{domain="a.example"} 3
+adguardhome_querylog_domain_queries_total{domain="b.example"} 1
+adguardhome_querylog_domain_queries_total{domain="other"} 2
+`), "adguardhome_querylog_domain_queries_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the top values are picked again for every update
+	q.Update(queries(now, "10.0.0.1", "d.example", "d.example", "e.example", "a.example"))
+	err = testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_querylog_domain_queries_total DNS queries per domain from the query log.
+# TYPE adguardhome_querylog_domain_queries_total counter
+adguardhome_querylog_domain_queries_total{domain="a.example"} 4
+adguardhome_querylog_domain_queries_total{domain="b.example"} 1
+adguardhome_querylog_domain_queries_total{domain="d.example"} 2
+adguardhome_querylog_domain_queries_total{domain="other"} 3
+# HELP adguardhome_querylog_label_values_dropped_total Label values folded into "other" by the label limits.
+# TYPE adguardhome_querylog_label_values_dropped_total counter
+adguardhome_querylog_label_values_dropped_total{label="client"} 0
+adguardhome_querylog_label_values_dropped_total{label="domain"} 3
+`), "adguardhome_querylog_domain_queries_total", "adguardhome_querylog_label_values_dropped_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLabelLimitTies(t *testing.T) {
+	counts := map[string]int{"d": 1, "c": 1, "b": 1, "a": 1, "e": 2}
+	for range 10 {
+		l := newLabelLimit()
+		labels := l.apply(counts, 3)
+		for v, want := range map[string]string{"e": "e", "a": "a", "b": "b", "c": otherLabel, "d": otherLabel} {
+			if labels[v] != want {
+				t.Fatalf("%v: got label %q, want %q", v, labels[v], want)
+			}
+		}
+		if l.dropped != 2 {
+			t.Fatalf("got %d dropped, want 2", l.dropped)
+		}
+	}
+
+	l := newLabelLimit()
+	if labels := l.apply(counts, 0); len(labels) != 5 || l.dropped != 0 {
+		t.Errorf("unlimited: got %v with %d dropped", labels, l.dropped)
+	}
+}
+
+func TestQueryLogUpstreamHistograms(t *testing.T) {
+	q := NewQueryLog(1000, []float64{0.001, 0.01})
+	q.UpstreamHistograms = true
+	now := time.Now()
+	q.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+
+	entry := func(age time.Duration, upstream, elapsedMs string, cached bool) QueryLogEntry {
+		e := queryEntry(now.Add(-age), "10.0.0.1", "example.org")
+		e.Upstream, e.ElapsedMs, e.Cached = upstream, elapsedMs, cached
+		return e
+	}
+	q.Update([]QueryLogEntry{
+		entry(1*time.Second, "tls://1.1.1.1:853", "0.5", false),
+		entry(2*time.Second, "tls://1.1.1.1:853", "5", false),
+		entry(3*time.Second, "8.8.8.8:53", "20", false),
+		// answered from cache, no upstream was asked
+		entry(4*time.Second, "tls://1.1.1.1:853", "0.1", true),
+		entry(5*time.Second, "", "0.2", false),
+	})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(q)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]uint64{}
+	for _, family := range families {
+		switch family.GetName() {
+		case "adguardhome_query_duration_seconds":
+			if count := family.GetMetric()[0].GetHistogram().GetSampleCount(); count != 5 {
+				t.Errorf("got %d queries in the overall histogram, want all 5", count)
+			}
+		case "adguardhome_upstream_query_duration_seconds":
+			for _, m := range family.GetMetric() {
+				h := m.GetHistogram()
+				counts := []uint64{}
+				for _, b := range h.GetBucket() {
+					counts = append(counts, b.GetCumulativeCount())
+				}
+				got[m.GetLabel()[0].GetValue()] = append(counts, h.GetSampleCount())
+			}
+		}
+	}
+	want := map[string][]uint64{
+		"tls://1.1.1.1:853": {1, 2, 2},
+		"8.8.8.8:53":        {0, 0, 1},
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got buckets and counts %v, want %v", got, want)
+	}
+}
+
+func TestQueryLogECS(t *testing.T) {
+	// as in /control/querylog of AdGuard Home v0.107
+	const fixture = `{"data": [
+		{"client": "10.0.0.1", "ecs": "192.0.2.0/24", "elapsedMs": "2.1", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:03.5+02:00", "question": {"name": "a.example", "type": "A"}},
+		{"client": "10.0.0.1", "ecs": "2001:db8::/56", "elapsedMs": "1.2", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:02.5+02:00", "question": {"name": "a.example", "type": "AAAA"}},
+		{"client": "10.0.0.2", "ecs": "", "elapsedMs": "0.4", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:01.5+02:00", "question": {"name": "b.example", "type": "A"}},
+		{"client": "10.0.0.2", "elapsedMs": "0.3", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:00.5+02:00", "question": {"name": "b.example", "type": "A"}}
+	]}`
+	var res QueryLogResponse
+	if err := json.Unmarshal([]byte(fixture), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueryLog(1000, []float64{0.01})
+	q.Update(queries(res.Data[len(res.Data)-1].Time.Add(-time.Hour), "10.0.0.1", "old.example"))
+	q.Update(res.Data)
+	err := testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_dns_queries_with_ecs_total DNS queries from the query log carrying EDNS Client Subnet information.
+# TYPE adguardhome_dns_queries_with_ecs_total counter
+adguardhome_dns_queries_with_ecs_total 2
+`), "adguardhome_dns_queries_with_ecs_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryLogProtocols(t *testing.T) {
+	// as in /control/querylog of AdGuard Home v0.107, plain DNS has an
+	// empty client_proto
+	const fixture = `{"data": [
+		{"client": "10.0.0.1", "client_proto": "doh", "elapsedMs": "2.1", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:04.5+02:00", "question": {"name": "a.example", "type": "A"}},
+		{"client": "10.0.0.1", "client_proto": "doh", "elapsedMs": "1.2", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:03.5+02:00", "question": {"name": "a.example", "type": "AAAA"}},
+		{"client": "10.0.0.2", "client_proto": "dot", "elapsedMs": "0.9", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:02.5+02:00", "question": {"name": "b.example", "type": "A"}},
+		{"client": "10.0.0.3", "client_proto": "", "elapsedMs": "0.4", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:01.5+02:00", "question": {"name": "c.example", "type": "A"}},
+		{"client": "10.0.0.3", "client_proto": "", "elapsedMs": "0.3", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:00.5+02:00", "question": {"name": "c.example", "type": "A"}}
+	]}`
+	var res QueryLogResponse
+	if err := json.Unmarshal([]byte(fixture), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueryLog(1000, []float64{0.01})
+	q.Update(queries(res.Data[len(res.Data)-1].Time.Add(-time.Hour), "10.0.0.1", "old.example"))
+	q.Update(res.Data)
+	err := testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_dns_queries_by_protocol_total DNS queries from the query log by client protocol.
+# TYPE adguardhome_dns_queries_by_protocol_total counter
+adguardhome_dns_queries_by_protocol_total{protocol="doh"} 2
+adguardhome_dns_queries_by_protocol_total{protocol="dot"} 1
+adguardhome_dns_queries_by_protocol_total{protocol="plain"} 2
+`), "adguardhome_dns_queries_by_protocol_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryLogErrors(t *testing.T) {
+	// as in /control/querylog of AdGuard Home v0.107
+	const fixture = `{"data": [
+		{"client": "10.0.0.1", "elapsedMs": "5012.3", "status": "SERVFAIL",
+		 "time": "2026-10-16T08:00:03.5+02:00", "question": {"name": "a.example", "type": "A"}},
+		{"client": "10.0.0.1", "elapsedMs": "0.8", "status": "NXDOMAIN",
+		 "time": "2026-10-16T08:00:02.5+02:00", "question": {"name": "nope.example", "type": "A"}},
+		{"client": "10.0.0.2", "elapsedMs": "5003.9", "status": "SERVFAIL",
+		 "time": "2026-10-16T08:00:01.5+02:00", "question": {"name": "b.example", "type": "AAAA"}},
+		{"client": "10.0.0.2", "elapsedMs": "0.3", "status": "NOERROR",
+		 "time": "2026-10-16T08:00:00.5+02:00", "question": {"name": "b.example", "type": "A"}}
+	]}`
+	var res QueryLogResponse
+	if err := json.Unmarshal([]byte(fixture), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueryLog(1000, []float64{0.01})
+	q.Update(queries(res.Data[len(res.Data)-1].Time.Add(-time.Hour), "10.0.0.1", "old.example"))
+	q.Update(res.Data)
+	err := testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_dns_query_errors_total DNS queries from the query log answered with SERVFAIL, as when the upstreams failed.
+# TYPE adguardhome_dns_query_errors_total counter
+adguardhome_dns_query_errors_total 2
+`), "adguardhome_dns_query_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// only the new entries count
+	entry := queryEntry(res.Data[0].Time.Add(time.Second), "10.0.0.1", "a.example")
+	entry.Status = "SERVFAIL"
+	q.Update(append([]QueryLogEntry{entry}, res.Data...))
+	if got := testutil.ToFloat64(collectorOf(q, "adguardhome_dns_query_errors_total")); got != 3 {
+		t.Errorf("got %v errors, want 3", got)
+	}
+}
+
+func TestQueryLogIgnore(t *testing.T) {
+	q := NewQueryLog(1000, []float64{0.01})
+	q.IgnoreClients = []string{"10.0.0.9", "10.1.*"}
+	q.IgnoreDomains = []string{"example.org", "*.arpa"}
+	q.Window = time.Hour
+	now := time.Now()
+	q.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+
+	entries := queries(now, "10.0.0.1", "a.example", "Example.ORG.", "4.3.2.1.in-addr.arpa", "b.example")
+	entries = append(entries, queries(now.Add(-time.Minute), "10.0.0.9", "a.example")...)
+	entries = append(entries, queries(now.Add(-2*time.Minute), "10.1.2.3", "c.example")...)
+	q.Update(entries)
+
+	if got, want := q.domainQueries, map[string]uint64{"a.example": 1, "b.example": 1}; !maps.Equal(got, want) {
+		t.Errorf("got domain counts %v, want %v", got, want)
+	}
+	if got, want := q.clientQueries, map[string]uint64{"10.0.0.1": 2}; !maps.Equal(got, want) {
+		t.Errorf("got client counts %v, want %v", got, want)
+	}
+	if q.duration.count != 2 {
+		t.Errorf("got %d queries in the duration histogram, want 2", q.duration.count)
+	}
+	err := testutil.CollectAndCompare(q, strings.NewReader(`
+# HELP adguardhome_active_clients Distinct clients in the query log window.
+# TYPE adguardhome_active_clients gauge
+adguardhome_active_clients 1
+# HELP adguardhome_querylog_ignored_entries_total Query log entries dropped by the ignore lists.
+# TYPE adguardhome_querylog_ignored_entries_total counter
+adguardhome_querylog_ignored_entries_total 4
+# HELP adguardhome_unique_domains Distinct domains in the query log window.
+# TYPE adguardhome_unique_domains gauge
+adguardhome_unique_domains 2
+`), "adguardhome_active_clients", "adguardhome_querylog_ignored_entries_total", "adguardhome_unique_domains")
+	if err != nil {
+		t.Error(err)
+	}
+}
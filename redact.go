@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const redacted = "<redacted>"
+
+// secretFlags are the flags whose values are never shown.
+var secretFlags = map[string]bool{
+	"password":                     true,
+	"token":                        true,
+	"metrics-token":                true,
+	"header":                       true,
+	"proxy-auth":                   true,
+	"target":                       true,
+	"probe.auth-module":            true,
+	"web.basic-auth-password-hash": true,
+}
+
+// redactor masks secret values wherever they could be shown: in the logs
+// and on the debug status page.
+type redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+var secrets = &redactor{}
+
+// Add registers a secret, empty values and secrets already known are
+// ignored, as discovery adds the same ones on every refresh.
+func (r *redactor) Add(secret string) {
+	if secret == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !slices.Contains(r.secrets, secret) {
+		r.secrets = append(r.secrets, secret)
+	}
+}
+
+// Redact replaces every secret in s.
+func (r *redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// redactFlag returns the value of a flag to show, masked for secret flags.
+func redactFlag(name, value string) string {
+	if secretFlags[name] && value != "" {
+		return redacted
+	}
+	return secrets.Redact(value)
+}
+
+// redactingWriter redacts secrets from the log lines written through it. It
+// is the output of the log package and so of the default slog handler.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write([]byte(secrets.Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestRedactor(t *testing.T) {
+	r := &redactor{}
+	r.Add("")
+	for range 3 {
+		// discovery adds the secrets of its targets on every refresh
+		r.Add("s3cret")
+	}
+	r.Add("hunter2")
+
+	if len(r.secrets) != 2 {
+		t.Errorf("got %d secrets, want 2: %q", len(r.secrets), r.secrets)
+	}
+	if got, want := r.Redact("login s3cret, then hunter2"), "login <redacted>, then <redacted>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRedactFlag(t *testing.T) {
+	if got := redactFlag("password", "s3cret"); got != redacted {
+		t.Errorf("secret flag: got %q", got)
+	}
+	if got := redactFlag("password", ""); got != "" {
+		t.Errorf("empty secret flag: got %q", got)
+	}
+	if got := redactFlag("endpoint", "adguard:3000"); got != "adguard:3000" {
+		t.Errorf("other flag: got %q", got)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	configLastReloadSuccessful = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "exporter", "config_last_reload_successful"),
+		"Whether the last configuration reload succeeded (1) or not (0).",
+		nil,
+	)
+	configLastReloadSuccess = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "exporter", "config_last_reload_success_timestamp_seconds"),
+		"When the configuration was last loaded successfully (unix time).",
+		nil,
+	)
+)
+
+// reloadFlags are the flags a reload applies from -config.file, the others
+// need a restart.
+var reloadFlags = []string{
+	"endpoint", "scheme", "target",
+	"username", "password", "token",
+	"username-file", "password-file", "token-file",
+	"collector.stats", "collector.status", "collector.dhcp", "collector.clients", "collector.dns_info", "collector.filtering",
+}
+
+// flagValues returns the current values of the named flags.
+func flagValues(fs *flag.FlagSet, names []string) map[string]string {
+	values := map[string]string{}
+	for _, name := range names {
+		values[name] = fs.Lookup(name).Value.String()
+	}
+	return values
+}
+
+// Reloader re-reads the configuration that can change at runtime: the
+// targets, credentials and collector switches of the config file, the
+// credential files, the Basic auth users of the web configuration file and
+// the TLS certificate. Everything else needs a restart.
+type Reloader struct {
+	// Targets holds the targets. The configured ones are replaced on a
+	// reload, Build makes the exporters of new and changed ones.
+	Targets *TargetSet
+	Build   func(*target) (*Exporter, error)
+
+	// Flags holds the reloadFlags of FlagSet as set by the command line and
+	// env, TargetURLs the -target values, ConfigFile is applied on top
+	// except for the Explicit flags.
+	FlagSet    *flag.FlagSet
+	Flags      map[string]string
+	TargetURLs []string
+	ConfigFile string
+	Explicit   map[string]bool
+
+	WebConfigFile string
+	// Auth is nil when the Basic auth users don't come from WebConfigFile.
+	Auth *ReloadableAuth
+
+	TLSCertFile, TLSKeyFile string
+
+	// Descs are the descs the metrics are exposed with, nil for the
+	// default ones.
+	Descs *descSet
+
+	mu   sync.Mutex
+	cert atomic.Pointer[tls.Certificate]
+
+	statusMu    sync.Mutex
+	lastOK      bool
+	lastSuccess time.Time
+}
+
+func (r *Reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.Descs.describe(ch, func(ch chan<- *prometheus.Desc) {
+		ch <- configLastReloadSuccessful
+		ch <- configLastReloadSuccess
+	})
+}
+
+func (r *Reloader) Collect(ch chan<- prometheus.Metric) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	r.Descs.collect(ch, func(ch chan<- prometheus.Metric) {
+		ch <- prometheus.MustNewConstMetric(
+			configLastReloadSuccessful, prometheus.GaugeValue, boolToFloat(r.lastOK),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			configLastReloadSuccess, prometheus.GaugeValue, float64(r.lastSuccess.Unix()),
+		)
+	})
+}
+
+// Record sets the outcome of a load of the configuration, the one at
+// startup included.
+func (r *Reloader) Record(err error, now time.Time) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	r.lastOK = err == nil
+	if err == nil {
+		r.lastSuccess = now
+	}
+}
+
+// SetCertificate sets the certificate GetCertificate serves.
+func (r *Reloader) SetCertificate(cert *tls.Certificate) {
+	r.cert.Store(cert)
+}
+
+// GetCertificate is a tls.Config callback returning the current certificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Reload reads and validates everything before applying any of it, so a bad
+// configuration leaves the current one active.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.reload()
+	r.Record(err, time.Now())
+	if err != nil {
+		slog.Error(fmt.Sprintf("Reload failed: %v", err))
+		return err
+	}
+
+	slog.Info("Configuration reloaded")
+	return nil
+}
+
+func (r *Reloader) reload() error {
+	values := maps.Clone(r.Flags)
+	urls := slices.Clone(r.TargetURLs)
+	fileTargets := false
+	if r.ConfigFile != "" {
+		err := readConfigFile(r.FlagSet, r.ConfigFile, r.Explicit, func(f *flag.Flag, value string) error {
+			if collector, negated, ok := collectorFlag(f.Name); ok {
+				on, err := strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+				name := "collector." + collector
+				if !slices.Contains(reloadFlags, name) {
+					return nil
+				}
+				values[name] = strconv.FormatBool(on != negated)
+				return nil
+			}
+			if !slices.Contains(reloadFlags, f.Name) {
+				return nil
+			}
+			// the targets of the file replace the others, one by one
+			if f.Name == "target" {
+				if !fileTargets {
+					urls, fileTargets = nil, true
+				}
+				urls = append(urls, value)
+				return nil
+			}
+			values[f.Name] = value
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid -config.file: %w", err)
+		}
+	}
+
+	username, password, token := values["username"], values["password"], values["token"]
+	err := readSecretFiles([]secretFile{
+		{values["username-file"], &username},
+		{values["password-file"], &password},
+		{values["token-file"], &token},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read secret file: %w", err)
+	}
+	enabled := func(name string) bool {
+		b, _ := strconv.ParseBool(values[name])
+		return b
+	}
+
+	var auth *BasicAuth
+	var cert *tls.Certificate
+	if r.WebConfigFile != "" {
+		webCfg, err := loadWebConfig(r.WebConfigFile)
+		if err != nil {
+			return fmt.Errorf("invalid -web.config.file: %w", err)
+		}
+		auth = webCfg.basicAuth()
+		if cert, err = webCfg.certificate(); err != nil {
+			return fmt.Errorf("invalid TLS keypair: %w", err)
+		}
+	} else if r.TLSCertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.TLSCertFile, r.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid TLS keypair: %w", err)
+		}
+		cert = &c
+	}
+	if (cert != nil) != (r.cert.Load() != nil) {
+		return errors.New("TLS can't be enabled or disabled without a restart")
+	}
+
+	targets, err := staticTargets(urls, values)
+	if err != nil {
+		return err
+	}
+	// the last step that can fail, the targets stay as they are then
+	if err := r.Targets.Replace(targets, r.Build); err != nil {
+		return fmt.Errorf("invalid targets: %w", err)
+	}
+
+	secrets.Add(password)
+	secrets.Add(token)
+	for _, e := range r.Targets.Exporters() {
+		if !e.ownCredentials {
+			e.SetCredentials(username, password, token)
+		}
+		e.setCollectors(map[string]bool{
+			"stats":     enabled("collector.stats"),
+			"status":    enabled("collector.status"),
+			"dhcp":      enabled("collector.dhcp"),
+			"clients":   enabled("collector.clients"),
+			"dns_info":  enabled("collector.dns_info"),
+			"filtering": enabled("collector.filtering"),
+		})
+	}
+	if r.Auth != nil {
+		r.Auth.Store(auth)
+	}
+	if cert != nil {
+		r.cert.Store(cert)
+	}
+
+	return nil
+}
+
+// ReloadHandler reloads on POST, like the Prometheus /-/reload endpoint.
+func ReloadHandler(r *Reloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.Reload(); err != nil {
+			http.Error(w, fmt.Sprintf("Reload failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, "Configuration reloaded")
+	})
+}
+
+// setCollectors switches the optional API collectors. It waits for running
+// collections, so none sees a half applied change. The state of a collector
+// switched off is dropped.
+func (e *Exporter) setCollectors(enabled map[string]bool) {
+	e.collectorsMu.Lock()
+	defer e.collectorsMu.Unlock()
+
+	switch {
+	case enabled["status"] && e.Status == nil:
+		e.Status = &Status{}
+	case !enabled["status"]:
+		e.Status = nil
+	}
+	e.Stats = enabled["stats"]
+	e.DHCP = enabled["dhcp"]
+	e.Clients = enabled["clients"]
+	e.DNSInfo = enabled["dns_info"]
+	e.Filtering = enabled["filtering"]
+}
+
+// staticTargets returns the targets of the -target urls, or the one of
+// endpoint and scheme without any. There are none with neither, as when
+// only discovery is used.
+func staticTargets(urls []string, values map[string]string) ([]*target, error) {
+	if len(urls) == 0 {
+		endpoint, scheme := normalizeEndpoint(values["endpoint"], values["scheme"])
+		if endpoint == "" {
+			return nil, nil
+		}
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("invalid scheme %q: must be http or https", scheme)
+		}
+		return []*target{{Scheme: scheme, Endpoint: endpoint}}, nil
+	}
+
+	var targets []*target
+	for i, s := range urls {
+		t, err := parseTarget(s)
+		if err != nil {
+			// the URL may hold credentials, name the target by position
+			return nil, fmt.Errorf("invalid target #%d: %w", i+1, err)
+		}
+		secrets.Add(t.Password)
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
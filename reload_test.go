@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestReloader returns a reloader of the targets of s with the config
+// file path, its flags set like the command line of the exporter would.
+func newTestReloader(t *testing.T, s *TargetSet, path string) *Reloader {
+	t.Helper()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("endpoint", "", "")
+	fs.String("scheme", "http", "")
+	fs.Var(&stringsFlag{}, "target", "")
+	for _, name := range []string{"username", "password", "token", "username-file", "password-file", "token-file"} {
+		fs.String(name, "", "")
+	}
+	collectorFlags(fs)
+
+	return &Reloader{
+		Targets:    s,
+		Build:      func(t *target) (*Exporter, error) { return s.Exporter.forTarget(t), nil },
+		FlagSet:    fs,
+		Flags:      flagValues(fs, reloadFlags),
+		ConfigFile: path,
+		Explicit:   map[string]bool{},
+	}
+}
+
+// reloadSuccessful returns whether r reports the last reload as successful.
+func reloadSuccessful(t *testing.T, r *Reloader) bool {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(r)
+	return strings.Contains(exposition(t, registry), "adguardhome_exporter_config_last_reload_successful 1")
+}
+
+func basicAuthHeader(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func TestReload(t *testing.T) {
+	one, two, three := newAdGuardStub(t), newAdGuardStub(t), newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	s := &TargetSet{Registerer: registry, TargetLabel: true, Exporter: NewExporter("", "admin", "secretpw")}
+	for _, stub := range []*adguardStub{one, two} {
+		t := &target{Scheme: "http", Endpoint: stub.endpoint()}
+		s.Add(t, s.Exporter.forTarget(t))
+	}
+	r := newTestReloader(t, s, path)
+	kept := s.Exporters()[0]
+
+	write("target: [" + one.URL + ", " + three.URL + "]\nusername: admin\npassword: newsecretpw\ncollector:\n  status: true\n")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{one.endpoint(), three.endpoint()}
+	slices.Sort(want)
+	if got := endpoints(s); !slices.Equal(got, want) {
+		t.Fatalf("got targets %v, want %v", got, want)
+	}
+	if s.Exporters()[0] != kept {
+		t.Error("the unchanged target lost its exporter")
+	}
+
+	metrics := exposition(t, registry)
+	if strings.Contains(metrics, two.endpoint()) {
+		t.Errorf("series of the removed target remain:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, `adguardhome_up{target="`+three.endpoint()+`"} 1`) {
+		t.Errorf("added target not collected:\n%s", metrics)
+	}
+	for _, stub := range []*adguardStub{one, three} {
+		if got, want := stub.authorization(), basicAuthHeader("admin", "newsecretpw"); got != want {
+			t.Errorf("%v: got Authorization %q, want %q", stub.endpoint(), got, want)
+		}
+		if stub.count("/control/status") == 0 {
+			t.Errorf("%v: status collector not switched on", stub.endpoint())
+		}
+	}
+	if !reloadSuccessful(t, r) {
+		t.Error("reload not reported as successful")
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	one := newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	for _, tc := range []struct {
+		name, content, wantErr string
+	}{
+		{"invalid target", "target: [" + one.URL + ", ftp://adguard2]\n", "invalid target #2"},
+		{"duplicate target", "target: [" + one.URL + ", " + one.URL + "]\n", "duplicate target"},
+		{"several targets", "endpoint: adguard1:3000\ntarget: [http://adguard1:3000, http://adguard2:3000]\n", "single target"},
+		{"unknown option", "endpont: adguard1:3000\n", "field endpont not found"},
+		{"invalid yaml", "target: [\n", "invalid -config.file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			// without the target label a single target has to stay one
+			s := &TargetSet{Registerer: registry, Exporter: NewExporter("", "admin", "secretpw")}
+			static := &target{Scheme: "http", Endpoint: one.endpoint()}
+			s.Add(static, s.Exporter.forTarget(static))
+			r := newTestReloader(t, s, path)
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := r.Reload()
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			if got := endpoints(s); !slices.Equal(got, []string{one.endpoint()}) {
+				t.Errorf("got targets %v, want the old one", got)
+			}
+			if reloadSuccessful(t, r) {
+				t.Error("failed reload reported as successful")
+			}
+		})
+	}
+}
+
+func TestReloadWhileScraping(t *testing.T) {
+	one, two := newAdGuardStub(t), newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	registry := prometheus.NewRegistry()
+	s := &TargetSet{Registerer: registry, TargetLabel: true, Exporter: NewExporter("", "", "")}
+	static := &target{Scheme: "http", Endpoint: one.endpoint()}
+	s.Add(static, s.Exporter.forTarget(static))
+	r := newTestReloader(t, s, path)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := registry.Gather(); err != nil {
+					t.Errorf("gathering during a reload: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	configs := []string{
+		"target: [" + one.URL + ", " + two.URL + "]\n",
+		"target: [" + two.URL + "]\ncollector: {stats: false}\n",
+		"target: [" + one.URL + "]\n",
+	}
+	for i := range 30 {
+		if err := os.WriteFile(path, []byte(configs[i%len(configs)]), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	one, two := newAdGuardStub(t), newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("endpoint: " + one.URL + "\n")
+	base := runExporter(t, "-config.file", path, "-web.enable-lifecycle", "-metrics-token", "secrettoken")
+
+	request := func(method, path, authorization string) (int, string) {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, base+path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if status, _ := request(http.MethodGet, "/-/reload", "Bearer secrettoken"); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %d, want 405", status)
+	}
+	if status, _ := request(http.MethodPost, "/-/reload", ""); status != http.StatusUnauthorized {
+		t.Errorf("POST without the token: got %d, want 401", status)
+	}
+
+	write("endpoint: " + two.URL + "\n")
+	if status, body := request(http.MethodPost, "/-/reload", "Bearer secrettoken"); status != http.StatusOK || body != "Configuration reloaded\n" {
+		t.Errorf("POST: got %d %q, want 200", status, body)
+	}
+	request(http.MethodGet, "/metrics", "Bearer secrettoken")
+	if one.count("/control/stats") != 0 || two.count("/control/stats") != 1 {
+		t.Errorf("got %d requests to the old endpoint and %d to the new, want the new one scraped",
+			one.count("/control/stats"), two.count("/control/stats"))
+	}
+
+	// the old configuration stays active
+	write("endpont: " + one.URL + "\n")
+	if status, body := request(http.MethodPost, "/-/reload", "Bearer secrettoken"); status != http.StatusInternalServerError || !strings.Contains(body, "field endpont not found") {
+		t.Errorf("POST with an invalid config: got %d %q, want 500 with the error", status, body)
+	}
+	if status, body := request(http.MethodGet, "/metrics", "Bearer secrettoken"); status != http.StatusOK || !strings.Contains(body, "adguardhome_up 1") {
+		t.Errorf("got %d:\n%s\nwant the old configuration scraped", status, body)
+	}
+	if two.count("/control/stats") != 2 {
+		t.Errorf("got %d requests to the kept endpoint, want 2", two.count("/control/stats"))
+	}
+
+	write("endpoint: " + two.URL + "\n")
+	disabled := runExporter(t, "-config.file", path)
+	res, err := http.Post(disabled+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("without -web.enable-lifecycle: got %d, want 404", res.StatusCode)
+	}
+}
+
+// TestReloadRace switches the status collector while /debug/status and
+// /probe use it, run with -race.
+func TestReloadRace(t *testing.T) {
+	stub := newAdGuardStub(t)
+	path := filepath.Join(t.TempDir(), "config.yml")
+	s, _ := newTestTargetSet()
+	s.Exporter.Status = &Status{}
+	static := &target{Scheme: "http", Endpoint: stub.endpoint()}
+	s.Add(static, s.Exporter.forTarget(static))
+	r := newTestReloader(t, s, path)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	handlers := map[string]http.Handler{
+		"/debug/status":                    DebugStatusHandler(fs, s.Exporters),
+		"/probe?target=" + stub.endpoint(): ProbeHandler(nil, s.Exporters, nil),
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for path, h := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("%v: got %d: %s", path, rec.Code, rec.Body)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := range 50 {
+		status := i%2 == 0
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("target: [%v]\ncollector:\n  status: %v\n", stub.URL, status)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var exporterRequests = newDesc(counterMetric,
+	prometheus.BuildFQName(namespace, "exporter", "requests_total"),
+	"Requests sent to the AdGuard API by endpoint and response code (error without a response).",
+	[]string{"endpoint", "code"},
+)
+
+type requestKey struct {
+	endpoint, code string
+}
+
+// requestCounts counts the requests sent to AdGuard, whether by a scrape,
+// a background collection or a probe.
+type requestCounts struct {
+	mu     sync.Mutex
+	counts map[requestKey]uint64
+}
+
+// record counts a request to path, the code is 0 if it failed without a
+// response.
+func (c *requestCounts) record(path string, code int) {
+	endpoint, _, _ := strings.Cut(strings.TrimPrefix(path, "/control/"), "?")
+	key := requestKey{endpoint: endpoint, code: "error"}
+	if code != 0 {
+		key.code = strconv.Itoa(code)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = map[requestKey]uint64{}
+	}
+	c.counts[key]++
+}
+
+func (c *requestCounts) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, count := range c.counts {
+		ch <- prometheus.MustNewConstMetric(
+			exporterRequests, prometheus.CounterValue, float64(count), key.endpoint, key.code,
+		)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExporterRequests(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "admin", "secretpw")
+
+	var status StatusResponse
+	for range 3 {
+		e.getRaw(t.Context(), "/control/stats")
+	}
+	e.get(t.Context(), "/control/status", &status)
+	stub.fail("/control/status", http.StatusUnauthorized)
+	e.get(t.Context(), "/control/status", &status)
+	e.getRaw(t.Context(), "/control/querylog?limit=1")
+
+	err := testutil.CollectAndCompare(collectorFunc(e.requests.Collect), strings.NewReader(`
+# HELP adguardhome_exporter_requests_total Requests sent to the AdGuard API by endpoint and response code (error without a response).
+# TYPE adguardhome_exporter_requests_total counter
+adguardhome_exporter_requests_total{code="200",endpoint="querylog"} 1
+adguardhome_exporter_requests_total{code="200",endpoint="stats"} 3
+adguardhome_exporter_requests_total{code="200",endpoint="status"} 1
+adguardhome_exporter_requests_total{code="401",endpoint="status"} 1
+`))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// no response at all
+	stub.Close()
+	e.getRaw(t.Context(), "/control/stats")
+	err = testutil.CollectAndCompare(collectorFunc(e.requests.Collect), strings.NewReader(`
+# HELP adguardhome_exporter_requests_total Requests sent to the AdGuard API by endpoint and response code (error without a response).
+# TYPE adguardhome_exporter_requests_total counter
+adguardhome_exporter_requests_total{code="200",endpoint="querylog"} 1
+adguardhome_exporter_requests_total{code="200",endpoint="stats"} 3
+adguardhome_exporter_requests_total{code="200",endpoint="status"} 1
+adguardhome_exporter_requests_total{code="401",endpoint="status"} 1
+adguardhome_exporter_requests_total{code="error",endpoint="stats"} 1
+`))
+	if err != nil {
+		t.Error(err)
+	}
+}
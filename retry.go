@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRetryBackoff caps the exponential backoff between retries.
+	maxRetryBackoff = 10 * time.Second
+
+	// maxRetryAfter is the longest Retry-After waited for without a
+	// deadline, a longer one fails the request.
+	maxRetryAfter = time.Minute
+)
+
+// Retry configures retries of failed API requests. The backoff doubles from
+// Backoff with every attempt, with full jitter a random delay between 0 and
+// the backoff is waited instead, so replicas don't retry in lockstep.
+type Retry struct {
+	Attempts int
+	Backoff  time.Duration
+	Jitter   bool
+}
+
+// delay returns how long to wait before the given retry, counting from 0.
+func (r *Retry) delay(retry int, rnd func(int64) int64) time.Duration {
+	backoff := r.Backoff << retry
+	if backoff > maxRetryBackoff || backoff <= 0 {
+		backoff = maxRetryBackoff
+	}
+	if r.Jitter {
+		backoff = time.Duration(rnd(int64(backoff) + 1))
+	}
+
+	return backoff
+}
+
+// retryable tells whether a failed request may succeed when repeated.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	// the response won't be any smaller the next time
+	var sizeErr *SizeError
+	if errors.As(err, &sizeErr) {
+		return false
+	}
+
+	// the caller gave up, anything else may be a blip
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// withRetry runs fn until it succeeds, fails for good or the attempts run out.
+func (e *Exporter) withRetry(ctx context.Context, fn func() error) error {
+	err := fn()
+	if e.Retry == nil {
+		return err
+	}
+
+	for retry := 0; retry < e.Retry.Attempts && err != nil && retryable(err); retry++ {
+		delay := e.Retry.delay(retry, rand.Int64N)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			// retrying earlier than asked is pointless, so is a retry
+			// the deadline won't see
+			delay = statusErr.RetryAfter
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(maxRetryAfter)
+			}
+			if time.Now().Add(delay).After(deadline) {
+				return err
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		err = fn()
+	}
+
+	return err
+}
+
+// retryAfter returns the delay the Retry-After header of a 429 or 503
+// response asks for, in seconds or as an HTTP date, or 0.
+func retryAfter(response *http.Response, now time.Time) time.Duration {
+	if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	value := strings.TrimSpace(response.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
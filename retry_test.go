@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	r := &Retry{Backoff: 200 * time.Millisecond}
+	for retry, want := range []time.Duration{
+		200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, 1600 * time.Millisecond,
+		3200 * time.Millisecond, 6400 * time.Millisecond, maxRetryBackoff, maxRetryBackoff,
+	} {
+		if got := r.delay(retry, nil); got != want {
+			t.Errorf("retry %d without jitter: got %v, want %v", retry, got, want)
+		}
+	}
+	if got := r.delay(100, nil); got != maxRetryBackoff {
+		t.Errorf("overflowing backoff: got %v, want %v", got, maxRetryBackoff)
+	}
+
+	r.Jitter = true
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for retry := range 8 {
+		backoff := min(r.Backoff<<retry, maxRetryBackoff)
+		seen := map[time.Duration]bool{}
+		for range 100 {
+			got := r.delay(retry, rnd.Int64N)
+			if got < 0 || got > backoff {
+				t.Fatalf("retry %d with jitter: got %v, want between 0 and %v", retry, got, backoff)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 90 {
+			t.Errorf("retry %d with jitter: got %d distinct delays of 100, want them spread", retry, len(seen))
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{errors.New("connection refused"), true},
+		{&SizeError{Path: "/control/querylog", Limit: 1024}, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+	} {
+		if got := retryable(tc.err); got != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	stub := newAdGuardStub(t)
+	failures := 2
+	stub.set("/control/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version": "v0.107.52"}`))
+	}))
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Retry = &Retry{Attempts: 3, Backoff: time.Millisecond}
+
+	var res StatusResponse
+	if err := e.get(t.Context(), "/control/status", &res); err != nil || res.Version != "v0.107.52" {
+		t.Fatalf("got %+v, %v", res, err)
+	}
+	if n := stub.count("/control/status"); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+
+	stub.fail("/control/status", http.StatusUnauthorized)
+	if err := e.get(t.Context(), "/control/status", &res); err == nil {
+		t.Fatal("got no error")
+	}
+	if n := stub.count("/control/status"); n != 4 {
+		t.Errorf("got %d requests, want a 401 not to be retried", n-3)
+	}
+}
+
+func TestWithRetryResponseTooLarge(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "v0.107.52", "dns_addresses": ["192.168.1.2", "192.168.1.3"]}`))
+	}))
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Retry = &Retry{Attempts: 3, Backoff: time.Millisecond}
+	e.MaxResponseBytes = 16
+
+	var res StatusResponse
+	err := e.get(t.Context(), "/control/status", &res)
+	var sizeErr *SizeError
+	if !errors.As(err, &sizeErr) {
+		t.Fatalf("got %v, want a SizeError", err)
+	}
+	if n := stub.count("/control/status"); n != 1 {
+		t.Errorf("got %d requests, want a single attempt", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		status int
+		header string
+		want   time.Duration
+	}{
+		{http.StatusTooManyRequests, "3", 3 * time.Second},
+		{http.StatusServiceUnavailable, " 3 ", 3 * time.Second},
+		{http.StatusTooManyRequests, now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{http.StatusTooManyRequests, now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{http.StatusTooManyRequests, "-3", 0},
+		{http.StatusTooManyRequests, "soon", 0},
+		{http.StatusTooManyRequests, "", 0},
+		{http.StatusInternalServerError, "3", 0},
+	} {
+		res := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		res.Header.Set("Retry-After", tc.header)
+		if got := retryAfter(res, now); got != tc.want {
+			t.Errorf("%d with Retry-After %q: got %v, want %v", tc.status, tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	stub := newAdGuardStub(t)
+	limited := true
+	stub.set("/control/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limited {
+			limited = false
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"version": "v0.107.52"}`))
+	}))
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Retry = &Retry{Attempts: 3, Backoff: time.Millisecond}
+
+	var res StatusResponse
+	start := time.Now()
+	if err := e.get(t.Context(), "/control/status", &res); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the second asked for instead of the backoff", elapsed)
+	}
+	if n := stub.count("/control/status"); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+
+	// a Retry-After past the deadline fails right away
+	stub.set("/control/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	start = time.Now()
+	err := e.get(ctx, "/control/status", &res)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got %v, want the 429", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %v, want right away", elapsed)
+	}
+	if n := stub.count("/control/status"); n != 3 {
+		t.Errorf("got %d requests, want no retry", n-2)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+)
+
+// sdGroup is a target group of the Prometheus http_sd format.
+type sdGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdGroups returns a group per target with its URL, the labels of its
+// discovery file, its source and pod and the auth module it's scraped with
+// as __meta_adguard_auth_module.
+func (s *TargetSet) sdGroups() []sdGroup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]sdGroup, 0, len(s.members))
+	for _, m := range s.members {
+		labels := maps.Clone(m.sdLabels)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels["source"] = m.source
+		if m.pod != "" {
+			labels["pod"] = m.pod
+		}
+		if m.module != "" {
+			labels["__meta_adguard_auth_module"] = m.module
+		}
+		groups = append(groups, sdGroup{
+			Targets: []string{m.exporter.Scheme + "://" + m.exporter.Endpoint},
+			Labels:  labels,
+		})
+	}
+	return groups
+}
+
+// SDHandler serves the current targets in the Prometheus http_sd format, for
+// scraping them through /probe.
+func SDHandler(s *TargetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := json.Marshal(s.sdGroups())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
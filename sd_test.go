@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSDHandler(t *testing.T) {
+	static, discovered, pod := newAdGuardStub(t), newAdGuardStub(t), newAdGuardStub(t)
+	name, module, err := parseAuthModule("home?username=admin&password=secretpw", &http.Transport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestTargetSet()
+	s.Modules = map[string]*probeModule{name: module}
+	staticTarget := &target{Scheme: "http", Endpoint: static.endpoint()}
+	s.Add(staticTarget, s.Exporter.forTarget(staticTarget))
+	h := SDHandler(s)
+
+	get := func() string {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sd", nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("got %d with Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		return rec.Body.String()
+	}
+	// the shape Prometheus expects, compared as JSON
+	equalJSON := func(got, want string) {
+		t.Helper()
+
+		var g, w any
+		if err := json.Unmarshal([]byte(got), &g); err != nil {
+			t.Fatalf("invalid JSON %s: %v", got, err)
+		}
+		json.Unmarshal([]byte(want), &w)
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+	}
+
+	s.Update(t.Context(), "kubernetes:dns/adguard-home", []*target{
+		{Scheme: "http", Endpoint: discovered.endpoint(), Module: "home"},
+		{Scheme: "http", Endpoint: pod.endpoint(), Pod: "adguard-home-0"},
+	})
+	equalJSON(get(), `[
+		{"targets": ["`+static.URL+`"], "labels": {"source": "static"}},
+		{"targets": ["`+discovered.URL+`"], "labels": {"source": "kubernetes:dns/adguard-home", "__meta_adguard_auth_module": "home"}},
+		{"targets": ["`+pod.URL+`"], "labels": {"source": "kubernetes:dns/adguard-home", "pod": "adguard-home-0"}}
+	]`)
+
+	// discovery changes show right away
+	s.Update(t.Context(), "kubernetes:dns/adguard-home", nil)
+	equalJSON(get(), `[{"targets": ["`+static.URL+`"], "labels": {"source": "static"}}]`)
+
+	// the labels of a discovery file are passed on, as they change too
+	path := filepath.Join(t.TempDir(), "targets.json")
+	source := "file:" + path
+	for _, env := range []string{"home", "office"} {
+		content := `[{"targets": ["` + discovered.URL + `"], "labels": {"env": "` + env + `", "__meta_rack": "a"}}]`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		targets, err := readDiscoveryFile(path, "http")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Update(t.Context(), source, targets)
+		equalJSON(get(), `[
+			{"targets": ["`+static.URL+`"], "labels": {"source": "static"}},
+			{"targets": ["`+discovered.URL+`"], "labels": {"source": "`+source+`", "env": "`+env+`", "__meta_rack": "a"}}
+		]`)
+	}
+
+	// never null, Prometheus rejects that
+	if body := get(); strings.TrimSpace(body) == "null" {
+		t.Errorf("got %s", body)
+	}
+}
+
+func TestSDAuth(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-metrics-token", "secrettoken")
+
+	for _, tc := range []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer secrettoken", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, base+"/sd", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("Authorization %q: got %d, want %d", tc.authorization, res.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusOK && !strings.Contains(string(body), `"targets":["`+stub.URL+`"]`) {
+			t.Errorf("got %s, want the configured target", body)
+		}
+	}
+}
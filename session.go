@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "agh_session"
+
+var (
+	authTokenExpiry = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "auth", "token_expiry_seconds"),
+		"Time until the session cookie expires (in seconds).",
+		nil,
+	)
+	authLastLogin = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "auth", "last_login_timestamp_seconds"),
+		"When the last login succeeded (unix time).",
+		nil,
+	)
+)
+
+// Session authenticates with the cookie AdGuard hands out on /control/login
+// instead of sending the credentials with every request. It logs in again
+// when the cookie is rejected.
+type Session struct {
+	mu        sync.Mutex
+	cookie    *http.Cookie
+	expires   time.Time
+	lastLogin time.Time
+}
+
+func (s *Session) Describe(ch chan<- *prometheus.Desc) {
+	ch <- authTokenExpiry
+	ch <- authLastLogin
+}
+
+func (s *Session) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.expires.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			authTokenExpiry, prometheus.GaugeValue, time.Until(s.expires).Seconds(),
+		)
+	}
+	if !s.lastLogin.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			authLastLogin, prometheus.GaugeValue, float64(s.lastLogin.Unix()),
+		)
+	}
+}
+
+// current returns the session cookie, nil before the first login.
+func (s *Session) current() *http.Cookie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cookie
+}
+
+func (s *Session) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cookie = nil
+}
+
+func (e *Exporter) login(ctx context.Context) (*http.Cookie, error) {
+	username, password, _ := e.credentials()
+	body, err := json.Marshal(map[string]string{"name": username, "password": password})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url("/control/login"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	e.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := e.httpClient().Do(req)
+	if err != nil {
+		e.requests.record("/control/login", 0)
+		return nil, err
+	}
+	e.requests.record("/control/login", response.StatusCode)
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &StatusError{Path: "/control/login", StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	for _, cookie := range response.Cookies() {
+		if cookie.Name != sessionCookie {
+			continue
+		}
+
+		now := time.Now()
+		s := e.Session
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cookie = cookie
+		s.lastLogin = now
+		s.expires = cookie.Expires
+		if cookie.MaxAge > 0 {
+			s.expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+		return cookie, nil
+	}
+
+	return nil, fmt.Errorf("/control/login: no %v cookie in the response", sessionCookie)
+}
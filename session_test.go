@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionRelogin(t *testing.T) {
+	stub := newAdGuardStub(t)
+	var mu sync.Mutex
+	session := ""
+	logins := 0
+	stub.set("/control/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var creds struct{ Name, Password string }
+		if json.NewDecoder(r.Body).Decode(&creds) != nil || creds.Name != "admin" || creds.Password != "secretpw" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		logins++
+		session = fmt.Sprintf("session%d", logins)
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session, MaxAge: 3600})
+	}))
+	stub.set("/control/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cookie, err := r.Cookie(sessionCookie); err != nil || cookie.Value != session || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"num_dns_queries": 100, "num_blocked_filtering": 10, "avg_processing_time": 0.01}`))
+	}))
+
+	e := NewExporter(stub.endpoint(), "admin", "secretpw")
+	e.Session = &Session{}
+	collect := func() {
+		t.Helper()
+		if err := e.CollectFromAPI(t.Context(), make(chan prometheus.Metric, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loginCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return logins
+	}
+	lastLogin := func() float64 {
+		return testutil.ToFloat64(collectorOf(e.Session, "adguardhome_auth_last_login_timestamp_seconds"))
+	}
+
+	collect()
+	collect()
+	if n := loginCount(); n != 1 {
+		t.Errorf("got %d logins, want the session reused", n)
+	}
+	if expiry := testutil.ToFloat64(collectorOf(e.Session, "adguardhome_auth_token_expiry_seconds")); math.Abs(expiry-3600) > 5 {
+		t.Errorf("got token expiry %v, want about 3600", expiry)
+	}
+
+	// AdGuard restarted and forgot the session
+	e.Session.mu.Lock()
+	e.Session.lastLogin = e.Session.lastLogin.Add(-time.Hour)
+	e.Session.mu.Unlock()
+	before := lastLogin()
+	mu.Lock()
+	session = "expired"
+	mu.Unlock()
+
+	collect()
+	if n := loginCount(); n != 2 {
+		t.Errorf("got %d logins, want a re-login after the 401", n)
+	}
+	if after := lastLogin(); after < before+3600 || math.Abs(after-float64(time.Now().Unix())) > 5 {
+		t.Errorf("got last login %v after the re-login, was %v", after, before)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var (
+	dataStale = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "data_stale"),
+		"Whether the AdGuard metrics are the last good collection's (1), served while collecting fails, or current (0).",
+		nil,
+	)
+	dataAge = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "data_age_seconds"),
+		"Age of the served AdGuard metrics, 0 when they're current.",
+		nil,
+	)
+)
+
+// collectOrStale runs the API collectors and keeps their metrics when they
+// succeed. When they fail, the kept metrics are served instead until they're
+// older than StaleMaxAge, with the collector outcomes of the failed run and
+// data_stale 1. up stays 0 either way.
+func (e *Exporter) collectOrStale(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var err error
+	metrics := collectMetrics(func(ch chan<- prometheus.Metric) { err = e.collect(ctx, ch) })
+
+	now := time.Now()
+	if err == nil {
+		e.lastGood.store(metrics, now)
+		for _, m := range metrics {
+			ch <- m
+		}
+		ch <- prometheus.MustNewConstMetric(dataStale, prometheus.GaugeValue, 0)
+		ch <- prometheus.MustNewConstMetric(dataAge, prometheus.GaugeValue, 0)
+		return nil
+	}
+
+	good, taken := e.lastGood.load()
+	if taken.IsZero() || now.Sub(taken) > e.StaleMaxAge {
+		for _, m := range metrics {
+			ch <- m
+		}
+		ch <- prometheus.MustNewConstMetric(dataStale, prometheus.GaugeValue, 0)
+		return err
+	}
+
+	// the failed run's own metrics are partial, only its outcomes are kept
+	for _, m := range good {
+		if !collectorOutcome(m) {
+			ch <- m
+		}
+	}
+	for _, m := range metrics {
+		if collectorOutcome(m) {
+			ch <- m
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(dataStale, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(dataAge, prometheus.GaugeValue, now.Sub(taken).Seconds())
+	return err
+}
+
+// collectorOutcome tells whether m is the success or duration of a collector.
+func collectorOutcome(m prometheus.Metric) bool {
+	return m.Desc() == collectorSuccess || m.Desc() == collectorDuration
+}
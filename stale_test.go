@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleMaxAge(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	const maxAge = 500 * time.Millisecond
+	e.StaleMaxAge = maxAge
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	// scrape returns the exposition and the value of each unlabeled series
+	scrape := func() (string, map[string]float64) {
+		t.Helper()
+
+		body := exposition(t, registry)
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := map[string]float64{}
+		for name, mf := range families {
+			if m := mf.GetMetric(); len(m) == 1 && len(m[0].GetLabel()) == 0 {
+				values[name] = m[0].GetGauge().GetValue()
+			}
+		}
+		return body, values
+	}
+
+	body, values := scrape()
+	if values["adguardhome_data_stale"] != 0 || values["adguardhome_data_age_seconds"] != 0 || values["adguardhome_dns_queries"] != 100 {
+		t.Fatalf("got\n%s\nwant current data", body)
+	}
+
+	// an outage shorter than the max age
+	stub.fail("/control/stats", http.StatusBadGateway)
+	time.Sleep(maxAge / 5)
+	body, values = scrape()
+	if values["adguardhome_data_stale"] != 1 || values["adguardhome_dns_queries"] != 100 || values["adguardhome_up"] != 0 {
+		t.Errorf("got\n%s\nwant the last good data flagged stale", body)
+	}
+	if age := values["adguardhome_data_age_seconds"]; age < (maxAge/5).Seconds() || age > maxAge.Seconds() {
+		t.Errorf("got data age %v, want the age of the last good collection", age)
+	}
+	if !strings.Contains(body, `adguardhome_collector_success{collector="stats"} 0`) {
+		t.Errorf("got\n%s\nwant the failed collection reported", body)
+	}
+
+	stub.fail("/control/stats", 0)
+	if body, values = scrape(); values["adguardhome_data_stale"] != 0 || values["adguardhome_data_age_seconds"] != 0 {
+		t.Errorf("got\n%s\nwant current data after the outage", body)
+	}
+
+	// an outage longer than the max age
+	stub.fail("/control/stats", http.StatusBadGateway)
+	time.Sleep(maxAge + 100*time.Millisecond)
+	body, values = scrape()
+	if _, ok := values["adguardhome_dns_queries"]; ok || values["adguardhome_data_stale"] != 0 || values["adguardhome_up"] != 0 {
+		t.Errorf("got\n%s\nwant no AdGuard metrics past the max age", body)
+	}
+	if _, ok := values["adguardhome_data_age_seconds"]; ok {
+		t.Errorf("got\n%s\nwant no data age without data", body)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// queryLogState is the part of the query log state persisted across restarts.
+type queryLogState struct {
+	Endpoint         string                    `json:"endpoint"`
+	Cursor           time.Time                 `json:"cursor"`
+	Buckets          []float64                 `json:"buckets"`
+	Duration         histogramState            `json:"duration"`
+	UpstreamDuration map[string]histogramState `json:"upstream_duration"`
+	DomainQueries    map[string]uint64         `json:"domain_queries"`
+	ClientQueries    map[string]uint64         `json:"client_queries"`
+	ProtocolQueries  map[string]uint64         `json:"protocol_queries"`
+	ECSQueries       uint64                    `json:"ecs_queries"`
+	QueryErrors      uint64                    `json:"query_errors"`
+	IgnoredEntries   uint64                    `json:"ignored_entries"`
+	Dropped          map[string]uint64         `json:"label_values_dropped"`
+}
+
+type histogramState struct {
+	Counts []uint64 `json:"counts"`
+	Count  uint64   `json:"count"`
+	Sum    float64  `json:"sum"`
+}
+
+func (h *histogram) state() histogramState {
+	return histogramState{
+		Counts: slices.Clone(h.counts),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+}
+
+func histogramFromState(buckets []float64, s histogramState) (*histogram, error) {
+	if len(s.Counts) != len(buckets) {
+		return nil, fmt.Errorf("histogram has %d buckets, expected %d", len(s.Counts), len(buckets))
+	}
+
+	return &histogram{
+		buckets: buckets,
+		counts:  s.Counts,
+		count:   s.Count,
+		sum:     s.Sum,
+	}, nil
+}
+
+// SaveState writes the cursor and the accumulated histograms to path.
+func (q *QueryLog) SaveState(path, endpoint string) error {
+	q.mu.Lock()
+	state := queryLogState{
+		Endpoint:         endpoint,
+		Cursor:           q.cursor,
+		Buckets:          q.Buckets,
+		Duration:         q.duration.state(),
+		UpstreamDuration: make(map[string]histogramState, len(q.upstreamDuration)),
+		DomainQueries:    maps.Clone(q.domainQueries),
+		ClientQueries:    maps.Clone(q.clientQueries),
+		ProtocolQueries:  maps.Clone(q.protocolQueries),
+		ECSQueries:       q.ecsQueries,
+		QueryErrors:      q.queryErrors,
+		IgnoredEntries:   q.ignoredEntries,
+		Dropped: map[string]uint64{
+			"domain":  q.domainLimit.dropped,
+			"client":  q.clientLimit.dropped,
+			"address": q.upstreamLimit.dropped,
+		},
+	}
+	for address, h := range q.upstreamDuration {
+		state.UpstreamDuration[address] = h.state()
+	}
+	q.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// LoadState restores the state saved by SaveState. A missing file isn't an
+// error, a state saved for another endpoint or other buckets is.
+func (q *QueryLog) LoadState(path, endpoint string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state queryLogState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("corrupt state file %v: %w", path, err)
+	}
+
+	if state.Endpoint != endpoint {
+		return fmt.Errorf("state file %v belongs to %v", path, state.Endpoint)
+	}
+	if !slices.Equal(state.Buckets, q.Buckets) {
+		return fmt.Errorf("state file %v was saved with different buckets", path)
+	}
+
+	duration, err := histogramFromState(q.Buckets, state.Duration)
+	if err != nil {
+		return fmt.Errorf("corrupt state file %v: %w", path, err)
+	}
+	upstreamDuration := make(map[string]*histogram, len(state.UpstreamDuration))
+	for address, s := range state.UpstreamDuration {
+		h, err := histogramFromState(q.Buckets, s)
+		if err != nil {
+			return fmt.Errorf("corrupt state file %v: %w", path, err)
+		}
+		upstreamDuration[address] = h
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reset()
+	q.cursor = state.Cursor
+	q.duration = duration
+	q.upstreamDuration = upstreamDuration
+	for domain, v := range state.DomainQueries {
+		q.domainQueries[domain] = v
+	}
+	for client, v := range state.ClientQueries {
+		q.clientQueries[client] = v
+	}
+	for protocol, v := range state.ProtocolQueries {
+		q.protocolQueries[protocol] = v
+	}
+	q.ecsQueries = state.ECSQueries
+	q.queryErrors = state.QueryErrors
+	q.ignoredEntries = state.IgnoredEntries
+	q.domainLimit.dropped = state.Dropped["domain"]
+	q.clientLimit.dropped = state.Dropped["client"]
+	q.upstreamLimit.dropped = state.Dropped["address"]
+	q.restored = true
+
+	return nil
+}
+
+// writeFileAtomic replaces path with data via a temporary file, so a crash
+// never leaves a partially written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
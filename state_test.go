@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryLogStateResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now()
+
+	q := NewQueryLog(1000, []float64{0.01})
+	q.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+	log := queries(now.Add(-time.Minute), "10.0.0.1", "a.example", "b.example")
+	q.Update(append(log, queries(now.Add(-time.Hour), "10.0.0.1", "old.example")...))
+	if err := q.SaveState(path, "adguard:3000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// after the restart the log has grown by one entry
+	restarted := NewQueryLog(1000, []float64{0.01})
+	if err := restarted.LoadState(path, "adguard:3000"); err != nil {
+		t.Fatal(err)
+	}
+	restarted.Update(append(queries(now, "10.0.0.1", "a.example"), log...))
+	if got := restarted.domainQueries; got["a.example"] != 2 || got["b.example"] != 1 || got["old.example"] != 0 {
+		t.Errorf("got domain counts %v, want the saved ones plus the new entry", got)
+	}
+	if restarted.duration.count != 3 {
+		t.Errorf("got %d queries in the histogram, want 3", restarted.duration.count)
+	}
+}
+
+func TestQueryLogStateInvalid(t *testing.T) {
+	dir := t.TempDir()
+	saved := NewQueryLog(1000, []float64{0.01})
+	now := time.Now()
+	saved.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+	saved.Update(queries(now, "10.0.0.1", "a.example"))
+	valid := filepath.Join(dir, "state.json")
+	if err := saved.SaveState(valid, "adguard:3000"); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte(`{"endpoint": "adguard:3000", "cursor": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, path, endpoint string
+		buckets              []float64
+		wantErr              string
+	}{
+		{"corrupt", corrupt, "adguard:3000", []float64{0.01}, "corrupt state file"},
+		{"other instance", valid, "adguard2:3000", []float64{0.01}, "belongs to adguard:3000"},
+		{"other buckets", valid, "adguard:3000", []float64{0.01, 0.1}, "different buckets"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewQueryLog(1000, tc.buckets)
+			err := q.LoadState(tc.path, tc.endpoint)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+			// the query log starts fresh, positioning on the first update
+			q.Update(queries(now, "10.0.0.1", "a.example"))
+			if len(q.domainQueries) != 0 || !q.cursor.Equal(now) {
+				t.Errorf("got counts %v and cursor %v, want a fresh start", q.domainQueries, q.cursor)
+			}
+		})
+	}
+
+	q := NewQueryLog(1000, []float64{0.01})
+	if err := q.LoadState(filepath.Join(dir, "missing.json"), "adguard:3000"); err != nil {
+		t.Errorf("missing file: got %v, want no error", err)
+	}
+}
+
+func TestQueryLogStateReplacedInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now()
+	saved := NewQueryLog(1000, []float64{0.01})
+	saved.Update(queries(now.Add(-time.Hour), "10.0.0.1", "old.example"))
+	saved.Update(queries(now, "10.0.0.1", "a.example"))
+	if err := saved.SaveState(path, "adguard:3000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// AdGuard was reinstalled behind the same endpoint, its log is older
+	// than the cursor
+	q := NewQueryLog(1000, []float64{0.01})
+	if err := q.LoadState(path, "adguard:3000"); err != nil {
+		t.Fatal(err)
+	}
+	q.Update(queries(now.Add(-time.Minute), "10.0.0.2", "b.example"))
+	if len(q.domainQueries) != 0 || q.duration.count != 0 {
+		t.Errorf("got counts %v, want the saved state dropped", q.domainQueries)
+	}
+	q.Update(queries(now.Add(time.Second), "10.0.0.2", "c.example"))
+	if got := q.domainQueries; len(got) != 1 || got["c.example"] != 1 {
+		t.Errorf("got counts %v, want c.example counted after the fresh start", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+var (
+	uptime = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "uptime_seconds"),
+		"Time since AdGuard Home started (in seconds), estimated from the last stats reset without start_time.",
+		nil,
+	)
+	protectionEnabled = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "protection_enabled"),
+		"Whether DNS protection is enabled (1) or not (0).",
+		nil,
+	)
+	protectionLastEnabled = newDesc(gaugeMetric,
+		prometheus.BuildFQName(namespace, "", "protection_last_enabled_timestamp_seconds"),
+		"When protection was last observed turning back on (unix time).",
+		nil,
+	)
+)
+
+type StatusResponse struct {
+	Version           string `json:"version"`
+	Running           bool   `json:"running"`
+	ProtectionEnabled bool   `json:"protection_enabled"`
+
+	// only set while protection is temporarily disabled
+	ProtectionDisabledUntil string `json:"protection_disabled_until"`
+
+	// unix time in milliseconds, only reported by newer AdGuard versions
+	StartTime *float64 `json:"start_time"`
+}
+
+// Status tracks /control/status between scrapes. AdGuard doesn't report when
+// protection was re-enabled, so it's derived from the transitions observed.
+// Older versions don't report their start time either, it's then estimated
+// from the last stats reset observed.
+type Status struct {
+	mu            sync.Mutex
+	known         bool
+	enabled       bool
+	lastSeen      time.Time
+	disabledUntil time.Time
+	lastEnabled   time.Time
+	version       string
+
+	queries    int
+	statsSeen  bool
+	statsReset time.Time
+}
+
+func (s *Status) Describe(ch chan<- *prometheus.Desc) {
+	ch <- protectionEnabled
+	ch <- protectionLastEnabled
+	ch <- uptime
+}
+
+func (s *Status) Update(res StatusResponse, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.known && !s.enabled && res.ProtectionEnabled {
+		s.lastEnabled = now
+		// a temporary disable ending between two scrapes ended at its deadline
+		if !s.disabledUntil.IsZero() && s.disabledUntil.After(s.lastSeen) && s.disabledUntil.Before(now) {
+			s.lastEnabled = s.disabledUntil
+		}
+	}
+
+	s.known = true
+	s.enabled = res.ProtectionEnabled
+	s.version = res.Version
+	s.lastSeen = now
+	s.disabledUntil = time.Time{}
+	if until, err := time.Parse(time.RFC3339, res.ProtectionDisabledUntil); err == nil {
+		s.disabledUntil = until
+	}
+}
+
+// ObserveStats records the number of queries in the stats window. Falling
+// by more than half between two collections is taken for a stats reset, the
+// window moving on drops the oldest interval only.
+func (s *Status) ObserveStats(queries int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.statsSeen && queries < s.queries/2 {
+		s.statsReset = now
+	}
+	s.statsSeen = true
+	s.queries = queries
+}
+
+// Version returns the AdGuard version last reported, empty before.
+func (s *Status) Version() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version
+}
+
+func (s *Status) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		protectionEnabled, prometheus.GaugeValue, boolToFloat(s.enabled),
+	)
+
+	if !s.lastEnabled.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			protectionLastEnabled, prometheus.GaugeValue, float64(s.lastEnabled.Unix()),
+		)
+	}
+}
+
+// start returns when AdGuard started, the reported start time or else the
+// last stats reset observed, and false if neither is known.
+func (s *Status) start(res StatusResponse) (time.Time, bool) {
+	if res.StartTime != nil {
+		return time.UnixMilli(int64(*res.StartTime)), true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.statsReset, !s.statsReset.IsZero()
+}
+
+func (e *Exporter) CollectFromStatus(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return e.collectStatus(ctx, ch, e.currentStatus())
+}
+
+// collectStatus collects /control/status into status.
+func (e *Exporter) collectStatus(ctx context.Context, ch chan<- prometheus.Metric, status *Status) error {
+	var res StatusResponse
+	if err := e.get(ctx, "/control/status", &res); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	status.Update(res, now)
+	e.last.setStatus(res)
+	status.Collect(ch)
+
+	if start, ok := status.start(res); ok {
+		ch <- prometheus.MustNewConstMetric(
+			uptime, prometheus.GaugeValue, now.Sub(start).Seconds(),
+		)
+	}
+
+	return nil
+}
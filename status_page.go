@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusPageTop is the number of entries shown per top list.
+const statusPageTop = 10
+
+// lastCollection keeps the latest responses for the /status page, so the
+// page never has to ask AdGuard itself.
+type lastCollection struct {
+	mu        sync.Mutex
+	stats     *Response
+	statsTime time.Time
+	status    *StatusResponse
+}
+
+func (l *lastCollection) setStats(res Response, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats, l.statsTime = &res, now
+}
+
+func (l *lastCollection) setStatus(res StatusResponse) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status = &res
+}
+
+type statusPageEntry struct {
+	Name  string
+	Count int
+}
+
+type statusPageTable struct {
+	Title   string
+	Entries []statusPageEntry
+}
+
+type statusPageData struct {
+	Version    string
+	Collected  bool
+	Age        time.Duration
+	Queries    int
+	Blocked    int
+	Ratio      string
+	Protection string
+	Tables     []statusPageTable
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>AdGuard Home Exporter</title></head>
+<body>
+<h1>AdGuard Home</h1>
+{{- if not .Collected}}
+<p>No successful collection yet.</p>
+{{- else}}
+<p>Last collection {{.Age}} ago, exporter version {{.Version}}.</p>
+<table>
+<tr><td>Queries</td><td>{{.Queries}}</td></tr>
+<tr><td>Blocked</td><td>{{.Blocked}} ({{.Ratio}})</td></tr>
+<tr><td>Protection</td><td>{{.Protection}}</td></tr>
+</table>
+{{- range .Tables}}
+<h2>{{.Title}}</h2>
+<table>
+{{- range .Entries}}
+<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{- else}}
+<tr><td>none</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// topEntries flattens an AdGuard top list, keeping its order, without the
+// excluded entries and at most statusPageTop of them.
+func topEntries(top []map[string]int, exclude func(string) bool) []statusPageEntry {
+	var entries []statusPageEntry
+	for _, i := range top {
+		for k, v := range i {
+			if !exclude(k) && len(entries) < statusPageTop {
+				entries = append(entries, statusPageEntry{k, v})
+			}
+		}
+	}
+	return entries
+}
+
+// statusPage returns what the /status page shows from the last collection.
+func (e *Exporter) statusPage(now time.Time) statusPageData {
+	e.last.mu.Lock()
+	defer e.last.mu.Unlock()
+
+	data := statusPageData{Version: version, Protection: "not collected, see -collector.status"}
+	if e.last.status != nil {
+		data.Protection = "off"
+		if e.last.status.ProtectionEnabled {
+			data.Protection = "on"
+		}
+	}
+
+	res := e.last.stats
+	if res == nil {
+		return data
+	}
+
+	data.Collected = true
+	data.Age = now.Sub(e.last.statsTime).Round(time.Second)
+	data.Queries = res.AllDNSQueries
+	data.Blocked = res.BlockedDNSQueries
+	data.Ratio = "0%"
+	if res.AllDNSQueries > 0 {
+		data.Ratio = fmt.Sprintf("%.1f%%", 100*float64(res.BlockedDNSQueries)/float64(res.AllDNSQueries))
+	}
+
+	excludeClient := func(client string) bool { return matchClient(e.ExcludeClients, client) }
+	excludeDomain := func(domain string) bool { return matchDomain(e.ExcludeDomains, domain) }
+	excludeNone := func(string) bool { return false }
+
+	upstreams := topEntries(res.UpstreamResponses, excludeNone)
+	sort.SliceStable(upstreams, func(i, j int) bool {
+		return upstreams[i].Count > upstreams[j].Count
+	})
+
+	data.Tables = []statusPageTable{
+		{"Top queried domains", topEntries(res.TopQueriedDomains, excludeDomain)},
+		{"Top blocked domains", topEntries(res.TopBlockedDomains, excludeDomain)},
+		{"Top clients", topEntries(res.TopClients, excludeClient)},
+		{"Upstreams", upstreams},
+	}
+
+	return data
+}
+
+// StatusPageHandler serves a summary of the last collection. It never
+// collects, so it's cheap and shows nothing before the first collection.
+// The target parameter picks one of several exporters, the first by default.
+func StatusPageHandler(exporters func() []*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		e := selectExporter(exporters(), target, true)
+		if e == nil {
+			http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusPage.Execute(w, e.statusPage(time.Now()))
+	})
+}
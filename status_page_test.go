@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+// golden compares got with the golden file name in testdata, or writes it
+// with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%s\nwant %v:\n%s", got, path, want)
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	stub := newAdGuardStub(t)
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Status = &Status{}
+	e.ExcludeClients = []string{"192.168.1.2"}
+	render := func() []byte {
+		var b bytes.Buffer
+		if err := statusPage.Execute(&b, e.statusPage(time.Unix(1792137690, 0))); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	golden(t, "status_page_empty.html", render())
+
+	collectMetrics(func(ch chan<- prometheus.Metric) {
+		if err := e.collect(t.Context(), ch); err != nil {
+			t.Error(err)
+		}
+	})
+	e.last.statsTime = time.Unix(1792137600, 0)
+	golden(t, "status_page.html", render())
+}
+
+func TestStatusPageHandler(t *testing.T) {
+	stub := newAdGuardStub(t)
+	base := runExporter(t, "-endpoint", stub.URL, "-metrics-token", "secrettoken")
+	get := func(path, authorization string) (int, string) {
+		t.Helper()
+
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if status, _ := get("/status", ""); status != http.StatusUnauthorized {
+		t.Errorf("without the token: got %d, want 401", status)
+	}
+	status, body := get("/status", "Bearer secrettoken")
+	if status != http.StatusOK || !strings.Contains(body, "No successful collection yet.") {
+		t.Errorf("got %d:\n%s\nwant the page before the first collection", status, body)
+	}
+	if n := stub.count("/control/stats"); n != 0 {
+		t.Errorf("got %d requests to AdGuard, want the page never to collect", n)
+	}
+
+	get("/metrics", "Bearer secrettoken")
+	status, body = get("/status", "Bearer secrettoken")
+	if status != http.StatusOK || !strings.Contains(body, "<tr><td>Queries</td><td>100</td></tr>") {
+		t.Errorf("got %d:\n%s\nwant the last collection", status, body)
+	}
+	if n := stub.count("/control/stats"); n != 1 {
+		t.Errorf("got %d requests to AdGuard, want only the scrape's", n)
+	}
+}
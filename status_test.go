@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"math"
+	"testing"
+	"time"
+)
+
+// collectUptime runs the stats and status collections of e and returns the
+// uptime exported, false without one.
+func collectUptime(t *testing.T, e *Exporter) (float64, bool) {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectorFunc(func(ch chan<- prometheus.Metric) {
+		if err := e.collect(t.Context(), ch, "stats", "status"); err != nil {
+			t.Error(err)
+		}
+	}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "adguardhome_uptime_seconds" {
+			return family.GetMetric()[0].GetGauge().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func TestUptimeFromStartTime(t *testing.T) {
+	stub := newAdGuardStub(t)
+	start := time.Now().Add(-90 * time.Minute)
+	stub.set("/control/status", map[string]any{
+		"version":            "v0.107.52",
+		"start_time":         start.UnixMilli(),
+		"protection_enabled": true,
+	})
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Status = &Status{}
+
+	got, ok := collectUptime(t, e)
+	if want := time.Since(start).Seconds(); !ok || math.Abs(got-want) > 5 {
+		t.Errorf("got uptime %v (%v), want about %v", got, ok, want)
+	}
+}
+
+func TestUptimeFromStatsReset(t *testing.T) {
+	stub := newAdGuardStub(t)
+	stub.set("/control/status", map[string]any{"version": "v0.107.20", "protection_enabled": true})
+	stats := func(queries int) map[string]any {
+		return map[string]any{"num_dns_queries": queries, "num_blocked_filtering": 0, "avg_processing_time": 0.01}
+	}
+	e := NewExporter(stub.endpoint(), "", "")
+	e.Status = &Status{}
+
+	for _, queries := range []int{1000, 1200, 900} {
+		stub.set("/control/stats", stats(queries))
+		// the window moving on isn't a reset
+		if got, ok := collectUptime(t, e); ok {
+			t.Fatalf("%d queries: got uptime %v before a reset", queries, got)
+		}
+	}
+
+	stub.set("/control/stats", stats(3))
+	got, ok := collectUptime(t, e)
+	if !ok || got < 0 || got > 5 {
+		t.Fatalf("got uptime %v (%v) right after a reset, want about 0", got, ok)
+	}
+	stub.set("/control/stats", stats(50))
+	if again, ok := collectUptime(t, e); !ok || again < got {
+		t.Errorf("got uptime %v (%v) after %v, want it to keep growing", again, ok, got)
+	}
+}
+
+func TestProtectionLastEnabled(t *testing.T) {
+	const name = "adguardhome_protection_last_enabled_timestamp_seconds"
+	s := &Status{}
+	t0 := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	// protection found on isn't a transition
+	s.Update(StatusResponse{ProtectionEnabled: true}, t0)
+	if n := testutil.CollectAndCount(s, name); n != 0 {
+		t.Fatalf("got %d series before a transition, want none", n)
+	}
+
+	s.Update(StatusResponse{ProtectionEnabled: false}, t0.Add(time.Minute))
+	s.Update(StatusResponse{ProtectionEnabled: true}, t0.Add(2*time.Minute))
+	if got := testutil.ToFloat64(collectorOf(s, name)); got != float64(t0.Add(2*time.Minute).Unix()) {
+		t.Errorf("got %v after re-enabling, want the time of the scrape", got)
+	}
+
+	// a temporary disable ending between two scrapes ended at its deadline
+	until := t0.Add(10*time.Minute + 30*time.Second)
+	s.Update(StatusResponse{ProtectionEnabled: false, ProtectionDisabledUntil: until.Format(time.RFC3339)}, t0.Add(10*time.Minute))
+	s.Update(StatusResponse{ProtectionEnabled: true}, t0.Add(11*time.Minute))
+	if got := testutil.ToFloat64(collectorOf(s, name)); got != float64(until.Unix()) {
+		t.Errorf("got %v after a temporary disable, want its deadline %v", got, until.Unix())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// notifySystemd sends a state to systemd, a no-op outside a Type=notify unit.
+func notifySystemd(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		slog.Warn(fmt.Sprintf("Unable to notify systemd: %v", err))
+	}
+}
+
+// RunWatchdog pings the systemd watchdog at half its interval until ctx is
+// done, as long as collections make progress. Collections of every exporter
+// stuck for longer than the interval stop the pings and systemd restarts the
+// exporter. It returns right away when the watchdog isn't enabled.
+func RunWatchdog(ctx context.Context, exporters func() []*Exporter) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Invalid systemd watchdog settings: %v", err))
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if allStuck(exporters(), interval, now) {
+				slog.Warn(fmt.Sprintf("Collection stuck for more than %v, skipping watchdog ping", interval))
+				continue
+			}
+			notifySystemd(daemon.SdNotifyWatchdog)
+		}
+	}
+}
+
+// listeners returns the sockets passed by systemd socket activation, or a
+// listener on address when there are none. An address like
+// unix:///run/exporter.sock listens on a Unix socket with socketMode, the
+// socket is removed again when the listener is closed.
+func listeners(address string, socketMode os.FileMode) ([]net.Listener, error) {
+	ls, err := activation.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(ls) > 0 {
+		return ls, nil
+	}
+
+	path, unix := strings.CutPrefix(address, "unix://")
+	if !unix {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	// a socket left behind by a crash would fail the listen
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return []net.Listener{l}, nil
+}
+
+// allStuck tells whether the collections of every exporter are stuck for
+// longer than interval. Without exporters, as while discovery finds none,
+// nothing is stuck.
+func allStuck(exporters []*Exporter, interval time.Duration, now time.Time) bool {
+	if len(exporters) == 0 {
+		return false
+	}
+	for _, e := range exporters {
+		if !e.health.stuck(interval, now) {
+			return false
+		}
+	}
+	return true
+}
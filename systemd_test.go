@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket points NOTIFY_SOCKET at a socket of the test and returns
+// the messages sent to it.
+func fakeNotifySocket(t *testing.T) <-chan string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	messages := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+func receive(t *testing.T, messages <-chan string, timeout time.Duration) (string, bool) {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		return msg, true
+	case <-time.After(timeout):
+		return "", false
+	}
+}
+
+func TestNotifySystemd(t *testing.T) {
+	messages := fakeNotifySocket(t)
+	notifySystemd(daemon.SdNotifyReady)
+	if msg, ok := receive(t, messages, time.Second); msg != daemon.SdNotifyReady {
+		t.Errorf("got %q (received: %v), want %q", msg, ok, daemon.SdNotifyReady)
+	}
+
+	// outside systemd it does nothing
+	t.Setenv("NOTIFY_SOCKET", "")
+	notifySystemd(daemon.SdNotifyStopping)
+}
+
+func TestRunWatchdog(t *testing.T) {
+	messages := fakeNotifySocket(t)
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("WATCHDOG_USEC", strconv.Itoa(int(100*time.Millisecond/time.Microsecond)))
+
+	e := NewExporter("adguard:3000", "", "")
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(ctx, func() []*Exporter { return []*Exporter{e} })
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if msg, ok := receive(t, messages, time.Second); msg != daemon.SdNotifyWatchdog {
+		t.Fatalf("got %q (received: %v), want a watchdog ping", msg, ok)
+	}
+
+	// a collection hanging for longer than the interval stops the pings
+	e.health.start(time.Now())
+	time.Sleep(150 * time.Millisecond)
+	for len(messages) > 0 {
+		<-messages
+	}
+	if msg, ok := receive(t, messages, 200*time.Millisecond); ok {
+		t.Errorf("got %q while the collection is stuck", msg)
+	}
+
+	e.health.record(nil, time.Now())
+	if msg, ok := receive(t, messages, time.Second); msg != daemon.SdNotifyWatchdog {
+		t.Errorf("got %q (received: %v) after the collection finished, want a watchdog ping", msg, ok)
+	}
+}
+
+func TestRunWatchdogDisabled(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(t.Context(), func() []*Exporter { return nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunWatchdog didn't return without a watchdog")
+	}
+}
+
+func TestAllStuck(t *testing.T) {
+	now := time.Now()
+	stuck, idle := NewExporter("a", "", ""), NewExporter("b", "", "")
+	stuck.health.start(now.Add(-time.Minute))
+
+	for _, tc := range []struct {
+		name      string
+		exporters []*Exporter
+		want      bool
+	}{
+		{"none", nil, false},
+		{"idle", []*Exporter{idle}, false},
+		{"stuck", []*Exporter{stuck}, true},
+		{"one stuck", []*Exporter{stuck, idle}, false},
+	} {
+		if got := allStuck(tc.exporters, 30*time.Second, now); got != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	ls, err := listeners("127.0.0.1:0", 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 || ls[0].Addr().Network() != "tcp" {
+		t.Errorf("got listeners %v, want one on TCP", ls)
+	}
+	for _, l := range ls {
+		l.Close()
+	}
+
+	// a socket left behind is replaced
+	path := filepath.Join(t.TempDir(), "exporter.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ls, err = listeners("unix://"+path, 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls[0].Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("got socket mode %v, want 0660", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// webConfig is the web configuration file format of the Prometheus
+// exporter-toolkit (v0.11), as used by node_exporter and blackbox_exporter.
+// Certificates, keys and client CAs are given inline or as files.
+type webConfig struct {
+	TLSServerConfig struct {
+		Cert             string   `yaml:"cert"`
+		Key              string   `yaml:"key"`
+		ClientCA         string   `yaml:"client_ca"`
+		CertFile         string   `yaml:"cert_file"`
+		KeyFile          string   `yaml:"key_file"`
+		ClientAuthType   string   `yaml:"client_auth_type"`
+		ClientCAFile     string   `yaml:"client_ca_file"`
+		CipherSuites     []string `yaml:"cipher_suites"`
+		CurvePreferences []string `yaml:"curve_preferences"`
+		MinVersion       string   `yaml:"min_version"`
+		MaxVersion       string   `yaml:"max_version"`
+		// PreferServerCipherSuites is accepted for compatibility, Go
+		// ignores it and picks the order itself.
+		PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+		ClientAllowedSANs        []string `yaml:"client_allowed_sans"`
+	} `yaml:"tls_server_config"`
+	HTTPServerConfig struct {
+		HTTP2   *bool             `yaml:"http2"`
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"http_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// loadWebConfig reads and validates a web configuration file. Relative file
+// paths are resolved against the directory of the file.
+func loadWebConfig(path string) (*webConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &webConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, err
+	}
+
+	t := &c.TLSServerConfig
+	for _, f := range []*string{&t.CertFile, &t.KeyFile, &t.ClientCAFile} {
+		if *f != "" && !filepath.IsAbs(*f) {
+			*f = filepath.Join(filepath.Dir(path), *f)
+		}
+	}
+
+	for _, pair := range [][3]string{
+		{"cert", t.Cert, t.CertFile},
+		{"key", t.Key, t.KeyFile},
+		{"client_ca", t.ClientCA, t.ClientCAFile},
+	} {
+		if pair[1] != "" && pair[2] != "" {
+			return nil, fmt.Errorf("%[1]v and %[1]v_file can't be set together", pair[0])
+		}
+	}
+	if c.hasTLS() != (t.Key != "" || t.KeyFile != "") {
+		return nil, fmt.Errorf("a certificate and a key must be set together")
+	}
+	if !c.hasTLS() && (t.ClientCA != "" || t.ClientCAFile != "" || t.ClientAuthType != "" || len(t.ClientAllowedSANs) > 0) {
+		return nil, fmt.Errorf("client certificates require a certificate and a key")
+	}
+	if _, ok := clientAuthTypes[t.ClientAuthType]; !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", t.ClientAuthType)
+	}
+	if len(t.ClientAllowedSANs) > 0 && clientAuthTypes[t.ClientAuthType] < tls.VerifyClientCertIfGiven {
+		return nil, fmt.Errorf("client_allowed_sans requires client_auth_type VerifyClientCertIfGiven or RequireAndVerifyClientCert")
+	}
+	for _, v := range []string{t.MinVersion, t.MaxVersion} {
+		if _, ok := tlsVersions[v]; v != "" && !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", v)
+		}
+	}
+	if _, err := cipherSuites(t.CipherSuites); err != nil {
+		return nil, err
+	}
+	for _, name := range t.CurvePreferences {
+		if _, ok := curves[name]; !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid password hash for user %q: %w", user, err)
+		}
+	}
+
+	if c.hasTLS() {
+		if _, err := c.tlsConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// cipherSuites returns the IDs of the named cipher suites, only the secure
+// ones Go offers are known.
+func cipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		i := slices.IndexFunc(tls.CipherSuites(), func(s *tls.CipherSuite) bool { return s.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, tls.CipherSuites()[i].ID)
+	}
+	return ids, nil
+}
+
+// hasTLS tells whether the server certificate is configured.
+func (c *webConfig) hasTLS() bool {
+	return c.TLSServerConfig.Cert != "" || c.TLSServerConfig.CertFile != ""
+}
+
+// readPEM returns inline PEM data, or the contents of file.
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	return os.ReadFile(file)
+}
+
+// certificate loads the server certificate, nil if TLS isn't configured.
+func (c *webConfig) certificate() (*tls.Certificate, error) {
+	t := c.TLSServerConfig
+	if !c.hasTLS() {
+		return nil, nil
+	}
+
+	certPEM, err := readPEM(t.Cert, t.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := readPEM(t.Key, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// verifySANs returns a tls.Config callback accepting only client
+// certificates with one of the allowed DNS names, emails, IPs or URIs.
+func verifySANs(allowed []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		// no chains when an optional certificate wasn't given
+		if len(chains) == 0 {
+			return nil
+		}
+		cert := chains[0][0]
+		sans := slices.Concat(cert.DNSNames, cert.EmailAddresses)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		for _, san := range sans {
+			if slices.Contains(allowed, san) {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate has none of the allowed SANs, found %v", sans)
+	}
+}
+
+// tlsConfig returns the server TLS configuration, nil if TLS isn't configured.
+func (c *webConfig) tlsConfig() (*tls.Config, error) {
+	t := c.TLSServerConfig
+	cert, err := c.certificate()
+	if cert == nil || err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		ClientAuth:   clientAuthTypes[t.ClientAuthType],
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tlsVersions[t.MaxVersion],
+	}
+	if t.MinVersion != "" {
+		config.MinVersion = tlsVersions[t.MinVersion]
+	}
+	if config.CipherSuites, err = cipherSuites(t.CipherSuites); err != nil {
+		return nil, err
+	}
+	for _, name := range t.CurvePreferences {
+		config.CurvePreferences = append(config.CurvePreferences, curves[name])
+	}
+
+	if t.ClientCA != "" || t.ClientCAFile != "" {
+		pem, err := readPEM(t.ClientCA, t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in the client CA")
+		}
+	}
+	if len(t.ClientAllowedSANs) > 0 {
+		config.VerifyPeerCertificate = verifySANs(t.ClientAllowedSANs)
+	}
+
+	return config, nil
+}
+
+// basicAuth returns the configured users, nil if there are none.
+func (c *webConfig) basicAuth() *BasicAuth {
+	if len(c.BasicAuthUsers) == 0 {
+		return nil
+	}
+
+	auth := &BasicAuth{Users: map[string][]byte{}}
+	for user, hash := range c.BasicAuthUsers {
+		auth.Users[user] = []byte(hash)
+	}
+	return auth
+}
+
+// apply configures server with the TLS and HTTP settings. HTTP/2 stays on
+// unless disabled explicitly.
+func (c *webConfig) apply(server *http.Server) error {
+	config, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = config
+
+	if http2 := c.HTTPServerConfig.HTTP2; http2 != nil && !*http2 {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	if headers := c.HTTPServerConfig.Headers; len(headers) > 0 {
+		next := server.Handler
+		if next == nil {
+			next = http.DefaultServeMux
+		}
+		server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range headers {
+				w.Header().Set(k, v)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, PEM encoded.
+type testCert struct {
+	cert, key []byte
+	parsed    *x509.Certificate
+	signer    *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate for localhost signed by ca, self-signed
+// without one.
+func newTestCert(t *testing.T, ca *testCert, dnsNames ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		parent, signer = ca.parsed, ca.signer
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		parsed: parsed,
+		signer: key,
+	}
+}
+
+// writeWebConfig writes a web configuration file with the server
+// certificate next to it as server.crt and server.key.
+func writeWebConfig(t *testing.T, server *testCert, content string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"server.crt": server.cert,
+		"server.key": server.key,
+		"web.yml":    []byte(content),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "web.yml")
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	server := newTestCert(t, nil, "localhost")
+	indent := func(pem []byte) string {
+		return strings.ReplaceAll(strings.TrimSpace(string(pem)), "\n", "\n    ")
+	}
+
+	for _, tc := range []struct {
+		name, content, wantErr string
+	}{
+		{
+			name: "files",
+			content: `
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  min_version: TLS13
+  cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]
+  curve_preferences: [X25519, CurveP256]
+  prefer_server_cipher_suites: true
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: server.crt
+  client_allowed_sans: [prometheus.home.arpa]
+http_server_config:
+  http2: false
+  headers:
+    X-Frame-Options: deny
+`,
+		},
+		{
+			name: "inline",
+			content: `
+tls_server_config:
+  cert: |
+    ` + indent(server.cert) + `
+  key: |
+    ` + indent(server.key) + `
+  client_ca: |
+    ` + indent(server.cert) + `
+`,
+		},
+		{
+			name:    "unknown field",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  ciphers: []\n",
+			wantErr: "field ciphers not found",
+		},
+		{
+			name:    "cert twice",
+			content: "tls_server_config:\n  cert: x\n  cert_file: server.crt\n  key_file: server.key\n",
+			wantErr: "cert and cert_file can't be set together",
+		},
+		{
+			name:    "missing key",
+			content: "tls_server_config:\n  cert_file: server.crt\n",
+			wantErr: "must be set together",
+		},
+		{
+			name:    "client CA without TLS",
+			content: "tls_server_config:\n  client_ca_file: server.crt\n",
+			wantErr: "require a certificate",
+		},
+		{
+			name:    "cipher suite",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  cipher_suites: [TLS_RSA_WITH_RC4_128_SHA]\n",
+			wantErr: "unknown cipher suite",
+		},
+		{
+			name:    "curve",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  curve_preferences: [P-256]\n",
+			wantErr: "unknown curve",
+		},
+		{
+			name:    "SANs without verification",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAnyClientCert\n  client_allowed_sans: [a]\n",
+			wantErr: "client_allowed_sans requires",
+		},
+		{
+			name:    "password hash",
+			content: "basic_auth_users:\n  prometheus: secretpw\n",
+			wantErr: `invalid password hash for user "prometheus"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := loadWebConfig(writeWebConfig(t, server, tc.content))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := c.tlsConfig()
+			if err != nil || config == nil || config.ClientCAs == nil {
+				t.Fatalf("got TLS config %v, %v, want one with client CAs", config, err)
+			}
+		})
+	}
+}
+
+func TestWebConfigBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secretpw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeWebConfig(t, newTestCert(t, nil), "basic_auth_users:\n  prometheus: "+string(hash)+"\n  grafana: "+string(hash)+"\n")
+	c, err := loadWebConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: c.basicAuth().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))}
+	if err := c.apply(server); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		username, password string
+		want               int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"prometheus", "wrongpw", http.StatusUnauthorized},
+		{"nobody", "secretpw", http.StatusUnauthorized},
+		{"prometheus", "secretpw", http.StatusOK},
+		{"grafana", "secretpw", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if tc.username != "" {
+			req.SetBasicAuth(tc.username, tc.password)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("user %q: got %d, want %d", tc.username, res.StatusCode, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("user %q: no WWW-Authenticate challenge", tc.username)
+		}
+	}
+}
+
+func TestWebConfigClientAllowedSANs(t *testing.T) {
+	ca := newTestCert(t, nil)
+	server := newTestCert(t, ca, "localhost")
+	path := writeWebConfig(t, server, `
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+  client_allowed_sans: [prometheus.home.arpa]
+`)
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "ca.crt"), ca.cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadWebConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := c.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = config
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.parsed)
+	for _, tc := range []struct {
+		san  string
+		want bool
+	}{
+		{"prometheus.home.arpa", true},
+		{"grafana.home.arpa", false},
+	} {
+		client := newTestCert(t, ca, tc.san)
+		cert, err := tls.X509KeyPair(client.cert, client.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert},
+		}}}
+		res, err := c.Get(ts.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		if (err == nil) != tc.want {
+			t.Errorf("client with SAN %v: got error %v, want accepted %v", tc.san, err, tc.want)
+		}
+	}
+}